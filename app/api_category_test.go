@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"image_gallery/category"
+)
+
+func TestCategories(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		api.expect(http.StatusBadRequest, "POST", "/categories", map[string]interface{}{"name": ""}, nil)
+		api.expect(http.StatusBadRequest, "POST", "/categories",
+			map[string]interface{}{"name": "nature", "max_upload_size": -1}, nil)
+
+		var created category.Category
+		api.expect(http.StatusOK, "POST", "/categories",
+			map[string]interface{}{"name": "nature", "description": "trees", "strip_metadata": true}, &created)
+		if created.ID == 0 || created.Name != "nature" || !created.StripMetadata {
+			t.Fatalf("unexpected created category %+v", created)
+		}
+		api.createCategory("city")
+
+		var selected category.Category
+		api.expect(http.StatusOK, "GET", path("/categories/%d", created.ID), nil, &selected)
+		if selected.Name != "nature" || selected.Description != "trees" || !selected.StripMetadata {
+			t.Fatalf("unexpected category %+v", selected)
+		}
+
+		api.expect(http.StatusOK, "PUT", path("/categories/%d", created.ID),
+			map[string]interface{}{"name": "landscapes", "description": "hills"}, nil)
+		api.expect(http.StatusOK, "GET", path("/categories/%d", created.ID), nil, &selected)
+		if selected.Name != "landscapes" || selected.Description != "hills" {
+			t.Fatalf("category not updated: %+v", selected)
+		}
+
+		var categories []category.Category
+		api.expect(http.StatusOK, "GET", "/categories", nil, &categories)
+		if len(categories) != 2 {
+			t.Fatalf("got %d categories, want 2", len(categories))
+		}
+
+		api.expect(http.StatusNoContent, "DELETE", path("/categories/%d", created.ID), nil, nil)
+		api.expect(http.StatusNotFound, "GET", path("/categories/%d", created.ID), nil, nil)
+	})
+}
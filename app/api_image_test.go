@@ -0,0 +1,177 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"image_gallery/image"
+)
+
+func TestImages(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		nature, city := api.createCategory("nature"), api.createCategory("city")
+
+		api.expect(http.StatusBadRequest, "POST", "/images",
+			map[string]interface{}{"name": "", "category_id": nature}, nil)
+
+		tree := api.createImage("tree", nature, "green", "Big", "green")
+		if tree.ID == 0 || tree.Slug == "" {
+			t.Fatalf("unexpected created image %+v", tree)
+		}
+		if !reflect.DeepEqual(tree.TagsNames, []string{"green", "Big"}) {
+			t.Fatalf("got tags %v, want [green Big]", tree.TagsNames)
+		}
+		street := api.createImage("street", city, "big")
+
+		var selected image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", tree.ID), nil, &selected)
+		if selected.Name != "tree" || selected.Category == nil || selected.Category.Name != "nature" {
+			t.Fatalf("unexpected image %+v", selected)
+		}
+		sort.Strings(selected.TagsNames)
+		if !reflect.DeepEqual(selected.TagsNames, []string{"Big", "green"}) {
+			t.Fatalf("got tags %v, want [Big green]", selected.TagsNames)
+		}
+		api.expect(http.StatusNotFound, "GET", "/images/999", nil, nil)
+
+		var images []image.Image
+		api.expect(http.StatusOK, "GET", "/images", nil, &images)
+		if len(images) != 2 {
+			t.Fatalf("got %d images, want 2", len(images))
+		}
+
+		api.expect(http.StatusOK, "GET", path("/images?category=%d", city), nil, &images)
+		if len(images) != 1 || images[0].ID != street.ID {
+			t.Fatalf("category filter returned %+v", images)
+		}
+
+		// tags are matched ignoring case, by name or id
+		api.expect(http.StatusOK, "GET", "/images?tag=BIG", nil, &images)
+		if len(images) != 2 {
+			t.Fatalf("tag filter returned %d images, want 2", len(images))
+		}
+		api.expect(http.StatusNotFound, "GET", "/images?tag=blue", nil, nil)
+
+		api.expect(http.StatusOK, "PUT", path("/images/%d", tree.ID), map[string]interface{}{
+			"name": "oak", "description": "an oak", "category_id": nature,
+		}, nil)
+		var updated image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", tree.ID), nil, &updated)
+		if updated.Name != "oak" || updated.Description != "an oak" {
+			t.Fatalf("image not updated: %+v", updated)
+		}
+		// tags are kept when none are sent
+		sort.Strings(updated.TagsNames)
+		if !reflect.DeepEqual(updated.TagsNames, []string{"Big", "green"}) {
+			t.Fatalf("got tags %v, want [Big green]", updated.TagsNames)
+		}
+
+		// the tags sent replace the tags of the image
+		for i := 0; i < 2; i++ {
+			api.expect(http.StatusOK, "PUT", path("/images/%d", tree.ID), map[string]interface{}{
+				"name": "oak", "description": "an oak", "category_id": nature, "tags": []string{"green", "oak"},
+			}, nil)
+		}
+		var retagged image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", tree.ID), nil, &retagged)
+		sort.Strings(retagged.TagsNames)
+		if !reflect.DeepEqual(retagged.TagsNames, []string{"green", "oak"}) {
+			t.Fatalf("got tags %v, want [green oak]", retagged.TagsNames)
+		}
+		api.expect(http.StatusOK, "GET", "/images?tag=big", nil, &images)
+		if len(images) != 1 || images[0].ID != street.ID {
+			t.Fatalf("tag removed from image still matches it: %+v", images)
+		}
+
+		// a soft delete keeps the metadata of the image, a hard delete removes it
+		api.expect(http.StatusNoContent, "DELETE", path("/images/%d", tree.ID), nil, nil)
+		api.expect(http.StatusOK, "GET", path("/images/%d", tree.ID), nil, nil)
+		api.expect(http.StatusNoContent, "DELETE", path("/images/%d?delete_mode=hard", tree.ID), nil, nil)
+		api.expect(http.StatusNotFound, "GET", path("/images/%d", tree.ID), nil, nil)
+	})
+}
+
+func TestSimilarImages(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("nature")
+		upload := func(name string, file []byte) *image.Image {
+			t.Helper()
+
+			fields := map[string]string{"name": name, "description": "d", "category_id": path("%d", categoryID)}
+			status, content := api.upload("/images/upload", fields, file)
+			if status != http.StatusCreated {
+				t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+			}
+			return decodeImage(t, content)
+		}
+
+		sunset := upload("sunset", pngFile(t, 64, 48, color.RGBA{R: 200, A: 255}))
+		// a slightly darker copy of the same picture
+		dusk := upload("dusk", pngFile(t, 64, 48, color.RGBA{R: 190, A: 255}))
+		horizon := upload("horizon", orientedJPEG(t, 64, 48, 1))
+
+		var similar []struct {
+			Distance int          `json:"distance"`
+			Image    *image.Image `json:"image"`
+		}
+		api.expect(http.StatusOK, "GET", path("/images/%d/similar", sunset.ID), nil, &similar)
+		if len(similar) != 1 || similar[0].Image.ID != dusk.ID || similar[0].Distance > 10 {
+			t.Fatalf("got similar images %+v, want dusk only", similar)
+		}
+
+		api.expect(http.StatusOK, "GET", path("/images/%d/similar?threshold=64", sunset.ID), nil, &similar)
+		if len(similar) != 2 || similar[0].Image.ID != dusk.ID || similar[1].Image.ID != horizon.ID {
+			t.Fatalf("got similar images %+v, want dusk then horizon", similar)
+		}
+
+		api.expect(http.StatusBadRequest, "GET", path("/images/%d/similar?threshold=65", sunset.ID), nil, nil)
+		api.expect(http.StatusNotFound, "GET", path("/images/%d/similar", api.createImage("tree", categoryID).ID),
+			nil, nil)
+	})
+}
+
+func TestImagesByColor(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("nature")
+		for name, c := range map[string]color.Color{
+			"sunset": color.RGBA{R: 200, A: 255},
+			"sea":    color.RGBA{B: 200, A: 255},
+		} {
+			fields := map[string]string{"name": name, "description": "d", "category_id": path("%d", categoryID)}
+			if status, content := api.upload("/images/upload", fields, pngFile(t, 64, 48, c)); status != http.StatusCreated {
+				t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+			}
+		}
+
+		tests := []struct {
+			query string
+			want  []string
+		}{
+			{query: "color=%23c80000", want: []string{"sunset"}},
+			{query: "color=d20000&tolerance=5", want: []string{"sunset"}},
+			{query: "color=%2300c", want: []string{"sea"}},
+			// a quarter of both files is white
+			{query: "color=%23fff", want: []string{"sea", "sunset"}},
+		}
+		for _, test := range tests {
+			var images []image.Image
+			api.expect(http.StatusOK, "GET", "/images?"+test.query, nil, &images)
+
+			var names []string
+			for _, listed := range images {
+				names = append(names, listed.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.want) {
+				t.Errorf("%s: got images %v, want %v", test.query, names, test.want)
+			}
+		}
+
+		api.expect(http.StatusNotFound, "GET", "/images?color=%2300ff00", nil, nil)
+		api.expect(http.StatusBadRequest, "GET", "/images?color=red", nil, nil)
+		api.expect(http.StatusBadRequest, "GET", "/images?color=%23ff0000&tolerance=101", nil, nil)
+	})
+}
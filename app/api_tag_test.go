@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"image_gallery/image"
+	cLog "image_gallery/logger"
+	"image_gallery/memory"
+	"image_gallery/router"
+	"image_gallery/tag"
+)
+
+// tagNames returns the names of tags
+func tagNames(tags []tag.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+
+	return names
+}
+
+func TestTags(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("animals")
+		api.createImage("rex", categoryID, "dog", "brown")
+		api.createImage("felix", categoryID, "cat")
+
+		var tags []tag.Tag
+		api.expect(http.StatusOK, "GET", "/tags", nil, &tags)
+		if !reflect.DeepEqual(tagNames(tags), []string{"brown", "cat", "dog"}) {
+			t.Fatalf("got tags %v", tagNames(tags))
+		}
+		if tags[0].ImageCount != 1 {
+			t.Fatalf("got image count %d, want 1", tags[0].ImageCount)
+		}
+
+		var created tag.Tag
+		api.expect(http.StatusOK, "POST", "/tags", map[string]interface{}{"name": "  bird  "}, &created)
+		if created.Name != "bird" {
+			t.Fatalf("tag name not trimmed: %q", created.Name)
+		}
+		api.expect(http.StatusConflict, "POST", "/tags", map[string]interface{}{"name": "Bird"}, nil)
+		api.expect(http.StatusBadRequest, "POST", "/tags", map[string]interface{}{"name": "   "}, nil)
+
+		var renamed tag.Tag
+		api.expect(http.StatusOK, "PUT", path("/tags/%d", created.ID), map[string]interface{}{"name": "Birds"}, &renamed)
+		if renamed.Name != "Birds" {
+			t.Fatalf("tag not renamed: %+v", renamed)
+		}
+		api.expect(http.StatusConflict, "PUT", path("/tags/%d", created.ID), map[string]interface{}{"name": "cat"}, nil)
+		api.expect(http.StatusNotFound, "PUT", "/tags/999", map[string]interface{}{"name": "fish"}, nil)
+
+		var selected tag.Tag
+		api.expect(http.StatusOK, "GET", path("/tags/%d", created.ID), nil, &selected)
+		if selected.Name != "Birds" {
+			t.Fatalf("unexpected tag %+v", selected)
+		}
+		api.expect(http.StatusBadRequest, "GET", "/tags/bird", nil, nil)
+
+		api.expect(http.StatusNoContent, "DELETE", path("/tags/%d", created.ID), nil, nil)
+		api.expect(http.StatusNotFound, "GET", path("/tags/%d", created.ID), nil, nil)
+		api.expect(http.StatusNotFound, "DELETE", path("/tags/%d", created.ID), nil, nil)
+	})
+}
+
+func TestTagMergeAndAliases(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("vehicles")
+		first := api.createImage("first", categoryID, "car", "red")
+		api.createImage("second", categoryID, "cars")
+		api.createImage("third", categoryID, "automobile", "car")
+
+		var car, cars, automobile tag.Tag
+		api.expect(http.StatusOK, "GET", path("/tags/%d", 1), nil, &car)
+		api.expect(http.StatusOK, "GET", path("/tags/%d", 3), nil, &cars)
+		api.expect(http.StatusOK, "GET", path("/tags/%d", 4), nil, &automobile)
+
+		// nothing is merged when a tag does not exist
+		api.expect(http.StatusNotFound, "POST", path("/tags/%d/merge", car.ID),
+			map[string]interface{}{"tags": []int64{cars.ID, 999}}, nil)
+		api.expect(http.StatusOK, "GET", path("/tags/%d", cars.ID), nil, nil)
+
+		api.expect(http.StatusNotFound, "POST", "/tags/999/merge", map[string]interface{}{"tags": []int64{cars.ID}}, nil)
+		api.expect(http.StatusBadRequest, "POST", path("/tags/%d/merge", car.ID),
+			map[string]interface{}{"tags": []int64{cars.ID, cars.ID}}, nil)
+		api.expect(http.StatusBadRequest, "POST", path("/tags/%d/merge", car.ID),
+			map[string]interface{}{"tags": []int64{cars.ID, car.ID}}, nil)
+		api.expect(http.StatusBadRequest, "POST", path("/tags/%d/merge", car.ID), map[string]interface{}{"tags": nil}, nil)
+
+		var merged tag.Tag
+		api.expect(http.StatusOK, "POST", path("/tags/%d/merge", car.ID),
+			map[string]interface{}{"tags": []int64{cars.ID, automobile.ID}}, &merged)
+		if merged.ImageCount != 3 || !reflect.DeepEqual(merged.Aliases, []string{"automobile", "cars"}) {
+			t.Fatalf("unexpected merged tag %+v", merged)
+		}
+		api.expect(http.StatusNotFound, "GET", path("/tags/%d", cars.ID), nil, nil)
+
+		// aliases are resolved to their tag
+		var images []image.Image
+		api.expect(http.StatusOK, "GET", "/images?tag=Cars", nil, &images)
+		if len(images) != 3 {
+			t.Fatalf("got %d images of cars, want 3", len(images))
+		}
+		created := api.createImage("fourth", categoryID, "CARS", "car")
+		if !reflect.DeepEqual(created.TagsNames, []string{"car"}) {
+			t.Fatalf("aliases not resolved: %v", created.TagsNames)
+		}
+
+		api.expect(http.StatusConflict, "POST", "/tags", map[string]interface{}{"name": "automobile"}, nil)
+		api.expect(http.StatusOK, "POST", path("/tags/%d/aliases", car.ID), map[string]interface{}{"name": "auto"}, nil)
+		api.expect(http.StatusConflict, "POST", "/tags/2/aliases", map[string]interface{}{"name": "Auto"}, nil)
+		api.expect(http.StatusNotFound, "POST", "/tags/999/aliases", map[string]interface{}{"name": "truck"}, nil)
+
+		api.expect(http.StatusNoContent, "DELETE", path("/tags/%d/aliases/auto", car.ID), nil, nil)
+		api.expect(http.StatusNotFound, "DELETE", path("/tags/%d/aliases/auto", car.ID), nil, nil)
+		api.expect(http.StatusNotFound, "GET", "/images?tag=auto", nil, nil)
+
+		var tagged []image.Image
+		api.expect(http.StatusOK, "GET", path("/tags/%d/images", car.ID), nil, &tagged)
+		if len(tagged) != 4 {
+			t.Fatalf("got %d images of car, want 4", len(tagged))
+		}
+		api.expect(http.StatusOK, "GET", "/tags/2/images", nil, &tagged)
+		if len(tagged) != 1 || tagged[0].ID != first.ID {
+			t.Fatalf("unexpected images of red %+v", tagged)
+		}
+	})
+}
+
+func TestTagTree(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("animals")
+
+		var animals, cat, kitten tag.Tag
+		api.expect(http.StatusOK, "POST", "/tags", map[string]interface{}{"name": "animals"}, &animals)
+		api.expect(http.StatusOK, "POST", "/tags", map[string]interface{}{"name": "cat", "parent_id": animals.ID}, &cat)
+		api.expect(http.StatusOK, "POST", "/tags", map[string]interface{}{"name": "kitten"}, &kitten)
+		api.expect(http.StatusBadRequest, "POST", "/tags", map[string]interface{}{"name": "dog", "parent_id": 999}, nil)
+
+		var moved tag.Tag
+		api.expect(http.StatusOK, "PUT", path("/tags/%d/parent", kitten.ID), map[string]interface{}{"parent_id": cat.ID},
+			&moved)
+		if moved.ParentID == nil || *moved.ParentID != cat.ID {
+			t.Fatalf("tag not moved: %+v", moved)
+		}
+		api.expect(http.StatusConflict, "PUT", path("/tags/%d/parent", animals.ID),
+			map[string]interface{}{"parent_id": kitten.ID}, nil)
+		api.expect(http.StatusConflict, "PUT", path("/tags/%d/parent", animals.ID),
+			map[string]interface{}{"parent_id": animals.ID}, nil)
+		api.expect(http.StatusBadRequest, "PUT", path("/tags/%d/parent", animals.ID),
+			map[string]interface{}{"parent_id": 999}, nil)
+		api.expect(http.StatusNotFound, "PUT", "/tags/999/parent", map[string]interface{}{"parent_id": nil}, nil)
+
+		api.createImage("tom", categoryID, "kitten")
+		api.createImage("felix", categoryID, "cat", "kitten")
+		api.createImage("oak", categoryID, "tree")
+
+		var images []image.Image
+		api.expect(http.StatusNotFound, "GET", "/images?tag=animals", nil, nil)
+		api.expect(http.StatusOK, "GET", "/images?tag=animals&descendants=true", nil, &images)
+		if len(images) != 2 {
+			t.Fatalf("got %d images below animals, want 2", len(images))
+		}
+		api.expect(http.StatusOK, "GET", path("/tags/%d/images?descendants=true", cat.ID), nil, &images)
+		if len(images) != 2 {
+			t.Fatalf("got %d images below cat, want 2", len(images))
+		}
+
+		// the children of a merged tag are moved to the tag it is merged into
+		var kitty, tabby tag.Tag
+		api.expect(http.StatusOK, "POST", "/tags", map[string]interface{}{"name": "kitty", "parent_id": animals.ID},
+			&kitty)
+		api.expect(http.StatusOK, "POST", "/tags", map[string]interface{}{"name": "tabby", "parent_id": kitty.ID},
+			&tabby)
+		api.expect(http.StatusOK, "POST", path("/tags/%d/merge", cat.ID), map[string]interface{}{"tags": []int64{kitty.ID}},
+			nil)
+		api.expect(http.StatusOK, "GET", path("/tags/%d", tabby.ID), nil, &tabby)
+		if tabby.ParentID == nil || *tabby.ParentID != cat.ID {
+			t.Fatalf("child of merged tag not moved below the tag it is merged into: %+v", tabby)
+		}
+		api.expect(http.StatusConflict, "POST", path("/tags/%d/merge", kitten.ID),
+			map[string]interface{}{"tags": []int64{animals.ID}}, nil)
+		api.expect(http.StatusOK, "GET", path("/tags/%d", animals.ID), nil, nil)
+
+		// the children of a deleted tag are moved to its parent
+		api.expect(http.StatusNoContent, "DELETE", path("/tags/%d", cat.ID), nil, nil)
+		var orphan tag.Tag
+		api.expect(http.StatusOK, "GET", path("/tags/%d", kitten.ID), nil, &orphan)
+		if orphan.ParentID == nil || *orphan.ParentID != animals.ID {
+			t.Fatalf("child of deleted tag not moved to its parent: %+v", orphan)
+		}
+
+		var root tag.Tag
+		api.expect(http.StatusOK, "PUT", path("/tags/%d/parent", kitten.ID), map[string]interface{}{"parent_id": nil},
+			&root)
+		if root.ParentID != nil {
+			t.Fatalf("tag not moved to the root: %+v", root)
+		}
+	})
+}
+
+func TestTagSuggestions(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("animals")
+		api.createImage("one", categoryID, "cat", "kitten", "cute")
+		second := api.createImage("two", categoryID, "cat", "cute")
+		api.createImage("three", categoryID, "cat", "dog", "cute")
+		api.createImage("four", categoryID, "category", "cat")
+		api.createImage("five", categoryID, "catalogue")
+		api.expect(http.StatusOK, "POST", "/tags/1/aliases", map[string]interface{}{"name": "chat"}, nil)
+
+		suggest := func(query string) []string {
+			t.Helper()
+			var suggestions []tag.Suggestion
+			api.expect(http.StatusOK, "GET", "/tags/suggest"+query, nil, &suggestions)
+			names := make([]string, 0, len(suggestions))
+			for _, s := range suggestions {
+				names = append(names, s.Name+"/"+s.Alias)
+			}
+			return names
+		}
+
+		for query, want := range map[string][]string{
+			"?q=cat":         {"cat/", "catalogue/", "category/", "cute/"},
+			"?q=cat&limit=2": {"cat/", "catalogue/"},
+			"?q=CHA":         {"cat/chat"},
+			"?q=kiten":       {"kitten/"},
+			"?q=catelogue":   {"catalogue/"},
+			"?limit=2":       {"cat/", "cute/"},
+		} {
+			if got := suggest(query); !reflect.DeepEqual(got, want) {
+				t.Errorf("suggestions for %s: got %v, want %v", query, got, want)
+			}
+		}
+		api.expect(http.StatusBadRequest, "GET", "/tags/suggest?limit=0", nil, nil)
+		api.expect(http.StatusBadRequest, "GET", "/tags/suggest?limit=many", nil, nil)
+
+		var suggestions []tag.Suggestion
+		api.expect(http.StatusOK, "GET", path("/images/%d/suggested-tags", second.ID), nil, &suggestions)
+		got := make(map[string]int64)
+		for _, s := range suggestions {
+			got[s.Name] = s.CoOccurrences
+		}
+		if !reflect.DeepEqual(got, map[string]int64{"dog": 2, "kitten": 2, "category": 1}) {
+			t.Fatalf("got suggested tags %v", got)
+		}
+		api.expect(http.StatusNotFound, "GET", "/images/999/suggested-tags", nil, nil)
+	})
+}
+
+// failingTagStore is a tag store whose tags cannot be read
+type failingTagStore struct {
+	tag.Store
+}
+
+func (s *failingTagStore) Tags() tag.Repository {
+	return &failingTagRepository{Repository: s.Store.Tags()}
+}
+
+func (s *failingTagStore) Transaction(fn func(store tag.Store) error) error {
+	return s.Store.Transaction(func(store tag.Store) error {
+		return fn(&failingTagStore{Store: store})
+	})
+}
+
+type failingTagRepository struct {
+	tag.Repository
+}
+
+func (r *failingTagRepository) SelectTagBy(whereColumn string, whereValue interface{}) (*tag.Tag, error) {
+	return nil, errors.New("database is down")
+}
+
+func TestTagMergeDatabaseError(t *testing.T) {
+	handler := &tag.Handler{Logger: cLog.GetLogger(), Store: &failingTagStore{Store: image.TagStore(memory.NewStore())}}
+	apiRouter := router.Router{Logger: handler.Logger}
+	apiRouter.AddHandler(handler)
+
+	req := httptest.NewRequest("POST", "/tags/1/merge", strings.NewReader(`{"tags": [2]}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	apiRouter.Configure().ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d: %s", recorder.Code, http.StatusInternalServerError, recorder.Body)
+	}
+}
+
+// racingTagStore is a tag store whose transactions do not see the tags saved
+// by concurrent requests, as when two requests create the same tag
+type racingTagStore struct {
+	tag.Store
+}
+
+func (s *racingTagStore) Transaction(fn func(store tag.Store) error) error {
+	return s.Store.Transaction(func(store tag.Store) error {
+		return fn(&staleTagStore{Store: store})
+	})
+}
+
+type staleTagStore struct {
+	tag.Store
+}
+
+func (s *staleTagStore) Tags() tag.Repository {
+	return &staleTagRepository{Repository: s.Store.Tags()}
+}
+
+type staleTagRepository struct {
+	tag.Repository
+}
+
+func (r *staleTagRepository) ResolveTag(name string) (*tag.Tag, error) {
+	return nil, nil
+}
+
+func TestTagCreatedConcurrently(t *testing.T) {
+	for name, newStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := image.TagStore(newStore(t))
+			if err := store.Tags().InsertTag(&tag.Tag{Name: "cat"}); err != nil {
+				t.Fatal(err)
+			}
+
+			handler := &tag.Handler{Logger: cLog.GetLogger(), Store: &racingTagStore{Store: store}}
+			apiRouter := router.Router{Logger: handler.Logger}
+			apiRouter.AddHandler(handler)
+
+			// the insert is refused by the unique index of tag names
+			req := httptest.NewRequest("POST", "/tags", strings.NewReader(`{"name": "cat"}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			apiRouter.Configure().ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusConflict {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, http.StatusConflict, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"image_gallery/category"
+	"image_gallery/database"
+	"image_gallery/image"
+	cLog "image_gallery/logger"
+	"image_gallery/memory"
+	"image_gallery/router"
+	"image_gallery/storage"
+	"image_gallery/tag"
+)
+
+// testStores returns the stores the API is tested with, by name
+func testStores(t *testing.T) map[string]func(t *testing.T) image.Store {
+	return map[string]func(t *testing.T) image.Store{
+		"memory": func(t *testing.T) image.Store { return memory.NewStore() },
+		"sqlite": newSQLiteStore,
+	}
+}
+
+// newSQLiteStore returns a store backed by a migrated SQLite database in a
+// temporary directory, so that the SQL repositories are tested without server
+func newSQLiteStore(t *testing.T) image.Store {
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "gallery.db"))
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := database.NewMigrator(db, database.SQLite, cLog.GetLogger())
+	if err != nil {
+		t.Fatalf("could not create migrator: %v", err)
+	}
+	if _, err = migrator.Up(); err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	return &image.SQLStore{Conn: db, Driver: database.SQLite}
+}
+
+// runAPITest runs test against the API backed by each test store
+func runAPITest(t *testing.T, test func(t *testing.T, api *testAPI)) {
+	for name, newStore := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			test(t, newTestAPI(t, newStore(t)))
+		})
+	}
+}
+
+// testAPI is the API served as by main, with files stored in a temporary directory
+type testAPI struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func newTestAPI(t *testing.T, store image.Store) *testAPI {
+	dir := t.TempDir()
+	t.Setenv("STORAGE_DRIVER", "local")
+	t.Setenv("STORAGE_LOCAL_PATH", filepath.Join(dir, "uploads")+"/")
+	t.Setenv("RENDER_CACHE_PATH", filepath.Join(dir, "renders")+"/")
+	t.Setenv("TUS_PATH", filepath.Join(dir, "tus")+"/")
+
+	logger := cLog.GetLogger()
+
+	fileStorage, err := storage.Open()
+	if err != nil {
+		t.Fatalf("could not open file storage: %v", err)
+	}
+	presets, err := image.LoadPresets()
+	if err != nil {
+		t.Fatalf("could not load rendition presets: %v", err)
+	}
+	renderer, err := image.NewRenderer()
+	if err != nil {
+		t.Fatalf("could not create image renderer: %v", err)
+	}
+	uploadConfig, err := image.LoadUploadConfig()
+	if err != nil {
+		t.Fatalf("could not load upload config: %v", err)
+	}
+	resumable, err := image.NewResumable()
+	if err != nil {
+		t.Fatalf("could not create resumable uploads: %v", err)
+	}
+	tagNames, err := tag.LoadNameConfig()
+	if err != nil {
+		t.Fatalf("could not load tag names config: %v", err)
+	}
+
+	apiRouter := router.Router{Logger: logger}
+	apiRouter.AddHandler(&category.Handler{Logger: logger, Repository: store.Categories()})
+	apiRouter.AddHandler(&tag.Handler{Logger: logger, Store: image.TagStore(store), Names: tagNames})
+	apiRouter.AddHandler(&image.Handler{
+		Logger:    logger,
+		Store:     store,
+		Storage:   fileStorage,
+		Presets:   presets,
+		Renderer:  renderer,
+		Upload:    uploadConfig,
+		Resumable: resumable,
+		TagNames:  tagNames,
+	})
+
+	muxRouter := apiRouter.Configure()
+	muxRouter.PathPrefix(image.UploadURL).Handler(http.StripPrefix(image.UploadURL,
+		storage.FileServer(fileStorage)))
+
+	server := httptest.NewServer(muxRouter)
+	t.Cleanup(server.Close)
+
+	return &testAPI{t: t, server: server}
+}
+
+// do sends a request with a JSON body, unless body is nil, and returns the
+// response status and body
+func (api *testAPI) do(method string, path string, body interface{}) (int, []byte) {
+	api.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, api.server.URL+path, reader)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return api.send(req)
+}
+
+// send sends a request and returns the response status and body
+func (api *testAPI) send(req *http.Request) (int, []byte) {
+	api.t.Helper()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+
+	return resp.StatusCode, content
+}
+
+// expect sends a request, checks the response status and decodes the
+// response body into v unless v is nil
+func (api *testAPI) expect(status int, method string, path string, body interface{}, v interface{}) {
+	api.t.Helper()
+
+	got, content := api.do(method, path, body)
+	if got != status {
+		api.t.Fatalf("%s %s: got status %d, want %d: %s", method, path, got, status, content)
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(content, v); err != nil {
+			api.t.Fatalf("%s %s: could not decode %s: %v", method, path, content, err)
+		}
+	}
+}
+
+// upload posts a multipart form with a file and returns the response status and body
+func (api *testAPI) upload(path string, fields map[string]string, file []byte) (int, []byte) {
+	api.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			api.t.Fatal(err)
+		}
+	}
+	part, err := form.CreateFormFile("file", "image.png")
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	if _, err = part.Write(file); err != nil {
+		api.t.Fatal(err)
+	}
+	if err = form.Close(); err != nil {
+		api.t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", api.server.URL+path, &body)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	return api.send(req)
+}
+
+// createCategory creates a category and returns its id
+func (api *testAPI) createCategory(name string) int64 {
+	api.t.Helper()
+
+	var created category.Category
+	api.expect(http.StatusOK, "POST", "/categories", map[string]interface{}{"name": name}, &created)
+
+	return created.ID
+}
+
+// createImage creates an image without file and returns it
+func (api *testAPI) createImage(name string, categoryID int64, tags ...string) *image.Image {
+	api.t.Helper()
+
+	var created image.Image
+	api.expect(http.StatusOK, "POST", "/images", map[string]interface{}{
+		"name": name, "description": "description of " + name, "category_id": categoryID, "tags": tags,
+	}, &created)
+
+	return &created
+}
+
+// pngFile returns a PNG of a single color, one quarter of it being white so
+// that its perceptual hash depends on the color
+func pngFile(t *testing.T, width int, height int, c color.Color) []byte {
+	t.Helper()
+
+	picture := goimage.NewRGBA(goimage.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 && y < height/2 {
+				picture.Set(x, y, color.White)
+				continue
+			}
+			picture.Set(x, y, c)
+		}
+	}
+
+	var file bytes.Buffer
+	if err := png.Encode(&file, picture); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Bytes()
+}
+
+// orientedJPEG returns a JPEG whose EXIF orientation is set, its top half
+// is white and its bottom half black
+func orientedJPEG(t *testing.T, width int, height int, orientation uint16) []byte {
+	t.Helper()
+
+	picture := goimage.NewGray(goimage.Rect(0, 0, width, height))
+	for y := 0; y < height/2; y++ {
+		for x := 0; x < width; x++ {
+			picture.Set(x, y, color.White)
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, picture, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// an APP1 segment with a little endian TIFF header and a single IFD entry
+	exif := []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00")
+	exif = append(exif, byte(orientation), byte(orientation>>8), 0, 0, 0, 0, 0, 0)
+	segment := append([]byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}, exif...)
+
+	file := append([]byte{}, encoded.Bytes()[:2]...)
+	file = append(file, segment...)
+	return append(file, encoded.Bytes()[2:]...)
+}
+
+// path formats an API path
+func path(format string, args ...interface{}) string {
+	return fmt.Sprintf(format, args...)
+}
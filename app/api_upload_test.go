@@ -0,0 +1,547 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	goimage "image"
+	"image/color"
+	_ "image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"image_gallery/image"
+	"image_gallery/imaging"
+	"image_gallery/memory"
+)
+
+// decodeImage decodes an image returned by the API
+func decodeImage(t *testing.T, content []byte) *image.Image {
+	t.Helper()
+
+	var decoded image.Image
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("could not decode image %s: %v", content, err)
+	}
+
+	return &decoded
+}
+
+func TestUploadImageWithFile(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("nature")
+		file := pngFile(t, 640, 480, color.RGBA{R: 200, A: 255})
+		fields := map[string]string{
+			"name": "sunset", "description": "red sky", "category_id": path("%d", categoryID), "tags": "red,sky",
+		}
+
+		status, content := api.upload("/images/upload", fields, file)
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+		}
+		uploaded := decodeImage(t, content)
+		if uploaded.Type != ".png" || uploaded.Hash == "" || uploaded.Width != 640 || uploaded.Height != 480 {
+			t.Fatalf("unexpected uploaded image %+v", uploaded)
+		}
+		if uploaded.BlurHash == "" || len(uploaded.Palette) == 0 {
+			t.Fatalf("placeholder and palette are not computed: %+v", uploaded)
+		}
+		thumb, ok := uploaded.Renditions["thumb"]
+		if !ok || thumb.Width != 200 || thumb.Height != 150 {
+			t.Fatalf("unexpected renditions %+v", uploaded.Renditions)
+		}
+
+		var selected image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", uploaded.ID), nil, &selected)
+		if selected.Hash != uploaded.Hash || len(selected.Renditions) != len(uploaded.Renditions) {
+			t.Fatalf("uploaded image not saved: %+v", selected)
+		}
+
+		// the stored file is the uploaded one
+		status, stored := api.do("GET", image.UploadURL+image.BlobKey(uploaded.Hash, uploaded.Type), nil)
+		if status != http.StatusOK || !bytes.Equal(stored, file) {
+			t.Fatalf("got stored file of %d bytes with status %d, want the %d uploaded bytes", len(stored), status,
+				len(file))
+		}
+		api.expect(http.StatusOK, "GET", path("/images/%d/render?w=300", uploaded.ID), nil, nil)
+
+		// the same file is not stored twice
+		fields["name"] = "sunset again"
+		status, content = api.upload("/images/upload", fields, file)
+		if status != http.StatusOK || decodeImage(t, content).ID != uploaded.ID {
+			t.Fatalf("duplicate upload returned %d: %s", status, content)
+		}
+
+		fields["link_duplicate"] = "true"
+		status, content = api.upload("/images/upload", fields, file)
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+		}
+		linked := decodeImage(t, content)
+		if linked.ID == uploaded.ID || linked.Hash != uploaded.Hash {
+			t.Fatalf("unexpected linked image %+v", linked)
+		}
+
+		// the file shared by both images is kept until both are deleted
+		api.expect(http.StatusNoContent, "DELETE", path("/images/%d?delete_mode=hard", uploaded.ID), nil, nil)
+		api.expect(http.StatusOK, "GET", path("/images/%d/render?w=100", linked.ID), nil, nil)
+	})
+}
+
+func TestUploadOrientedFile(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		fields := map[string]string{
+			"name": "portrait", "description": "taken sideways", "category_id": path("%d", api.createCategory("people")),
+		}
+
+		// the camera was rotated, the landscape file is displayed as a portrait
+		status, content := api.upload("/images/upload", fields, orientedJPEG(t, 400, 200, 6))
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+		}
+		uploaded := decodeImage(t, content)
+		if uploaded.Width != 200 || uploaded.Height != 400 || uploaded.Metadata == nil ||
+			uploaded.Metadata.Orientation != 6 {
+			t.Fatalf("unexpected uploaded image %+v", uploaded)
+		}
+
+		thumb := uploaded.Renditions["thumb"]
+		if thumb == nil || thumb.Width != 100 || thumb.Height != 200 {
+			t.Fatalf("rendition not oriented: %+v", thumb)
+		}
+		expectPortrait(t, api, thumb.URL, 100, 200)
+		expectPortrait(t, api, path("/images/%d/render?w=100", uploaded.ID), 100, 200)
+		expectPortrait(t, api, path("/images/%d/render?h=100", uploaded.ID), 50, 100)
+		expectPortrait(t, api, path("/images/%d/render?w=50&h=100&fit=cover", uploaded.ID), 50, 100)
+	})
+}
+
+// expectPortrait checks that an image served at path is the file of orientedJPEG
+// rotated clockwise, its white top on the right
+func expectPortrait(t *testing.T, api *testAPI, path string, width int, height int) {
+	t.Helper()
+
+	status, content := api.do("GET", path, nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: got status %d: %s", path, status, content)
+	}
+	portrait, _, err := goimage.Decode(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+
+	if bounds := portrait.Bounds(); bounds.Dx() != width || bounds.Dy() != height {
+		t.Fatalf("GET %s: got image of %v, want %dx%d", path, bounds, width, height)
+	}
+	if r, _, _, _ := portrait.At(width*9/10, height/2).RGBA(); r < 0xC000 {
+		t.Fatalf("GET %s: image not rotated clockwise", path)
+	}
+	if r, _, _, _ := portrait.At(width/10, height/2).RGBA(); r > 0x4000 {
+		t.Fatalf("GET %s: image not rotated clockwise", path)
+	}
+}
+
+func TestUploadRejectedFiles(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("nature")
+		fields := map[string]string{"name": "text", "description": "d", "category_id": path("%d", categoryID)}
+
+		status, content := api.upload("/images/upload", fields, []byte("not an image at all"))
+		if status != http.StatusUnsupportedMediaType {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusUnsupportedMediaType, content)
+		}
+
+		file := pngFile(t, 64, 48, color.RGBA{B: 200, A: 255})
+		status, content = api.upload("/images/upload", fields, file[:len(file)/2])
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusUnprocessableEntity, content)
+		}
+
+		// avif files would have to be re-encoded to be stripped
+		var avif bytes.Buffer
+		if err := imaging.Encode(&avif, goimage.NewRGBA(goimage.Rect(0, 0, 8, 8)), "avif"); err != nil {
+			t.Fatal(err)
+		}
+		fields["strip_metadata"] = "true"
+		status, content = api.upload("/images/upload", fields, avif.Bytes())
+		if status != http.StatusUnsupportedMediaType {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusUnsupportedMediaType, content)
+		}
+		delete(fields, "strip_metadata")
+
+		fields["category_id"] = "999"
+		status, content = api.upload("/images/upload", fields, file)
+		if status != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusBadRequest, content)
+		}
+
+		// nothing is saved for rejected files
+		api.expect(http.StatusNotFound, "GET", "/images", nil, nil)
+	})
+}
+
+func TestUploadFileOfImage(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		created := api.createImage("forest", api.createCategory("nature"), "green")
+
+		status, content := api.upload(path("/upload/%d", created.ID), nil, pngFile(t, 300, 200,
+			color.RGBA{G: 200, A: 255}))
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+		}
+
+		var selected image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", created.ID), nil, &selected)
+		if selected.Type != ".png" || selected.Width != 300 || selected.Height != 200 {
+			t.Fatalf("file of image not saved: %+v", selected)
+		}
+
+		// the type is the one of the uploaded file
+		var updated image.Image
+		api.expect(http.StatusOK, "PUT", path("/images/%d", created.ID), map[string]interface{}{
+			"name": "forest", "description": "pines", "category_id": selected.CategoryID, "type": ".gif",
+		}, &updated)
+		if updated.Type != ".png" {
+			t.Fatalf("got type %q in the update response, want .png", updated.Type)
+		}
+		api.expect(http.StatusOK, "GET", path("/images/%d", created.ID), nil, &updated)
+		if updated.Type != ".png" || updated.Description != "pines" {
+			t.Fatalf("unexpected updated image %+v", updated)
+		}
+
+		status, _ = api.upload(path("/upload/%d", created.ID), nil, pngFile(t, 300, 200, color.Black))
+		if status != http.StatusBadRequest {
+			t.Fatalf("second upload got status %d, want %d", status, http.StatusBadRequest)
+		}
+
+		// a soft delete removes the file and keeps the image, which can be uploaded again
+		api.expect(http.StatusNoContent, "DELETE", path("/images/%d", created.ID), nil, nil)
+		var deleted image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", created.ID), nil, &deleted)
+		if deleted.Type != "" || len(deleted.Renditions) != 0 {
+			t.Fatalf("file of image not removed: %+v", deleted)
+		}
+		status, content = api.upload(path("/upload/%d", created.ID), nil, pngFile(t, 300, 200, color.Black))
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, content)
+		}
+	})
+}
+
+// failingImageStore is a store whose image palettes cannot be saved
+type failingImageStore struct {
+	image.Store
+}
+
+func (s *failingImageStore) Images() image.Repository {
+	return &failingImageRepository{Repository: s.Store.Images()}
+}
+
+func (s *failingImageStore) Transaction(fn func(store image.Store) error) error {
+	return s.Store.Transaction(func(store image.Store) error {
+		return fn(&failingImageStore{Store: store})
+	})
+}
+
+type failingImageRepository struct {
+	image.Repository
+}
+
+func (r *failingImageRepository) InsertPalette(imageID int64, palette []imaging.PaletteColor) error {
+	return errors.New("database is down")
+}
+
+func TestUploadFileRemovedWhenRowsCannotBeSaved(t *testing.T) {
+	api := newTestAPI(t, &failingImageStore{Store: memory.NewStore()})
+	categoryID := api.createCategory("nature")
+	file := pngFile(t, 64, 48, color.RGBA{G: 200, A: 255})
+	fields := map[string]string{"name": "forest", "description": "d", "category_id": path("%d", categoryID)}
+
+	status, content := api.upload("/images/upload", fields, file)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d: %s", status, http.StatusInternalServerError, content)
+	}
+
+	// the file is written before its rows are saved, then removed
+	hash := sha256.Sum256(file)
+	api.expect(http.StatusNotFound, "GET", image.UploadURL+image.BlobKey(hex.EncodeToString(hash[:]), ".png"), nil, nil)
+	api.expect(http.StatusNotFound, "GET", "/images", nil, nil)
+}
+
+// tus sends a tus protocol request and returns the response status and headers
+func (api *testAPI) tus(method string, path string, headers map[string]string, body []byte) (int, http.Header) {
+	api.t.Helper()
+
+	req, err := http.NewRequest(method, api.server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, resp.Header
+}
+
+func TestResumableUploadKeptAfterServerError(t *testing.T) {
+	api := newTestAPI(t, &failingImageStore{Store: memory.NewStore()})
+	created := api.createImage("forest", api.createCategory("nature"))
+	file := pngFile(t, 64, 48, color.RGBA{G: 200, A: 255})
+	length := path("%d", len(file))
+
+	status, headers := api.tus("POST", path("/upload/%d/resumable", created.ID),
+		map[string]string{"Upload-Length": length}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+	location := headers.Get("Location")
+
+	status, _ = api.tus("PATCH", location, map[string]string{"Upload-Offset": "0"}, file)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", status, http.StatusInternalServerError)
+	}
+
+	// the complete upload is kept so that saving it can be retried
+	status, headers = api.tus("HEAD", location, nil, nil)
+	if status != http.StatusOK || headers.Get("Upload-Offset") != length {
+		t.Fatalf("got status %d and offset %q, want %d and %s", status, headers.Get("Upload-Offset"),
+			http.StatusOK, length)
+	}
+	status, _ = api.tus("PATCH", location, map[string]string{"Upload-Offset": length}, nil)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		created := api.createImage("forest", api.createCategory("nature"))
+		file := pngFile(t, 64, 48, color.RGBA{G: 200, A: 255})
+		half := len(file) / 2
+
+		status, headers := api.tus("POST", path("/upload/%d/resumable", created.ID),
+			map[string]string{"Upload-Length": path("%d", len(file))}, nil)
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+		}
+		location := headers.Get("Location")
+
+		status, headers = api.tus("PATCH", location, map[string]string{"Upload-Offset": "0"}, file[:half])
+		if status != http.StatusNoContent || headers.Get("Upload-Offset") != path("%d", half) {
+			t.Fatalf("got status %d and offset %q, want %d and %d", status, headers.Get("Upload-Offset"),
+				http.StatusNoContent, half)
+		}
+
+		// a chunk sent again is refused, the offset tells where to resume
+		status, _ = api.tus("PATCH", location, map[string]string{"Upload-Offset": "0"}, file[:half])
+		if status != http.StatusConflict {
+			t.Fatalf("got status %d, want %d", status, http.StatusConflict)
+		}
+		status, headers = api.tus("HEAD", location, nil, nil)
+		if status != http.StatusOK || headers.Get("Upload-Offset") != path("%d", half) {
+			t.Fatalf("got status %d and offset %q, want %d and %d", status, headers.Get("Upload-Offset"),
+				http.StatusOK, half)
+		}
+
+		status, _ = api.tus("PATCH", location, map[string]string{"Upload-Offset": path("%d", half)}, file[half:])
+		if status != http.StatusNoContent {
+			t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+		}
+
+		// the upload is removed once the image is stored
+		status, _ = api.tus("HEAD", location, nil, nil)
+		if status != http.StatusNotFound {
+			t.Fatalf("got status %d, want %d", status, http.StatusNotFound)
+		}
+
+		var uploaded image.Image
+		api.expect(http.StatusOK, "GET", path("/images/%d", created.ID), nil, &uploaded)
+		if uploaded.Type != ".png" || uploaded.Width != 64 || uploaded.Height != 48 {
+			t.Fatalf("got type %q and size %dx%d, want .png and 64x48", uploaded.Type, uploaded.Width, uploaded.Height)
+		}
+		status, stored := api.do("GET", image.UploadURL+image.BlobKey(uploaded.Hash, uploaded.Type), nil)
+		if status != http.StatusOK || !bytes.Equal(stored, file) {
+			t.Fatalf("got status %d and %d bytes, want the %d bytes uploaded", status, len(stored), len(file))
+		}
+	})
+}
+
+func TestResumableUploadCancelled(t *testing.T) {
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		created := api.createImage("forest", api.createCategory("nature"))
+
+		status, _ := api.tus("POST", path("/upload/%d/resumable", created.ID), map[string]string{}, nil)
+		if status != http.StatusBadRequest {
+			t.Fatalf("got status %d without length, want %d", status, http.StatusBadRequest)
+		}
+
+		status, headers := api.tus("POST", path("/upload/%d/resumable", created.ID),
+			map[string]string{"Upload-Length": "100"}, nil)
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+		}
+		location := headers.Get("Location")
+
+		status, _ = api.tus("DELETE", location, nil, nil)
+		if status != http.StatusNoContent {
+			t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+		}
+		status, _ = api.tus("PATCH", location, map[string]string{"Upload-Offset": "0"}, []byte("data"))
+		if status != http.StatusNotFound {
+			t.Fatalf("got status %d, want %d", status, http.StatusNotFound)
+		}
+	})
+}
+
+// bulkFile is a file part of a bulk upload
+type bulkFile struct {
+	name    string
+	content []byte
+}
+
+// zipFile returns a zip archive of files
+func zipFile(t *testing.T, files ...bulkFile) []byte {
+	t.Helper()
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, file := range files {
+		entry, err := writer.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = entry.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archive.Bytes()
+}
+
+// bulkUpload posts the category id followed by files to the bulk upload and
+// returns the decoded report
+func (api *testAPI) bulkUpload(categoryID int64, files ...bulkFile) *bulkReport {
+	api.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("category_id", path("%d", categoryID)); err != nil {
+		api.t.Fatal(err)
+	}
+	for _, file := range files {
+		part, err := form.CreateFormFile("files", file.name)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		if _, err = part.Write(file.content); err != nil {
+			api.t.Fatal(err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		api.t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", api.server.URL+"/images/bulk", &body)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	status, content := api.send(req)
+	if status != http.StatusOK {
+		api.t.Fatalf("got status %d, want %d: %s", status, http.StatusOK, content)
+	}
+
+	var report bulkReport
+	if err = json.Unmarshal(content, &report); err != nil {
+		api.t.Fatalf("could not decode report %s: %v", content, err)
+	}
+
+	return &report
+}
+
+// bulkReport is the report returned by bulk uploads
+type bulkReport struct {
+	Succeeded  int `json:"succeeded"`
+	Duplicates int `json:"duplicates"`
+	Failed     int `json:"failed"`
+	Results    []struct {
+		File      string       `json:"file"`
+		Image     *image.Image `json:"image"`
+		Duplicate bool         `json:"duplicate"`
+		Error     string       `json:"error"`
+	} `json:"results"`
+}
+
+func TestBulkUpload(t *testing.T) {
+	t.Setenv("UPLOAD_MAX_SIZE", "2000")
+	t.Setenv("UPLOAD_MAX_BULK_FILES", "5")
+
+	runAPITest(t, func(t *testing.T, api *testAPI) {
+		categoryID := api.createCategory("nature")
+		red := pngFile(t, 64, 48, color.RGBA{R: 200, A: 255})
+		archive := zipFile(t,
+			bulkFile{name: "green.png", content: pngFile(t, 64, 48, color.RGBA{G: 200, A: 255})},
+			bulkFile{name: "__MACOSX/._green.png", content: []byte("resource fork")},
+			bulkFile{name: ".DS_Store", content: []byte("finder settings")},
+			bulkFile{name: "photos/blue.png", content: pngFile(t, 64, 48, color.RGBA{B: 200, A: 255})},
+			bulkFile{name: "photos/.DS_Store", content: []byte("finder settings")},
+		)
+
+		report := api.bulkUpload(categoryID,
+			bulkFile{name: "red.png", content: red},
+			bulkFile{name: "holidays.zip", content: archive},
+			bulkFile{name: "large.png", content: bytes.Repeat([]byte{0}, 2001)},
+			bulkFile{name: "copy.png", content: red},
+			bulkFile{name: "yellow.png", content: pngFile(t, 64, 48, color.RGBA{R: 200, G: 200, A: 255})},
+		)
+
+		// hidden files of the archive are ignored, the sixth file is beyond the limit
+		want := []struct {
+			file      string
+			duplicate bool
+			err       string
+		}{
+			{file: "red.png"},
+			{file: "holidays.zip/green.png"},
+			{file: "holidays.zip/photos/blue.png"},
+			{file: "large.png", err: "file cannot exceed 2000 bytes"},
+			{file: "copy.png", duplicate: true},
+			{file: "yellow.png", err: "bulk uploads are limited to 5 files, following files were not read"},
+		}
+		if report.Succeeded != 3 || report.Duplicates != 1 || report.Failed != 2 || len(report.Results) != len(want) {
+			t.Fatalf("got report %+v, want 3 succeeded, 1 duplicate and 2 failed files", report)
+		}
+		for i, result := range report.Results {
+			if result.File != want[i].file || result.Duplicate != want[i].duplicate || result.Error != want[i].err {
+				t.Errorf("got result %d %+v, want %+v", i, result, want[i])
+			}
+		}
+		if report.Results[4].Image == nil || report.Results[4].Image.ID != report.Results[0].Image.ID {
+			t.Errorf("duplicate is not reported with the existing image: %+v", report.Results[4].Image)
+		}
+
+		var images []image.Image
+		api.expect(http.StatusOK, "GET", "/images", nil, &images)
+		if len(images) != 3 {
+			t.Fatalf("got %d images, want 3", len(images))
+		}
+	})
+}
@@ -3,22 +3,38 @@ package category
 import (
 	"database/sql"
 	"fmt"
+	"image_gallery/database"
 	"strings"
 	"time"
 )
 
-// Repository struct for db connection
-type Repository struct {
-	Conn *sql.DB
+// Repository stores categories
+type Repository interface {
+	// SelectCategoryByID returns nil when the category does not exist
+	SelectCategoryByID(id int64) (*Category, error)
+	RetrieveAllCategories(filters map[FilterName]interface{}) ([]*Category, error)
+	InsertCategory(category *Category) error
+	UpdateCategory(category *Category, id int64) error
+	DeleteCategory(id int64) (int64, error)
+}
+
+// SQLRepository is the Repository of a SQL database
+type SQLRepository struct {
+	// Conn runs queries written with ? placeholders, see Driver.Conn
+	Conn   database.Querier
+	Driver database.Driver
 }
 
 // Category struct
 type Category struct {
-	ID          int64     `json:"id,omitempty"`
-	Name        string    `json:"name,omitempty"`
-	Description string    `json:"description,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID            int64  `json:"id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Description   string `json:"description,omitempty"`
+	StripMetadata bool   `json:"strip_metadata"`
+	// MaxUploadSize overrides the global upload size limit, in bytes
+	MaxUploadSize *int64    `json:"max_upload_size,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // Validate : interface for JSON backend validation
@@ -32,26 +48,34 @@ func (c *Category) Validate() error {
 		return fmt.Errorf("name cannot be longer than 255 characters")
 	}
 
+	if c.MaxUploadSize != nil && *c.MaxUploadSize <= 0 {
+		return fmt.Errorf("max_upload_size must be positive")
+	}
+
 	return nil
 
 }
 
 // SelectCategoryByID retrieves a product using its id
-func (repository *Repository) SelectCategoryByID(id int64) (*Category, error) {
-	row := repository.Conn.QueryRow("SELECT c.id, c.name, c.description, "+
-		"c.created_at, c.updated_at FROM category c WHERE c.id=(?)", id)
+func (repository *SQLRepository) SelectCategoryByID(id int64) (*Category, error) {
+	row := repository.Conn.QueryRow("SELECT c.id, c.name, c.description, c.strip_metadata, "+
+		"c.max_upload_size, c.created_at, c.updated_at FROM category c WHERE c.id=(?)", id)
 	var name, description string
+	var stripMetadata bool
+	var maxUploadSize sql.NullInt64
 	var createdAt, updatedAt time.Time
-	switch err := row.Scan(&id, &name, &description, &createdAt, &updatedAt); err {
+	switch err := row.Scan(&id, &name, &description, &stripMetadata, &maxUploadSize, &createdAt, &updatedAt); err {
 	case sql.ErrNoRows:
 		return nil, nil
 	case nil:
 		category := Category{
-			ID:          id,
-			Name:        name,
-			Description: description,
-			CreatedAt:   createdAt,
-			UpdatedAt:   updatedAt,
+			ID:            id,
+			Name:          name,
+			Description:   description,
+			StripMetadata: stripMetadata,
+			MaxUploadSize: nullableInt64(maxUploadSize),
+			CreatedAt:     createdAt,
+			UpdatedAt:     updatedAt,
 		}
 		return &category, nil
 	default:
@@ -59,16 +83,18 @@ func (repository *Repository) SelectCategoryByID(id int64) (*Category, error) {
 	}
 }
 
-type filterName string
+// FilterName names a filter of RetrieveAllCategories
+type FilterName string
 
-const filterByDateOfUpdate filterName = "updated_at"
+// FilterByDateOfUpdate orders categories by update date, "asc" or "desc", and keeps the first 3
+const FilterByDateOfUpdate FilterName = "updated_at"
 
-// retrieveAllCategories stored in db
-func (repository *Repository) retrieveAllCategories(filters map[filterName]interface{}) ([]*Category, error) {
+// RetrieveAllCategories stored in db
+func (repository *SQLRepository) RetrieveAllCategories(filters map[FilterName]interface{}) ([]*Category, error) {
 	queryOrders := make([]string, 0)
 
 	// Filtering categories by date
-	if v, ok := filters[filterByDateOfUpdate]; ok {
+	if v, ok := filters[FilterByDateOfUpdate]; ok {
 		if vv, ok := v.(string); ok {
 			switch vv {
 			case "asc":
@@ -80,7 +106,7 @@ func (repository *Repository) retrieveAllCategories(filters map[filterName]inter
 	}
 
 	queryFields := []string{
-		"c.id", "c.name", "c.description", "c.created_at", "c.updated_at",
+		"c.id", "c.name", "c.description", "c.strip_metadata", "c.max_upload_size", "c.created_at", "c.updated_at",
 	}
 	query := fmt.Sprintf("SELECT %s FROM category c", strings.Join(queryFields, ", "))
 
@@ -96,42 +122,36 @@ func (repository *Repository) retrieveAllCategories(filters map[filterName]inter
 
 	var id int64
 	var name, description string
+	var stripMetadata bool
+	var maxUploadSize sql.NullInt64
 	var createdAt, updatedAt time.Time
 	var categories []*Category
 	for rows.Next() {
-		err := rows.Scan(&id, &name, &description, &createdAt, &updatedAt)
+		err := rows.Scan(&id, &name, &description, &stripMetadata, &maxUploadSize, &createdAt, &updatedAt)
 		if err != nil {
 			return nil, err
 		}
 		categories = append(categories, &Category{
-			ID:          id,
-			Name:        name,
-			Description: description,
-			CreatedAt:   createdAt,
-			UpdatedAt:   updatedAt,
+			ID:            id,
+			Name:          name,
+			Description:   description,
+			StripMetadata: stripMetadata,
+			MaxUploadSize: nullableInt64(maxUploadSize),
+			CreatedAt:     createdAt,
+			UpdatedAt:     updatedAt,
 		})
 	}
 
 	return categories, nil
 }
 
-// insertCategory posts a new category
-func (repository *Repository) insertCategory(category *Category) error {
-	stmt, err := repository.Conn.Prepare("INSERT INTO category(name, description, created_at," +
-		" updated_at) VALUES(?,?,?,?)")
-
-	if err != nil {
-		return err
-	}
+// InsertCategory posts a new category
+func (repository *SQLRepository) InsertCategory(category *Category) error {
 	category.CreatedAt = time.Now()
 	category.UpdatedAt = time.Now()
-	res, errExec := stmt.Exec(category.Name, category.Description, category.CreatedAt, category.UpdatedAt)
-
-	if errExec != nil {
-		return errExec
-	}
-
-	lastInsertedID, errInsert := res.LastInsertId()
+	lastInsertedID, errInsert := repository.Driver.Insert(repository.Conn, "INSERT INTO category(name, description,"+
+		" strip_metadata, max_upload_size, created_at, updated_at) VALUES(?,?,?,?,?,?)", category.Name,
+		category.Description, category.StripMetadata, category.MaxUploadSize, category.CreatedAt, category.UpdatedAt)
 
 	if errInsert != nil {
 		return errInsert
@@ -142,10 +162,10 @@ func (repository *Repository) insertCategory(category *Category) error {
 	return nil
 }
 
-// updateCategory by ID
-func (repository *Repository) updateCategory(category *Category, id int64) error {
-	stmt, err := repository.Conn.Prepare("UPDATE category SET name=(?), description=(?), " +
-		"updated_at=(?) WHERE id=(?)")
+// UpdateCategory by ID
+func (repository *SQLRepository) UpdateCategory(category *Category, id int64) error {
+	stmt, err := repository.Conn.Prepare("UPDATE category SET name=(?), description=(?), strip_metadata=(?), " +
+		"max_upload_size=(?), updated_at=(?) WHERE id=(?)")
 	if err != nil {
 		return err
 	}
@@ -157,7 +177,8 @@ func (repository *Repository) updateCategory(category *Category, id int64) error
 	category.CreatedAt = createdAt
 	category.UpdatedAt = time.Now()
 
-	_, errExec := stmt.Exec(category.Name, category.Description, category.UpdatedAt, id)
+	_, errExec := stmt.Exec(category.Name, category.Description, category.StripMetadata, category.MaxUploadSize,
+		category.UpdatedAt, id)
 
 	if errExec != nil {
 		return errExec
@@ -168,8 +189,8 @@ func (repository *Repository) updateCategory(category *Category, id int64) error
 	return nil
 }
 
-// deleteCategory by ID
-func (repository *Repository) deleteCategory(id int64) (int64, error) {
+// DeleteCategory by ID
+func (repository *SQLRepository) DeleteCategory(id int64) (int64, error) {
 
 	res, err := repository.Conn.Exec("DELETE FROM category WHERE id=(?)", id)
 	if err != nil {
@@ -177,3 +198,12 @@ func (repository *Repository) deleteCategory(id int64) (int64, error) {
 	}
 	return res.RowsAffected()
 }
+
+// nullableInt64 converts a nullable column to a pointer, nil when NULL
+func nullableInt64(value sql.NullInt64) *int64 {
+	if !value.Valid {
+		return nil
+	}
+
+	return &value.Int64
+}
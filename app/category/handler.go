@@ -2,7 +2,6 @@ package category
 
 import (
 	"github.com/gorilla/mux"
-	"image_gallery/database"
 	"image_gallery/helpers"
 	cLog "image_gallery/logger"
 	"image_gallery/router"
@@ -11,7 +10,8 @@ import (
 
 // Handler is the home handler
 type Handler struct {
-	Logger *cLog.Logger
+	Logger     *cLog.Logger
+	Repository Repository
 }
 
 // Routes returns handler routes
@@ -54,17 +54,13 @@ func (h *Handler) getCategoryByID(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("calling %v", r.URL.Path)
 
 	muxVars := mux.Vars(r)
-	db := database.DbConn
-
-	repository := Repository{Conn: db}
-
 	id, err := helpers.ParseInt64(muxVars["id"])
 	if err != nil {
 		h.Logger.Error(err)
 		return
 	}
 
-	category, err := repository.SelectCategoryByID(id)
+	category, err := h.Repository.SelectCategoryByID(id)
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve category")
@@ -84,17 +80,14 @@ func (h *Handler) getCategoryByID(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) getAllCategories(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("calling %v", r.URL.Path)
 
-	db := database.DbConn
-	repository := Repository{Conn: db}
-
-	filters := make(map[filterName]interface{})
+	filters := make(map[FilterName]interface{})
 
-	order := r.URL.Query().Get(string(filterByDateOfUpdate))
+	order := r.URL.Query().Get(string(FilterByDateOfUpdate))
 	if order != "" {
-		filters[filterByDateOfUpdate] = order
+		filters[FilterByDateOfUpdate] = order
 	}
 
-	categories, err := repository.retrieveAllCategories(filters)
+	categories, err := h.Repository.RetrieveAllCategories(filters)
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve categories")
@@ -108,9 +101,6 @@ func (h *Handler) getAllCategories(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) createCategory(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("calling %v", r.URL.Path)
 
-	db := database.DbConn
-	repository := Repository{Conn: db}
-
 	var category Category
 
 	err := helpers.ReadValidateJSON(w, r, &category)
@@ -118,7 +108,7 @@ func (h *Handler) createCategory(w http.ResponseWriter, r *http.Request) {
 		h.Logger.Error(err)
 		return
 	}
-	err = repository.insertCategory(&category)
+	err = h.Repository.InsertCategory(&category)
 
 	if err != nil {
 		h.Logger.Error(err)
@@ -140,9 +130,6 @@ func (h *Handler) updateCategory(w http.ResponseWriter, r *http.Request) {
 		h.Logger.Error(err)
 		return
 	}
-	db := database.DbConn
-	repository := Repository{Conn: db}
-
 	var category Category
 
 	err = helpers.ReadValidateJSON(w, r, &category)
@@ -150,7 +137,7 @@ func (h *Handler) updateCategory(w http.ResponseWriter, r *http.Request) {
 		h.Logger.Error(err)
 		return
 	}
-	err = repository.updateCategory(&category, id)
+	err = h.Repository.UpdateCategory(&category, id)
 	if err != nil {
 		h.Logger.Error(err)
 		return
@@ -169,10 +156,7 @@ func (h *Handler) deleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db := database.DbConn
-	repository := Repository{Conn: db}
-
-	rowsAffected, err := repository.deleteCategory(id)
+	rowsAffected, err := h.Repository.DeleteCategory(id)
 	if err != nil {
 		h.Logger.Error(err)
 		return
@@ -5,23 +5,53 @@ import (
 	"errors"
 	"fmt"
 	"github.com/caarlos0/env/v6"
+	"net/url"
+	"os"
+	"path/filepath"
 	// blank import for mysql driver
 	_ "github.com/go-sql-driver/mysql"
+	// blank import for postgres driver
+	_ "github.com/lib/pq"
 	cLog "image_gallery/logger"
 	"time"
+
+	// blank import for sqlite driver
+	_ "modernc.org/sqlite"
 )
 
 //DbConn stores the connexion to the database
 var (
 	DbConn *sql.DB
+	// DbDriver is the database DbConn is connected to
+	DbDriver Driver
 )
 
+// Driver names a supported database
+type Driver string
+
+// MySQL is the default database
+const MySQL Driver = "mysql"
+
+// SQLite stores the database in a single file, for single node deployments and tests
+const SQLite Driver = "sqlite"
+
+// PostgreSQL is supported alongside MySQL for server deployments
+const PostgreSQL Driver = "postgres"
+
 // Config for DB connection
 type Config struct {
+	Driver     Driver `env:"DB_DRIVER" envDefault:"mysql"`
 	DbHost     string `env:"DB_HOST"`
 	DbName     string `env:"MYSQL_DATABASE"`
 	DbUser     string `env:"MYSQL_USER"`
 	DbPassword string `env:"MYSQL_PASSWORD"`
+	PgHost     string `env:"POSTGRES_HOST" envDefault:"localhost:5432"`
+	PgName     string `env:"POSTGRES_DB"`
+	PgUser     string `env:"POSTGRES_USER"`
+	PgPassword string `env:"POSTGRES_PASSWORD"`
+	PgSSLMode  string `env:"POSTGRES_SSLMODE" envDefault:"disable"`
+	// SQLitePath is the database file used by the sqlite driver
+	SQLitePath string `env:"SQLITE_PATH" envDefault:"/go/data/gallery.db"`
 	DbConn     *sql.DB
 }
 
@@ -31,14 +61,38 @@ func Connect() error {
 	if err := env.Parse(&cfg); err != nil {
 		return fmt.Errorf("%+v", err)
 	}
-	dsn := cfg.DbUser + ":" + cfg.DbPassword + "@" + cfg.DbHost + "/" + cfg.
-		DbName + "?parseTime=true&charset=utf8"
 
 	logger := cLog.GetLogger()
 
-	logger.Infof("DSN: %s", dsn)
+	var db *sql.DB
+	var err error
+	switch cfg.Driver {
+	case MySQL:
+		dsn := cfg.DbUser + ":" + cfg.DbPassword + "@" + cfg.DbHost + "/" + cfg.
+			DbName + "?parseTime=true&charset=utf8"
+
+		logger.Infof("DSN: %s", dsn)
+
+		db, err = sql.Open("mysql", dsn)
+	case PostgreSQL:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.PgUser, cfg.PgPassword),
+			Host:     cfg.PgHost,
+			Path:     cfg.PgName,
+			RawQuery: url.Values{"sslmode": {cfg.PgSSLMode}}.Encode(),
+		}
+
+		logger.Infof("DSN: %s", dsn.Redacted())
+
+		db, err = sql.Open("postgres", dsn.String())
+	case SQLite:
+		logger.Infof("SQLite database: %s", cfg.SQLitePath)
 
-	db, err := sql.Open("mysql", dsn)
+		db, err = OpenSQLite(cfg.SQLitePath)
+	default:
+		return fmt.Errorf("unknown database driver %q, use mysql, postgres or sqlite", cfg.Driver)
+	}
 
 	if err != nil {
 		return err
@@ -63,6 +117,21 @@ func Connect() error {
 	}
 
 	DbConn = db
+	DbDriver = cfg.Driver
 
 	return nil
 }
+
+// OpenSQLite opens the SQLite database file at path, created if missing
+func OpenSQLite(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create database directory: %v", err)
+	}
+
+	// foreign keys are checked as by MySQL, and writers wait for each other
+	// instead of failing while the database is locked
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)" +
+		"&_time_format=sqlite"
+
+	return sql.Open("sqlite", dsn)
+}
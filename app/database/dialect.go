@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Rebind rewrites the ? placeholders of a query to the syntax of the driver,
+// $1, $2... for PostgreSQL
+func (d Driver) Rebind(query string) string {
+	if d != PostgreSQL || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var rebound strings.Builder
+	rebound.Grow(len(query) + 8)
+
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			rebound.WriteByte('$')
+			rebound.WriteString(strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteByte(c)
+	}
+
+	return rebound.String()
+}
+
+// Conn returns a Querier running the queries of conn written with ? placeholders
+// on a database of the driver
+func (d Driver) Conn(conn Querier) Querier {
+	if d != PostgreSQL {
+		return conn
+	}
+
+	return &reboundQuerier{Querier: conn, driver: d}
+}
+
+// reboundQuerier rewrites the placeholders of queries before running them
+type reboundQuerier struct {
+	Querier
+	driver Driver
+}
+
+func (q *reboundQuerier) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return q.Querier.Exec(q.driver.Rebind(query), args...)
+}
+
+func (q *reboundQuerier) Prepare(query string) (*sql.Stmt, error) {
+	return q.Querier.Prepare(q.driver.Rebind(query))
+}
+
+func (q *reboundQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return q.Querier.Query(q.driver.Rebind(query), args...)
+}
+
+func (q *reboundQuerier) QueryRow(query string, args ...interface{}) *sql.Row {
+	return q.Querier.QueryRow(q.driver.Rebind(query), args...)
+}
+
+// Insert runs an INSERT statement and returns the id of the inserted row.
+// PostgreSQL has no last insert id, the id is returned by the statement
+func (d Driver) Insert(conn Querier, query string, args ...interface{}) (int64, error) {
+	if d == PostgreSQL {
+		var id int64
+		err := conn.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	res, err := conn.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
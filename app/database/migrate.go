@@ -0,0 +1,475 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	cLog "image_gallery/logger"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+// migrationFiles holds a directory of migrations per driver
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationsLock is the name of the lock held by the instance migrating the database
+const migrationsLock = "image_gallery_migrations"
+
+// migrationFileName matches {version}_{name}.up.sql and {version}_{name}.down.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// MigrationConfig for database migrations
+type MigrationConfig struct {
+	// OnStart applies pending migrations when the server starts
+	OnStart bool `env:"DB_MIGRATE_ON_START" envDefault:"true"`
+	// LockTimeout is how long an instance waits for another one to finish migrating
+	LockTimeout time.Duration `env:"DB_MIGRATE_LOCK_TIMEOUT" envDefault:"1m"`
+}
+
+// Migration is a versioned change of the schema, Down reverts Up
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells if a migration is applied
+type MigrationStatus struct {
+	*Migration
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the migrations of a driver embedded in the binary, sorted by version
+func LoadMigrations(driver Driver) ([]*Migration, error) {
+	dir := path.Join("migrations", string(driver))
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list migrations: %v", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := migrationFileName.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(matches[1], 10, 64)
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %s: %v", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database. A lock is held while migrating
+// a MySQL database so that a single instance migrates at once, the others wait for it
+type Migrator struct {
+	Config     MigrationConfig
+	DB         *sql.DB
+	Driver     Driver
+	Migrations []*Migration
+	Logger     *cLog.Logger
+}
+
+// NewMigrator returns a migrator of the embedded migrations of driver configured by DB_MIGRATE_* env vars
+func NewMigrator(db *sql.DB, driver Driver, logger *cLog.Logger) (*Migrator, error) {
+	cfg := MigrationConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		return nil, fmt.Errorf("%+v", err)
+	}
+
+	migrations, err := LoadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		Config:     cfg,
+		DB:         db,
+		Driver:     driver,
+		Migrations: migrations,
+		Logger:     logger,
+	}, nil
+}
+
+// Up applies all pending migrations and returns how many were applied
+func (m *Migrator) Up() (int, error) {
+	applied := 0
+
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			m.Logger.Infof("applying migration %d_%s", migration.Version, migration.Name)
+			err = m.run(ctx, conn, migration.Up, func(db execer) error {
+				_, err := db.ExecContext(ctx, m.Driver.Rebind("INSERT INTO schema_migrations(version, name, applied_at)"+
+					" VALUES(?,?,?)"), migration.Version, migration.Name, time.Now())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("could not apply migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations and returns how many were reverted
+func (m *Migrator) Down(steps int) (int, error) {
+	reverted := 0
+
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted", migration.Version, migration.Name)
+			}
+
+			m.Logger.Infof("reverting migration %d_%s", migration.Version, migration.Name)
+			err = m.run(ctx, conn, migration.Down, func(db execer) error {
+				_, err := db.ExecContext(ctx, m.Driver.Rebind("DELETE FROM schema_migrations WHERE version=(?)"),
+					migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("could not revert migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status lists all migrations with the date they were applied. It only reads
+// the schema_migrations table, without waiting for an instance which is migrating
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	ctx := context.Background()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get a connection: %v", err)
+	}
+	defer conn.Close()
+
+	// no migration was applied before the table is created by Up
+	versions := make(map[int64]time.Time)
+	exists, err := m.migrationsTableExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if versions, err = m.appliedVersions(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []*MigrationStatus
+	for _, migration := range m.Migrations {
+		status := &MigrationStatus{Migration: migration}
+		if appliedAt, ok := versions[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// withLock runs fn on a connection holding the migrations lock, the
+// schema_migrations table is created if needed
+func (m *Migrator) withLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	// the lock belongs to the session, every statement must use the same connection
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get a connection: %v", err)
+	}
+	defer conn.Close()
+
+	// a SQLite database belongs to a single instance, there is no one to wait for
+	if m.Driver != SQLite {
+		if err = m.lock(ctx, conn); err != nil {
+			return err
+		}
+		defer m.unlock(ctx, conn)
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY NOT NULL,
+    name VARCHAR(255),
+    applied_at `+m.timestampType()+`
+)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema_migrations table: %v", err)
+	}
+
+	return fn(ctx, conn)
+}
+
+// timestampType is the column type of the date a migration was applied
+func (m *Migrator) timestampType() string {
+	if m.Driver == PostgreSQL {
+		return "TIMESTAMP WITH TIME ZONE"
+	}
+
+	return "DATETIME"
+}
+
+// lock acquires the migrations lock of the session of conn
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	if m.Driver == PostgreSQL {
+		return m.lockPostgreSQL(ctx, conn)
+	}
+
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationsLock,
+		int(m.Config.LockTimeout.Seconds())).Scan(&locked)
+	if err != nil {
+		return fmt.Errorf("could not acquire migrations lock: %v", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("could not acquire migrations lock within %v, another instance is migrating",
+			m.Config.LockTimeout)
+	}
+
+	return nil
+}
+
+// lockPostgreSQL acquires the migrations advisory lock of the session of conn,
+// waiting at most LockTimeout
+func (m *Migrator) lockPostgreSQL(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("SET lock_timeout = %d", m.Config.LockTimeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("could not acquire migrations lock: %v", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "RESET lock_timeout"); err != nil {
+			m.Logger.Errorf("could not reset lock timeout: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrationsLock)
+	if err != nil {
+		return fmt.Errorf("could not acquire migrations lock within %v, another instance is migrating: %v",
+			m.Config.LockTimeout, err)
+	}
+
+	return nil
+}
+
+// unlock releases the migrations lock of the session of conn
+func (m *Migrator) unlock(ctx context.Context, conn *sql.Conn) {
+	query := "SELECT RELEASE_LOCK(?)"
+	if m.Driver == PostgreSQL {
+		query = "SELECT pg_advisory_unlock(hashtext($1))"
+	}
+
+	var released sql.NullBool
+	if err := conn.QueryRowContext(ctx, query, migrationsLock).Scan(&released); err != nil {
+		m.Logger.Errorf("could not release migrations lock: %v", err)
+	}
+}
+
+// migrationsTableExists tells if the schema_migrations table was created
+func (m *Migrator) migrationsTableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE()" +
+		" AND table_name = 'schema_migrations'"
+	switch m.Driver {
+	case PostgreSQL:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema()" +
+			" AND table_name = 'schema_migrations'"
+	case SQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	}
+
+	var count int
+	if err := conn.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return false, fmt.Errorf("could not read applied migrations: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// appliedVersions returns the applied migrations versions with the date they were applied
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not read applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("could not read applied migrations: %v", err)
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+// execer runs statements on a connection or in a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// run runs the script of a migration then record, which keeps track of it.
+// PostgreSQL and SQLite run both in a transaction, so that a failing migration
+// leaves the schema as it was. MySQL commits schema changes immediately, a
+// migration failing halfway must be fixed by hand
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script string, record func(db execer) error) error {
+	if m.Driver == MySQL {
+		if err := execStatements(ctx, conn, script); err != nil {
+			return err
+		}
+		return record(conn)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	if err = execStatements(ctx, tx, script); err == nil {
+		err = record(tx)
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			return fmt.Errorf("%v, could not rollback transaction: %v", err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execStatements runs the statements of a migration one by one
+func execStatements(ctx context.Context, db execer, script string) error {
+	for _, statement := range splitStatements(script) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitStatements splits a SQL script on semicolons outside of quotes,
+// comments are removed
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	var quote byte
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+
+		switch {
+		case quote != 0:
+			current.WriteByte(c)
+			if c == '\\' && i+1 < len(script) {
+				i++
+				current.WriteByte(script[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteByte(c)
+		case c == '-' && strings.HasPrefix(script[i:], "--"), c == '#':
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			current.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case c == ';':
+			statements = appendStatement(statements, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	return appendStatement(statements, current.String())
+}
+
+func appendStatement(statements []string, statement string) []string {
+	if statement = strings.TrimSpace(statement); statement != "" {
+		statements = append(statements, statement)
+	}
+
+	return statements
+}
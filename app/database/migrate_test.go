@@ -0,0 +1,296 @@
+package database
+
+import (
+	cLog "image_gallery/logger"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestMigrator returns a migrator of the SQLite migrations on a database in a temporary directory
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "gallery.db"))
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := NewMigrator(db, SQLite, cLog.GetLogger())
+	if err != nil {
+		t.Fatalf("could not create migrator: %v", err)
+	}
+
+	return migrator
+}
+
+// tableExists tells if the database of migrator has a table
+func tableExists(t *testing.T, migrator *Migrator, table string) bool {
+	t.Helper()
+
+	var count int
+	err := migrator.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).
+		Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count > 0
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []Driver{MySQL, SQLite, PostgreSQL} {
+		migrations, err := LoadMigrations(driver)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+
+		for i, migration := range migrations {
+			if migration.Version != int64(i+1) {
+				t.Errorf("%s: migration %d_%s is not numbered %d", driver, migration.Version, migration.Name, i+1)
+			}
+			if migration.Down == "" {
+				t.Errorf("%s: migration %d_%s cannot be reverted", driver, migration.Version, migration.Name)
+			}
+		}
+	}
+
+	mysql, _ := LoadMigrations(MySQL)
+	for _, driver := range []Driver{SQLite, PostgreSQL} {
+		migrations, _ := LoadMigrations(driver)
+		if len(migrations) != len(mysql) {
+			t.Fatalf("%s has %d migrations, MySQL has %d", driver, len(migrations), len(mysql))
+		}
+		for i, migration := range migrations {
+			if migration.Name != mysql[i].Name {
+				t.Errorf("%s migration %d is %s, MySQL one is %s", driver, migration.Version, migration.Name,
+					mysql[i].Name)
+			}
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	migrator := newTestMigrator(t)
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrator.Migrations) {
+		t.Fatalf("applied %d migrations, want %d", applied, len(migrator.Migrations))
+	}
+	for _, table := range []string{"category", "image", "tag", "image_tag", "image_rendition", "tag_alias"} {
+		if !tableExists(t, migrator, table) {
+			t.Errorf("table %s not created", table)
+		}
+	}
+
+	if applied, err = migrator.Up(); err != nil || applied != 0 {
+		t.Fatalf("applied %d migrations again: %v", applied, err)
+	}
+
+	// each migration is reverted then applied again
+	for range migrator.Migrations {
+		if _, err = migrator.Down(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tableExists(t, migrator, "image") {
+		t.Fatal("table image not dropped")
+	}
+	if applied, err = migrator.Up(); err != nil || applied != len(migrator.Migrations) {
+		t.Fatalf("applied %d migrations after reverting them: %v", applied, err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d_%s not applied", status.Version, status.Name)
+		}
+	}
+}
+
+func TestFailingMigrationRolledBack(t *testing.T) {
+	migrator := newTestMigrator(t)
+	migrator.Migrations = []*Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first (id INTEGER);", Down: "DROP TABLE first;"},
+		{Version: 2, Name: "failing", Up: "CREATE TABLE second (id INTEGER);\nINSERT INTO missing VALUES (1);"},
+	}
+
+	applied, err := migrator.Up()
+	if err == nil {
+		t.Fatal("failing migration applied")
+	}
+	if applied != 1 {
+		t.Fatalf("applied %d migrations, want 1", applied)
+	}
+	if !tableExists(t, migrator, "first") {
+		t.Fatal("migration before the failing one not applied")
+	}
+	if tableExists(t, migrator, "second") {
+		t.Fatal("failing migration not rolled back")
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil {
+		t.Fatal("failing migration recorded as applied")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"empty", " \n ", nil},
+		{"single without semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"several", "SELECT 1;\n\nSELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"block comment", "/* a; b */\nSELECT 1;", []string{"SELECT 1"}},
+		{"line comments", "-- a; b\nSELECT 1; # c;\nSELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"quoted semicolon", "INSERT INTO t VALUES ('a;b');", []string{"INSERT INTO t VALUES ('a;b')"}},
+		{"escaped quote", `INSERT INTO t VALUES ('a\';b');`, []string{`INSERT INTO t VALUES ('a\';b')`}},
+		{"quoted identifier", "SELECT `a;b` FROM t;", []string{"SELECT `a;b` FROM t"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := splitStatements(test.script); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUniqueTagNameMigrationMergesDuplicates(t *testing.T) {
+	migrator := newTestMigrator(t)
+	migrations := migrator.Migrations
+
+	// tags were saved without unique names before migration 11
+	migrator.Migrations = migrations[:10]
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"INSERT INTO category (id, name) VALUES (1, 'animals')",
+		"INSERT INTO image (id, name, slug, category_id) VALUES (1, 'cat', 'cat', 1), (2, 'kitten', 'kitten', 1)",
+		"INSERT INTO tag (id, name) VALUES (1, 'cat'), (2, 'dog'), (3, 'Cat'), (4, 'CAT')",
+		"INSERT INTO image_tag (image_id, tag_id) VALUES (1, 1), (1, 2), (2, 3), (2, 4)",
+		"INSERT INTO tag_alias (name, tag_id) VALUES ('kitty', 4)",
+	} {
+		if _, err := migrator.DB.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrator.Migrations = migrations
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	links := make(map[[2]int64]bool)
+	rows, err := migrator.DB.Query("SELECT image_id, tag_id FROM image_tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link [2]int64
+		if err = rows.Scan(&link[0], &link[1]); err != nil {
+			t.Fatal(err)
+		}
+		links[link] = true
+	}
+	if !reflect.DeepEqual(links, map[[2]int64]bool{{1, 1}: true, {1, 2}: true, {2, 1}: true}) {
+		t.Fatalf("unexpected image tags %v", links)
+	}
+
+	var tags, aliasTag int64
+	if err = migrator.DB.QueryRow("SELECT COUNT(*) FROM tag").Scan(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if err = migrator.DB.QueryRow("SELECT tag_id FROM tag_alias WHERE name = 'kitty'").Scan(&aliasTag); err != nil {
+		t.Fatal(err)
+	}
+	if tags != 2 || aliasTag != 1 {
+		t.Fatalf("got %d tags and alias of tag %d, want 2 tags and alias of tag 1", tags, aliasTag)
+	}
+}
+
+func TestImageTagPrimaryKeyMigrationRemovesDuplicates(t *testing.T) {
+	migrator := newTestMigrator(t)
+	migrations := migrator.Migrations
+
+	// images could be linked several times to a tag before migration 13
+	migrator.Migrations = migrations[:12]
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"INSERT INTO category (id, name) VALUES (1, 'animals')",
+		"INSERT INTO image (id, name, slug, category_id) VALUES (1, 'cat', 'cat', 1)",
+		"INSERT INTO tag (id, name) VALUES (1, 'cat'), (2, 'cute')",
+		"INSERT INTO image_tag (image_id, tag_id) VALUES (1, 1), (1, 2), (1, 1), (1, 1)",
+	} {
+		if _, err := migrator.DB.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrator.Migrations = migrations
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	var links int
+	if err := migrator.DB.QueryRow("SELECT COUNT(*) FROM image_tag").Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 2 {
+		t.Fatalf("got %d image tags, want 2", links)
+	}
+	if _, err := migrator.DB.Exec("INSERT INTO image_tag (image_id, tag_id) VALUES (1, 2)"); err == nil {
+		t.Fatal("image linked twice to a tag")
+	}
+
+	// links are still removed with their image
+	if _, err := migrator.DB.Exec("DELETE FROM image WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.DB.QueryRow("SELECT COUNT(*) FROM image_tag").Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 0 {
+		t.Fatalf("got %d image tags of a deleted image", links)
+	}
+}
+
+func TestStatusOfNewDatabase(t *testing.T) {
+	migrator := newTestMigrator(t)
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrator.Migrations) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(migrator.Migrations))
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %d_%s applied to a new database", status.Version, status.Name)
+		}
+	}
+
+	// reading the status does not write to the database
+	if tableExists(t, migrator, "schema_migrations") {
+		t.Error("table schema_migrations created by status")
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS image_tag;
+DROP TABLE IF EXISTS tag;
+DROP TABLE IF EXISTS image;
+DROP TABLE IF EXISTS category;
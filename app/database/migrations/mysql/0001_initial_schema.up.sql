@@ -0,0 +1,49 @@
+/*
+    Initial schema, as created by the former docker init script
+
+    Tables:
+    * category : stores categories (id, name, desc, creation, update)
+    * image : stores images (id, name, desc, type, creation, update, category ID)
+    * tag : stores tags (id, name, creation date)
+    * image_tag : links images to tags by ids (Many to Many relation)
+
+    Tables are only created if missing, so databases created by the former docker init script are kept as is
+*/
+CREATE TABLE IF NOT EXISTS category (
+    id INT PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255),
+    description TEXT,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS image (
+    id INT PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255),
+    slug VARCHAR(255) UNIQUE,
+    description TEXT,
+    type VARCHAR(10),
+    created_at DATETIME,
+    updated_at DATETIME,
+    category_id INT, 
+    FOREIGN KEY (category_id) 
+        REFERENCES category(id)   
+        ON DELETE CASCADE 
+);
+
+CREATE TABLE IF NOT EXISTS tag (
+    id INT PRIMARY KEY NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255),
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS image_tag (
+    image_id INT,
+    tag_id INT,
+    FOREIGN KEY (image_id) 
+        REFERENCES image(id)
+        ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) 
+        REFERENCES tag(id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE image_metadata DROP COLUMN private;
+
+ALTER TABLE category DROP COLUMN strip_metadata;
@@ -0,0 +1,12 @@
+/*
+    Privacy upload mode
+
+    Columns:
+    * category.strip_metadata : metadata are stripped from the files uploaded to the category
+    * image_metadata.private : the metadata were stripped from the stored file, they are redacted
+      unless requested by the owner
+*/
+
+ALTER TABLE category ADD COLUMN strip_metadata BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE image_metadata ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE category DROP COLUMN max_upload_size;
@@ -0,0 +1,3 @@
+DROP INDEX image_hash ON image;
+
+ALTER TABLE image DROP COLUMN hash;
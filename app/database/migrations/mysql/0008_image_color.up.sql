@@ -0,0 +1,20 @@
+/*
+    Color palettes
+
+    Tables:
+    * image_color : dominant colors of an image file, with their L*a*b* coordinates
+*/
+
+CREATE TABLE IF NOT EXISTS image_color (
+    image_id INT NOT NULL,
+    position INT NOT NULL,
+    color CHAR(7),
+    weight DOUBLE,
+    lab_l DOUBLE,
+    lab_a DOUBLE,
+    lab_b DOUBLE,
+    PRIMARY KEY (image_id, position),
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE
+);
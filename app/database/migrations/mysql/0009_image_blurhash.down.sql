@@ -0,0 +1,5 @@
+ALTER TABLE image DROP COLUMN blurhash;
+
+ALTER TABLE image DROP COLUMN height;
+
+ALTER TABLE image DROP COLUMN width;
@@ -0,0 +1,16 @@
+/*
+    Tag aliases
+
+    Tables:
+    * tag_alias : other names of a tag (synonyms, plurals, former names of merged tags), resolved to the tag when
+      images are tagged or filtered
+*/
+
+CREATE TABLE IF NOT EXISTS tag_alias (
+    name VARCHAR(255) PRIMARY KEY NOT NULL,
+    tag_id INT NOT NULL,
+    created_at DATETIME,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+        ON DELETE CASCADE
+);
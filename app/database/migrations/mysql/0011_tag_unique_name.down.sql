@@ -0,0 +1 @@
+DROP INDEX tag_name_unique ON tag;
@@ -0,0 +1,3 @@
+ALTER TABLE tag DROP FOREIGN KEY tag_parent_fk;
+
+ALTER TABLE tag DROP COLUMN parent_id;
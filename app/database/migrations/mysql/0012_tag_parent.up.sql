@@ -0,0 +1,13 @@
+/*
+    Hierarchical tags
+
+    Columns:
+    * tag.parent_id : the parent of a tag, null for the tags at the root of the tree (animals > cat > kitten)
+*/
+
+ALTER TABLE tag ADD COLUMN parent_id INT NULL;
+
+ALTER TABLE tag ADD CONSTRAINT tag_parent_fk
+    FOREIGN KEY (parent_id)
+    REFERENCES tag(id)
+    ON DELETE SET NULL;
@@ -0,0 +1,52 @@
+/*
+    Initial schema, the PostgreSQL equivalent of the MySQL one
+
+    Tables:
+    * category : stores categories (id, name, desc, creation, update)
+    * image : stores images (id, name, desc, type, creation, update, category ID)
+    * tag : stores tags (id, name, creation date)
+    * image_tag : links images to tags by ids (Many to Many relation)
+
+    Tag names are compared ignoring case, as with the default MySQL collation, through the tag_name index
+*/
+
+CREATE TABLE IF NOT EXISTS category (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255),
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS image (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255),
+    slug VARCHAR(255) UNIQUE,
+    description TEXT,
+    type VARCHAR(10),
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    category_id INT,
+    FOREIGN KEY (category_id)
+        REFERENCES category(id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tag (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS tag_name ON tag (LOWER(name));
+
+CREATE TABLE IF NOT EXISTS image_tag (
+    image_id INT,
+    tag_id INT,
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+);
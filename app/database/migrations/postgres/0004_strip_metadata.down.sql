@@ -0,0 +1,3 @@
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS private;
+
+ALTER TABLE category DROP COLUMN IF EXISTS strip_metadata;
@@ -0,0 +1 @@
+ALTER TABLE category DROP COLUMN IF EXISTS max_upload_size;
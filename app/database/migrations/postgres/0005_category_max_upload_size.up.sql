@@ -0,0 +1,8 @@
+/*
+    Upload size limit per category
+
+    Columns:
+    * category.max_upload_size : the largest file uploaded to the category in bytes, null for the global limit
+*/
+
+ALTER TABLE category ADD COLUMN IF NOT EXISTS max_upload_size BIGINT NULL;
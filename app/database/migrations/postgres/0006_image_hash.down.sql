@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS image_hash;
+
+ALTER TABLE image DROP COLUMN IF EXISTS hash;
@@ -0,0 +1,13 @@
+/*
+    Content addressed storage
+
+    Columns:
+    * image.hash : SHA-256 of the image file, the key of the stored file shared by images of the same file
+
+    Indexes:
+    * image_hash : finds the images of a file
+*/
+
+ALTER TABLE image ADD COLUMN IF NOT EXISTS hash CHAR(64) NULL;
+
+CREATE INDEX IF NOT EXISTS image_hash ON image (hash);
@@ -0,0 +1,8 @@
+/*
+    Similar images
+
+    Columns:
+    * image.perceptual_hash : perceptual hash of the image file, with few different bits for similar images
+*/
+
+ALTER TABLE image ADD COLUMN IF NOT EXISTS perceptual_hash BIGINT NULL;
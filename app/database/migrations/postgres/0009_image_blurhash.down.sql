@@ -0,0 +1,5 @@
+ALTER TABLE image DROP COLUMN IF EXISTS blurhash;
+
+ALTER TABLE image DROP COLUMN IF EXISTS height;
+
+ALTER TABLE image DROP COLUMN IF EXISTS width;
@@ -0,0 +1,13 @@
+/*
+    Image placeholders
+
+    Columns:
+    * image.width, image.height : size of the image file once oriented
+    * image.blurhash : BlurHash placeholder of the image file
+*/
+
+ALTER TABLE image ADD COLUMN IF NOT EXISTS width INT NULL;
+
+ALTER TABLE image ADD COLUMN IF NOT EXISTS height INT NULL;
+
+ALTER TABLE image ADD COLUMN IF NOT EXISTS blurhash VARCHAR(64) NULL;
@@ -0,0 +1,20 @@
+/*
+    Tag aliases
+
+    Tables:
+    * tag_alias : other names of a tag (synonyms, plurals, former names of merged tags), resolved to the tag when
+      images are tagged or filtered
+
+    Alias names are compared ignoring case, as tag names, the tag_alias_name index keeps them unique
+*/
+
+CREATE TABLE IF NOT EXISTS tag_alias (
+    name VARCHAR(255) PRIMARY KEY NOT NULL,
+    tag_id INT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+        ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tag_alias_name ON tag_alias (LOWER(name));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tag_name_unique;
+
+CREATE INDEX IF NOT EXISTS tag_name ON tag (LOWER(name));
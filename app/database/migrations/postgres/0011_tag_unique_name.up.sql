@@ -0,0 +1,36 @@
+/*
+    Unique tag names
+
+    Indexes:
+    * tag_name_unique : tag names are unique ignoring case, it replaces the tag_name index
+
+    Tags sharing a name are merged into the oldest of them: their images and aliases are moved to it, as by the
+    tags cleanup command. The merge is not reverted by the down migration
+*/
+
+CREATE TABLE tag_duplicate (
+    id INT PRIMARY KEY NOT NULL,
+    tag_id INT NOT NULL
+);
+
+INSERT INTO tag_duplicate (id, tag_id)
+    SELECT t.id, MIN(kept.id)
+    FROM tag t
+    JOIN tag kept ON LOWER(kept.name) = LOWER(t.name) AND kept.id < t.id
+    GROUP BY t.id;
+
+UPDATE image_tag
+    SET tag_id = (SELECT d.tag_id FROM tag_duplicate d WHERE d.id = image_tag.tag_id)
+    WHERE tag_id IN (SELECT id FROM tag_duplicate);
+
+UPDATE tag_alias
+    SET tag_id = (SELECT d.tag_id FROM tag_duplicate d WHERE d.id = tag_alias.tag_id)
+    WHERE tag_id IN (SELECT id FROM tag_duplicate);
+
+DELETE FROM tag WHERE id IN (SELECT id FROM tag_duplicate);
+
+DROP TABLE tag_duplicate;
+
+DROP INDEX IF EXISTS tag_name;
+
+CREATE UNIQUE INDEX IF NOT EXISTS tag_name_unique ON tag (LOWER(name));
@@ -0,0 +1,15 @@
+CREATE TABLE image_tag_without_key (
+    image_id INT,
+    tag_id INT,
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+);
+
+INSERT INTO image_tag_without_key (image_id, tag_id) SELECT image_id, tag_id FROM image_tag;
+
+DROP TABLE image_tag;
+
+ALTER TABLE image_tag_without_key RENAME TO image_tag;
@@ -0,0 +1,50 @@
+/*
+    Initial schema, the SQLite equivalent of the MySQL one
+
+    Tables:
+    * category : stores categories (id, name, desc, creation, update)
+    * image : stores images (id, name, desc, type, creation, update, category ID)
+    * tag : stores tags (id, name, creation date)
+    * image_tag : links images to tags by ids (Many to Many relation)
+
+    Tag names are compared ignoring case, as with the default MySQL collation
+*/
+
+CREATE TABLE IF NOT EXISTS category (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255),
+    description TEXT,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS image (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255),
+    slug VARCHAR(255) UNIQUE,
+    description TEXT,
+    type VARCHAR(10),
+    created_at DATETIME,
+    updated_at DATETIME,
+    category_id INTEGER,
+    FOREIGN KEY (category_id)
+        REFERENCES category(id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tag (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) COLLATE NOCASE,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS image_tag (
+    image_id INTEGER,
+    tag_id INTEGER,
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+);
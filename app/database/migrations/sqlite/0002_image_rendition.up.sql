@@ -0,0 +1,18 @@
+/*
+    Image renditions
+
+    Tables:
+    * image_rendition : resized copies of an image generated on upload
+*/
+
+CREATE TABLE IF NOT EXISTS image_rendition (
+    image_id INTEGER NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    path VARCHAR(255),
+    width INT,
+    height INT,
+    PRIMARY KEY (image_id, name),
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,27 @@
+/*
+    Image metadata
+
+    Tables:
+    * image_metadata : EXIF/XMP/IPTC informations extracted from an image file
+*/
+
+CREATE TABLE IF NOT EXISTS image_metadata (
+    image_id INTEGER PRIMARY KEY NOT NULL,
+    camera_make VARCHAR(255),
+    camera_model VARCHAR(255),
+    lens VARCHAR(255),
+    exposure_time VARCHAR(20),
+    f_number DOUBLE,
+    iso INT,
+    focal_length DOUBLE,
+    captured_at DATETIME NULL,
+    orientation INT,
+    latitude DOUBLE NULL,
+    longitude DOUBLE NULL,
+    width INT,
+    height INT,
+    copyright VARCHAR(255),
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE
+);
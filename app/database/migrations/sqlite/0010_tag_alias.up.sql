@@ -0,0 +1,18 @@
+/*
+    Tag aliases
+
+    Tables:
+    * tag_alias : other names of a tag (synonyms, plurals, former names of merged tags), resolved to the tag when
+      images are tagged or filtered
+
+    Alias names are compared ignoring case, as tag names
+*/
+
+CREATE TABLE IF NOT EXISTS tag_alias (
+    name VARCHAR(255) COLLATE NOCASE PRIMARY KEY NOT NULL,
+    tag_id INTEGER NOT NULL,
+    created_at DATETIME,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+        ON DELETE CASCADE
+);
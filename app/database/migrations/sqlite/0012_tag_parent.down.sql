@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tag_parent;
+
+ALTER TABLE tag DROP COLUMN parent_id;
@@ -0,0 +1,10 @@
+/*
+    Hierarchical tags
+
+    Columns:
+    * tag.parent_id : the parent of a tag, null for the tags at the root of the tree (animals > cat > kitten)
+*/
+
+ALTER TABLE tag ADD COLUMN parent_id INTEGER REFERENCES tag(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS tag_parent ON tag (parent_id);
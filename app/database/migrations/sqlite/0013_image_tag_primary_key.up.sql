@@ -0,0 +1,26 @@
+/*
+    Image tags primary key
+
+    Keys:
+    * image_tag (image_id, tag_id) : an image is linked once to a tag
+
+    The table is copied without its duplicate links, as no driver adds a primary key to a table holding duplicates
+*/
+
+CREATE TABLE image_tag_unique (
+    image_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (image_id, tag_id),
+    FOREIGN KEY (image_id)
+        REFERENCES image(id)
+        ON DELETE CASCADE,
+    FOREIGN KEY (tag_id)
+        REFERENCES tag(id)
+);
+
+INSERT INTO image_tag_unique (image_id, tag_id)
+    SELECT DISTINCT image_id, tag_id FROM image_tag WHERE image_id IS NOT NULL AND tag_id IS NOT NULL;
+
+DROP TABLE image_tag;
+
+ALTER TABLE image_tag_unique RENAME TO image_tag;
@@ -0,0 +1,12 @@
+package database
+
+import "database/sql"
+
+// Querier runs queries on the connection pool or inside a transaction, it is
+// implemented by *sql.DB and *sql.Tx
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
@@ -1,10 +1,12 @@
 package helpers
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 // StatusResponse is a status response returned by an API endpoint
@@ -71,3 +73,30 @@ func WriteErrorJSON(w http.ResponseWriter, status int, message string) {
 		Message: message,
 	})
 }
+
+// OwnerTokenHeader is the header used to authenticate the gallery owner
+const OwnerTokenHeader = "X-Owner-Token"
+
+// IsOwner checks if the request is authenticated with the owner token, always false when token is empty
+func IsOwner(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(OwnerTokenHeader)), []byte(token)) == 1
+}
+
+// ParseLimit reads the limit query parameter, defaultLimit when it is not set
+func ParseLimit(r *http.Request, defaultLimit int, maxLimit int) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, fmt.Errorf("limit must be an integer between 1 and %d", maxLimit)
+	}
+
+	return limit, nil
+}
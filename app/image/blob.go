@@ -0,0 +1,260 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image_gallery/imaging"
+	"image_gallery/storage"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// blobsPrefix is the storage prefix of files stored by content hash
+const blobsPrefix = "blobs/"
+
+// BlobKey returns the storage key of a file stored by its SHA-256 content hash,
+// blobs are spread in directories named after the first hash characters. The
+// suffix is the extension, preceded by the rendition name for renditions
+func BlobKey(hash string, suffix string) string {
+	return blobsPrefix + hash[:2] + "/" + hash + suffix
+}
+
+// duplicateError is returned when the file of an image to create is already
+// stored for another image
+type duplicateError struct {
+	Image *Image
+}
+
+func (e *duplicateError) Error() string {
+	return fmt.Sprintf("file is already stored for image %d", e.Image.ID)
+}
+
+// preparedFile is an uploaded file ready to be stored, with metadata stripped
+// if requested and its content hash
+type preparedFile struct {
+	// Original is the file as uploaded, renditions are generated from it
+	Original io.ReadSeeker
+	// Content is the file to store
+	Content  io.ReadSeeker
+	Size     int64
+	Hash     string
+	Metadata *imaging.Metadata
+	stripped *os.File
+
+	// the fields below are set by storeBlob, from the decoded file or from
+	// the image already storing the same content
+	PerceptualHash *uint64
+	Width          int
+	Height         int
+	BlurHash       string
+	Palette        []imaging.PaletteColor
+	Renditions     map[string]*Rendition
+	// stored is true when storeBlob wrote the file and its renditions, false
+	// when they were already stored for another image
+	stored bool
+}
+
+// Close removes the temporary stripped file
+func (prepared *preparedFile) Close() {
+	removeTempFile(prepared.stripped)
+}
+
+// prepareFile extracts the metadata of a validated file, strips them if
+// requested and hashes the content to store
+func (h *Handler) prepareFile(file io.ReadSeeker, size int64, options uploadOptions) (*preparedFile, error) {
+	metadata, err := imaging.ExtractMetadata(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not extract metadata: %v", err)
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not rewind file: %v", err)
+	}
+
+	prepared := &preparedFile{Original: file, Content: file, Size: size}
+
+	if options.StripMetadata {
+		prepared.stripped, err = ioutil.TempFile(h.Upload.TmpPath, "stripped-")
+		if err != nil {
+			return nil, fmt.Errorf("could not create temporary file: %v", err)
+		}
+
+		err = imaging.StripMetadata(prepared.stripped, file, metadata.Orientation)
+		if err == nil {
+			prepared.Size, err = prepared.stripped.Seek(0, io.SeekCurrent)
+		}
+		if err != nil {
+			prepared.Close()
+			if _, ok := err.(*imaging.ValidationError); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("could not strip metadata: %v", err)
+		}
+		prepared.Content = prepared.stripped
+
+		if options.KeepMetadata {
+			metadata.Private = true
+		} else {
+			metadata = metadata.Redacted()
+		}
+	}
+	prepared.Metadata = metadata
+
+	prepared.Hash, err = hashContent(prepared.Content)
+	if err != nil {
+		prepared.Close()
+		return nil, err
+	}
+
+	return prepared, nil
+}
+
+// storeBlob writes a prepared file and its renditions under its content hash
+// and computes the values saved with its image. Decoding and encoding take
+// time, so it runs before the rows of the file are saved in a transaction.
+// When the same content is already stored for an image other than imageID,
+// its values are reused and nothing is written
+func (h *Handler) storeBlob(prepared *preparedFile, info *imaging.Info, imageID int64) error {
+	repository := h.Store.Images()
+
+	linked, err := repository.SelectImageByHash(prepared.Hash, imageID)
+	if err != nil {
+		return fmt.Errorf("could not check if file is already stored: %v", err)
+	}
+
+	if linked != nil {
+		prepared.PerceptualHash = linked.PerceptualHash
+		prepared.Width, prepared.Height, prepared.BlurHash = linked.Width, linked.Height, linked.BlurHash
+
+		prepared.Palette, err = repository.SelectPaletteByImageID(linked.ID)
+		if err != nil {
+			return fmt.Errorf("could not get linked palette: %v", err)
+		}
+		prepared.Renditions, err = repository.SelectRenditionsByImageID(linked.ID)
+		if err != nil {
+			return fmt.Errorf("could not get linked renditions: %v", err)
+		}
+
+		return nil
+	}
+
+	if _, err = prepared.Original.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not rewind file: %v", err)
+	}
+
+	src, err := imaging.Decode(prepared.Original)
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+
+	perceptualHash := imaging.PerceptualHash(src.Image())
+	prepared.PerceptualHash = &perceptualHash
+
+	// placeholders are displayed before the file, with its orientation
+	bounds := src.Image().Bounds()
+	prepared.Width, prepared.Height = imaging.OrientedSize(bounds.Dx(), bounds.Dy(), prepared.Metadata.Orientation)
+	prepared.BlurHash = imaging.BlurHash(src.Image(), prepared.Metadata.Orientation)
+	prepared.Palette = imaging.Palette(src.Image())
+
+	// decoding read the original, which is also the content unless metadata was stripped
+	if _, err = prepared.Content.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not rewind file: %v", err)
+	}
+
+	blob := &Image{Hash: prepared.Hash, Type: info.Format.Extension()}
+	err = h.Storage.Put(FileKey(blob), prepared.Content, prepared.Size, info.Format.MimeType)
+	if err != nil {
+		return fmt.Errorf("could not write file: %v", err)
+	}
+	prepared.stored = true
+
+	prepared.Renditions, err = h.generateRenditions(src, prepared.Metadata.Orientation, blob)
+	if err != nil {
+		h.removeBlob(prepared.Hash)
+		return fmt.Errorf("could not generate renditions: %v", err)
+	}
+
+	return nil
+}
+
+// hashContent returns the hex encoded SHA-256 hash of r and rewinds it
+func hashContent(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("could not rewind file: %v", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", fmt.Errorf("could not hash file: %v", err)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("could not rewind file: %v", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findDuplicate returns the image whose stored file has the given hash, with
+// its tags and renditions, or nil
+func findDuplicate(store Store, hash string) (*Image, error) {
+	repository := store.Images()
+
+	duplicate, err := repository.SelectImageByHash(hash, 0)
+	if err != nil || duplicate == nil {
+		return nil, err
+	}
+
+	duplicate.TagsNames, err = store.Tags().GetAllTagsByImageID(duplicate.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicate.Renditions, err = repository.SelectRenditionsByImageID(duplicate.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return duplicate, nil
+}
+
+// fileShared is true when the stored file of an image is also used by another
+// image, it must then be kept when the image is deleted
+func fileShared(repository Repository, image *Image) (bool, error) {
+	if image.Hash == "" {
+		return false, nil
+	}
+
+	other, err := repository.SelectImageByHash(image.Hash, image.ID)
+	if err != nil {
+		return false, fmt.Errorf("could not check if file is shared: %v", err)
+	}
+
+	return other != nil, nil
+}
+
+// removeBlob deletes a stored file and its renditions when no image uses it
+func (h *Handler) removeBlob(hash string) {
+	shared, err := fileShared(h.Store.Images(), &Image{Hash: hash})
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+	if shared {
+		return
+	}
+
+	files, err := h.Storage.List(BlobKey(hash, ""))
+	if err != nil {
+		h.Logger.Errorf("could not list blob files: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		if err = h.Storage.Delete(file.Key); err != nil && err != storage.ErrNotExist {
+			h.Logger.Errorf("could not delete blob file %s: %v", file.Key, err)
+		}
+	}
+}
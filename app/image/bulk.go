@@ -0,0 +1,280 @@
+package image
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image_gallery/category"
+	"image_gallery/helpers"
+	"image_gallery/imaging"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var zipSignature = []byte("PK\x03\x04")
+
+// bulkResult is the outcome of one file of a bulk upload
+type bulkResult struct {
+	File  string `json:"file"`
+	Image *Image `json:"image,omitempty"`
+	// Duplicate is true when Image is an existing image with the same file
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// bulkReport lists the outcome of every file of a bulk upload
+type bulkReport struct {
+	Succeeded  int           `json:"succeeded"`
+	Duplicates int           `json:"duplicates"`
+	Failed     int           `json:"failed"`
+	Results    []*bulkResult `json:"results"`
+}
+
+func (report *bulkReport) add(result *bulkResult) {
+	switch {
+	case result.Error != "":
+		report.Failed++
+	case result.Duplicate:
+		report.Duplicates++
+	default:
+		report.Succeeded++
+	}
+	report.Results = append(report.Results, result)
+}
+
+// bulkUpload holds what is shared by all the files of a bulk upload
+type bulkUpload struct {
+	Category *category.Category
+	Tags     []string
+	Options  uploadOptions
+	Report   bulkReport
+}
+
+// full is true when no more files can be added to the upload
+func (upload *bulkUpload) full(maxFiles int) bool {
+	return len(upload.Report.Results) >= maxFiles
+}
+
+// bulkUploadImages creates an image for every file of a multipart request, zip
+// archives are extracted. Shared form values must be sent before the files
+func (h *Handler) bulkUploadImages(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Request must be multipart/form-data")
+		return
+	}
+
+	values := url.Values{}
+	valuesSize := int64(0)
+	var upload *bulkUpload
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.Logger.Infof("could not read bulk upload: %v", err)
+			if upload == nil {
+				helpers.WriteErrorJSON(w, http.StatusBadRequest, "Invalid multipart form data")
+				return
+			}
+			// files received so far are reported
+			upload.Report.add(&bulkResult{Error: "invalid multipart form data, following files were not read"})
+			break
+		}
+
+		if part.FileName() == "" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValuesSize-valuesSize+1))
+			if err != nil {
+				helpers.WriteErrorJSON(w, http.StatusBadRequest, "Invalid multipart form data")
+				return
+			}
+
+			valuesSize += int64(len(value))
+			if valuesSize > maxFormValuesSize {
+				helpers.WriteErrorJSON(w, http.StatusRequestEntityTooLarge, "Form values are too large")
+				return
+			}
+
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if upload == nil {
+			var status int
+			if upload, status, err = h.newBulkUpload(values); err != nil {
+				helpers.WriteErrorJSON(w, status, err.Error())
+				return
+			}
+		}
+
+		if upload.full(h.Upload.MaxBulkFiles) {
+			upload.Report.add(&bulkResult{
+				File:  part.FileName(),
+				Error: fmt.Sprintf("bulk uploads are limited to %d files, following files were not read", h.Upload.MaxBulkFiles),
+			})
+			break
+		}
+
+		h.receiveBulkPart(upload, part)
+	}
+
+	if upload == nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Missing files")
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, upload.Report)
+}
+
+// newBulkUpload reads the category, tags and upload options shared by all files
+func (h *Handler) newBulkUpload(values url.Values) (*bulkUpload, int, error) {
+	categoryID, err := helpers.ParseInt64(values.Get("category_id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("category_id must be an integer sent before the files")
+	}
+
+	imageCategory, err := h.Store.Categories().SelectCategoryByID(categoryID)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image category : %v", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("unable to retrieve image category")
+	}
+	if imageCategory == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("category does not exist")
+	}
+
+	options, err := parseUploadOptions(values.Get, imageCategory)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	return &bulkUpload{
+		Category: imageCategory,
+		Tags:     formTags(values),
+		Options:  options,
+		Report:   bulkReport{Results: []*bulkResult{}},
+	}, 0, nil
+}
+
+// receiveBulkPart creates an image from a file part, or from every file of a zip archive
+func (h *Handler) receiveBulkPart(upload *bulkUpload, part *multipart.Part) {
+	name := part.FileName()
+	br := bufio.NewReader(part)
+
+	if magic, _ := br.Peek(len(zipSignature)); !bytes.Equal(magic, zipSignature) {
+		upload.Report.add(h.createBulkImage(upload, name, br))
+		return
+	}
+
+	archive, size, err := h.copyToTempFile(br, h.Upload.MaxArchiveSize)
+	if err != nil {
+		upload.Report.add(h.bulkFailure(name, err, h.Upload.MaxArchiveSize))
+		return
+	}
+	defer removeTempFile(archive)
+
+	zipReader, err := zip.NewReader(archive, size)
+	if err != nil {
+		upload.Report.add(&bulkResult{File: name, Error: "file is not a valid zip archive"})
+		return
+	}
+
+	for _, entry := range zipReader.File {
+		if entry.FileInfo().IsDir() || isHiddenPath(entry.Name) {
+			continue
+		}
+
+		if upload.full(h.Upload.MaxBulkFiles) {
+			upload.Report.add(&bulkResult{
+				File:  name + "/" + entry.Name,
+				Error: fmt.Sprintf("bulk uploads are limited to %d files, following files were not read", h.Upload.MaxBulkFiles),
+			})
+			return
+		}
+
+		upload.Report.add(h.createBulkArchiveImage(upload, name, entry))
+	}
+}
+
+func (h *Handler) createBulkArchiveImage(upload *bulkUpload, archiveName string, entry *zip.File) *bulkResult {
+	content, err := entry.Open()
+	if err != nil {
+		return &bulkResult{File: archiveName + "/" + entry.Name, Error: "could not read file from archive"}
+	}
+	defer content.Close()
+
+	result := h.createBulkImage(upload, entry.Name, content)
+	result.File = archiveName + "/" + entry.Name
+
+	return result
+}
+
+// createBulkImage creates an image named after its file
+func (h *Handler) createBulkImage(upload *bulkUpload, name string, content io.Reader) *bulkResult {
+	maxSize := h.Upload.MaxSizeFor(upload.Category)
+
+	file, size, err := h.copyToTempFile(content, maxSize)
+	if err != nil {
+		return h.bulkFailure(name, err, maxSize)
+	}
+	defer removeTempFile(file)
+
+	imageToCreate := &Image{
+		Name:       path.Base(name),
+		CategoryID: upload.Category.ID,
+		TagsNames:  upload.Tags,
+	}
+	if err = imageToCreate.Validate(); err != nil {
+		return &bulkResult{File: name, Error: err.Error()}
+	}
+
+	err = h.createImageFromFile(imageToCreate, file, size, upload.Options)
+	if duplicateErr, ok := err.(*duplicateError); ok {
+		return &bulkResult{File: name, Image: duplicateErr.Image, Duplicate: true}
+	}
+	if err != nil {
+		return h.bulkFailure(name, err, maxSize)
+	}
+
+	imageToCreate.Category = upload.Category
+
+	return &bulkResult{File: name, Image: imageToCreate}
+}
+
+// bulkFailure returns the result of a failed file, internal errors are logged
+// and not detailed to the client
+func (h *Handler) bulkFailure(name string, err error, maxSize int64) *bulkResult {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &bulkResult{File: name, Error: fmt.Sprintf("file cannot exceed %d bytes", maxSize)}
+	}
+
+	if validationErr, ok := err.(*imaging.ValidationError); ok {
+		return &bulkResult{File: name, Error: validationErr.Message}
+	}
+
+	h.Logger.Errorf("could not create image from %s: %v", name, err)
+	return &bulkResult{File: name, Error: "file could not be uploaded"}
+}
+
+// isHiddenPath is true for files added to archives by operating systems, such
+// as __MACOSX resource forks or .DS_Store files
+func isHiddenPath(name string) bool {
+	for _, element := range strings.Split(name, "/") {
+		if strings.HasPrefix(element, ".") || element == "__MACOSX" {
+			return true
+		}
+	}
+
+	return false
+}
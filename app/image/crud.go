@@ -3,31 +3,78 @@ package image
 import (
 	"database/sql"
 	"fmt"
+	"image/color"
 	"image_gallery/category"
+	"image_gallery/database"
 	"image_gallery/helpers"
+	"image_gallery/imaging"
 	"image_gallery/tag"
 	"strings"
 	"time"
 )
 
-// Repository struct to store db connection
-type Repository struct {
-	Conn *sql.DB
+// Repository stores images, their tags links, renditions, metadata and palette
+type Repository interface {
+	// SelectImageByID returns nil when the image does not exist
+	SelectImageByID(id int64) (*Image, error)
+	// SelectImageByHash returns an image whose stored file has the given content
+	// hash, other than the image excludedID
+	SelectImageByHash(hash string, excludedID int64) (*Image, error)
+	// RetrieveAllImages returns the images matching filters with their category,
+	// tags, renditions and palette
+	RetrieveAllImages(filters map[FilterName]interface{}) ([]*Image, error)
+	InsertImage(image *Image) error
+	UpdateImage(image *Image, id int64) error
+	UpdateImageFile(image *Image) error
+	ClearImageFile(id int64) error
+	DeleteImage(id int64) (int64, error)
+	SelectPerceptualHashes() ([]PerceptualHash, error)
+
+	LinkTagToImage(imageID int64, tagID int64) error
+	UnlinkTagsFromImage(imageID int64) error
+
+	InsertRendition(imageID int64, name string, rendition *Rendition) error
+	SelectRenditionsByImageID(imageID int64) (map[string]*Rendition, error)
+	DeleteRenditions(imageID int64) error
+
+	InsertMetadata(imageID int64, metadata *imaging.Metadata) error
+	// SelectMetadataByImageID returns nil when the image file had no metadata
+	SelectMetadataByImageID(imageID int64) (*imaging.Metadata, error)
+	DeleteMetadata(imageID int64) error
+
+	InsertPalette(imageID int64, palette []imaging.PaletteColor) error
+	SelectPaletteByImageID(imageID int64) ([]imaging.PaletteColor, error)
+	DeletePalette(imageID int64) error
+}
+
+// SQLRepository is the Repository of a SQL database
+type SQLRepository struct {
+	// Conn runs queries written with ? placeholders, see Driver.Conn
+	Conn   database.Querier
+	Driver database.Driver
 }
 
 // Image struct for handling images
 type Image struct {
-	ID          int64              `json:"id"`
-	Name        string             `json:"name"`
-	Slug        string             `json:"slug"`
-	Description string             `json:"description"`
-	Type        string             `json:"type,omitempty"`
-	CreatedAt   time.Time          `json:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at"`
-	CategoryID  int64              `json:"category_id,omitempty"`
-	Category    *category.Category `json:"category,omitempty"`
-	TagsNames   []string           `json:"tags"`
-	Tags        []*tag.Tag         `json:"-"`
+	ID             int64                  `json:"id"`
+	Name           string                 `json:"name"`
+	Slug           string                 `json:"slug"`
+	Description    string                 `json:"description"`
+	Type           string                 `json:"type,omitempty"`
+	Hash           string                 `json:"hash,omitempty"`
+	PerceptualHash *uint64                `json:"-"`
+	Width          int                    `json:"width,omitempty"`
+	Height         int                    `json:"height,omitempty"`
+	BlurHash       string                 `json:"blurhash,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+	CategoryID     int64                  `json:"category_id,omitempty"`
+	Category       *category.Category     `json:"category,omitempty"`
+	TagsNames      []string               `json:"tags"`
+	Tags           []*tag.Tag             `json:"-"`
+	Renditions     map[string]*Rendition  `json:"renditions,omitempty"`
+	Metadata       *imaging.Metadata      `json:"metadata,omitempty"`
+	Palette        []imaging.PaletteColor `json:"palette,omitempty"`
 }
 
 // Validate : interface for JSON backend validation
@@ -44,13 +91,34 @@ func (i *Image) Validate() error {
 	return nil
 }
 
-func (repository *Repository) selectImageByID(id int64) (*Image, error) {
-	row := repository.Conn.QueryRow(`SELECT i.id, i.name, i.slug, i.description, i.type, 
-	i.created_at, i.updated_at, i.category_id FROM image i WHERE i.id=?;`, id)
+func (repository *SQLRepository) SelectImageByID(id int64) (*Image, error) {
+	row := repository.Conn.QueryRow(`SELECT i.id, i.name, i.slug, i.description, i.type, i.hash,
+	i.perceptual_hash, i.width, i.height, i.blurhash, i.created_at, i.updated_at, i.category_id
+	FROM image i WHERE i.id=?;`, id)
+
+	return scanImage(row)
+}
+
+// SelectImageByHash returns an image whose stored file has the given content
+// hash, other than the image excludedID
+func (repository *SQLRepository) SelectImageByHash(hash string, excludedID int64) (*Image, error) {
+	row := repository.Conn.QueryRow(`SELECT i.id, i.name, i.slug, i.description, i.type, i.hash,
+	i.perceptual_hash, i.width, i.height, i.blurhash, i.created_at, i.updated_at, i.category_id
+	FROM image i WHERE i.hash=? AND i.id<>? ORDER BY i.id LIMIT 1;`,
+		hash, excludedID)
+
+	return scanImage(row)
+}
+
+func scanImage(row *sql.Row) (*Image, error) {
+	var id int64
 	var name, slug, description, typeExt string
+	var hash, blurHash sql.NullString
+	var perceptualHash, width, height sql.NullInt64
 	var createdAt, updatedAt time.Time
 	var categoryID int64
-	switch err := row.Scan(&id, &name, &slug, &description, &typeExt, &createdAt, &updatedAt, &categoryID); err {
+	switch err := row.Scan(&id, &name, &slug, &description, &typeExt, &hash, &perceptualHash, &width, &height,
+		&blurHash, &createdAt, &updatedAt, &categoryID); err {
 	case sql.ErrNoRows:
 		return nil, nil
 	case nil:
@@ -60,24 +128,58 @@ func (repository *Repository) selectImageByID(id int64) (*Image, error) {
 			Slug:        slug,
 			Description: description,
 			Type:        typeExt,
+			Hash:        hash.String,
+			Width:       int(width.Int64),
+			Height:      int(height.Int64),
+			BlurHash:    blurHash.String,
 			CreatedAt:   createdAt,
 			UpdatedAt:   updatedAt,
 			CategoryID:  categoryID,
 		}
+		if perceptualHash.Valid {
+			value := uint64(perceptualHash.Int64)
+			image.PerceptualHash = &value
+		}
 		return &image, nil
 	default:
 		return nil, err
 	}
 }
 
-type filterName string
+// FilterName names a filter of RetrieveAllImages
+type FilterName string
+
+// FilterByDateOfUpdate orders images by update date, "asc" or "desc"
+const FilterByDateOfUpdate FilterName = "updated_at"
+
+// FilterByTag keeps the images linked to a tag id
+const FilterByTag FilterName = "tag"
 
-const filterByDateOfUpdate filterName = "updated_at"
-const filterByTag filterName = "tag"
-const filterByCategory filterName = "category"
+// FilterByTagTree keeps the images linked to a tag id or to one of its descendants
+const FilterByTagTree FilterName = "tag_tree"
 
-// retrieveAllImages stored in db
-func (repository *Repository) retrieveAllImages(filters map[filterName]interface{}, db *sql.DB) ([]*Image, error) {
+// FilterByCategory keeps the images of a category id
+const FilterByCategory FilterName = "category"
+
+// FilterByColor keeps the images dominated by a ColorFilter color
+const FilterByColor FilterName = "color"
+
+// defaultColorTolerance is the distance under which colors are alike, about
+// the difference between two shades of a color
+const defaultColorTolerance = 20
+
+// DominantColorWeight is the part of an image a color must cover to match a color filter
+const DominantColorWeight = 0.2
+
+// ColorFilter selects images dominated by a color
+type ColorFilter struct {
+	Color color.RGBA
+	// Tolerance is the maximum distance between colors in the L*a*b* space
+	Tolerance float64
+}
+
+// RetrieveAllImages stored in db
+func (repository *SQLRepository) RetrieveAllImages(filters map[FilterName]interface{}) ([]*Image, error) {
 
 	queryFilters := make([]string, 0)
 	queryArgs := make([]interface{}, 0)
@@ -87,17 +189,21 @@ func (repository *Repository) retrieveAllImages(filters map[filterName]interface
 
 	var id, categoryID int64
 	var name, slug, description, typeExt, categoryName, categoryDescription, tagName string
+	var categoryStripMetadata bool
+	var hash, blurHash sql.NullString
+	var width, height sql.NullInt64
 	var createdAt, updatedAt, categCreatedAt, categUpdatedAt time.Time
 
-	scan = append(scan, &id, &name, &slug, &description, &typeExt, &createdAt, &updatedAt, &categoryID)
+	scan = append(scan, &id, &name, &slug, &description, &typeExt, &hash, &width, &height, &blurHash, &createdAt,
+		&updatedAt, &categoryID)
 
 	queryFields := []string{
-		"i.id", "i.name", "i.slug", "i.description", "i.type", "i.created_at", "i.updated_at",
-		"i.category_id",
+		"i.id", "i.name", "i.slug", "i.description", "i.type", "i.hash", "i.width", "i.height", "i.blurhash",
+		"i.created_at", "i.updated_at", "i.category_id",
 	}
 
 	// Filtering images by date
-	if v, ok := filters[filterByDateOfUpdate]; ok {
+	if v, ok := filters[FilterByDateOfUpdate]; ok {
 		if vv, ok := v.(string); ok {
 			switch vv {
 			case "asc":
@@ -110,17 +216,18 @@ func (repository *Repository) retrieveAllImages(filters map[filterName]interface
 
 	queryJoins = append(queryJoins, "INNER JOIN category c ON c.id = i.category_id")
 	queryFields = append(queryFields, "c.name AS category_name", "c.description AS"+
-		" category_description, c.created_at, c.updated_at")
-	scan = append(scan, &categoryName, &categoryDescription, &categCreatedAt, &categUpdatedAt)
+		" category_description, c.strip_metadata, c.created_at, c.updated_at")
+	scan = append(scan, &categoryName, &categoryDescription, &categoryStripMetadata, &categCreatedAt,
+		&categUpdatedAt)
 
-	if v, ok := filters[filterByCategory]; ok {
+	if v, ok := filters[FilterByCategory]; ok {
 		if vv, ok := v.(int64); ok {
 			queryFilters = append(queryFilters, "i.category_id = ?")
 			queryArgs = append(queryArgs, vv)
 		}
 	}
 
-	if v, ok := filters[filterByTag]; ok {
+	if v, ok := filters[FilterByTag]; ok {
 		if vv, ok := v.(int64); ok {
 			queryFilters = append(queryFilters, "t.id = ?")
 			queryArgs = append(queryArgs, vv)
@@ -131,6 +238,25 @@ func (repository *Repository) retrieveAllImages(filters map[filterName]interface
 		}
 	}
 
+	if v, ok := filters[FilterByTagTree]; ok {
+		if vv, ok := v.(int64); ok {
+			queryFilters = append(queryFilters, "i.id IN (SELECT it.image_id FROM image_tag it WHERE it.tag_id IN ("+
+				tag.DescendantsQuery+"))")
+			queryArgs = append(queryArgs, vv)
+		}
+	}
+
+	// palette colors close to the filter color must cover enough of the image
+	if v, ok := filters[FilterByColor]; ok {
+		if vv, ok := v.(ColorFilter); ok {
+			l, a, b := imaging.Lab(vv.Color)
+			queryFilters = append(queryFilters, "i.id IN (SELECT pc.image_id FROM image_color pc"+
+				" WHERE (pc.lab_l-?)*(pc.lab_l-?) + (pc.lab_a-?)*(pc.lab_a-?) + (pc.lab_b-?)*(pc.lab_b-?) <= ?"+
+				" GROUP BY pc.image_id HAVING SUM(pc.weight) >= ?)")
+			queryArgs = append(queryArgs, l, l, a, a, b, b, vv.Tolerance*vv.Tolerance, DominantColorWeight)
+		}
+	}
+
 	query := fmt.Sprintf("SELECT %s FROM image i %s", strings.Join(queryFields, ", "),
 		strings.Join(queryJoins, "\n"))
 
@@ -146,6 +272,7 @@ func (repository *Repository) retrieveAllImages(filters map[filterName]interface
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve images: %v", err)
 	}
+	defer rows.Close()
 
 	var images []*Image
 
@@ -162,40 +289,148 @@ func (repository *Repository) retrieveAllImages(filters map[filterName]interface
 			Name:        name,
 			Slug:        slug,
 			Description: description,
+			Hash:        hash.String,
+			Width:       int(width.Int64),
+			Height:      int(height.Int64),
+			BlurHash:    blurHash.String,
 			CreatedAt:   createdAt,
 			UpdatedAt:   updatedAt,
 			CategoryID:  categoryID,
 			Category: &category.Category{
-				Name:        categoryName,
-				Description: categoryDescription,
-				CreatedAt:   categCreatedAt,
-				UpdatedAt:   categUpdatedAt,
+				Name:          categoryName,
+				Description:   categoryDescription,
+				StripMetadata: categoryStripMetadata,
+				CreatedAt:     categCreatedAt,
+				UpdatedAt:     categUpdatedAt,
 			},
 		}
 
-		tagRepository := tag.Repository{Conn: db}
+		images = append(images, &image)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get images : %v", err)
+	}
 
-		tags, err := tagRepository.GetAllTagsByImageID(id)
-		if err != nil {
-			return nil, fmt.Errorf("could not get tags : %v", err)
-		}
+	if len(images) == 0 {
+		return images, nil
+	}
 
-		image.TagsNames = tags
+	// tags, renditions and palettes are read for all images at once
+	ids := make([]interface{}, 0, len(images))
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
 
-		images = append(images, &image)
+	tags, err := repository.selectTagsByImageIDs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("could not get tags : %v", err)
+	}
+	renditions, err := repository.selectRenditionsByImageIDs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("could not get renditions : %v", err)
+	}
+	palettes, err := repository.selectPalettesByImageIDs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("could not get palette : %v", err)
+	}
+
+	for _, image := range images {
+		image.TagsNames = tags[image.ID]
+		image.Renditions = renditions[image.ID]
+		image.Palette = palettes[image.ID]
 	}
 
 	return images, nil
 }
 
-// insertCategory posts a new image
-func (repository *Repository) insertImage(image *Image) error {
+// placeholders returns the n comma separated placeholders of an IN clause
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
 
-	stmt, err := repository.Conn.Prepare("INSERT INTO image(name, slug, description, type, created_at," +
-		" updated_at, category_id) VALUES(?,?,?,?,?,?,?)")
+// selectTagsByImageIDs returns the tag names of images, by image id
+func (repository *SQLRepository) selectTagsByImageIDs(ids []interface{}) (map[int64][]string, error) {
+	rows, err := repository.Conn.Query("SELECT it.image_id, t.name FROM tag t INNER JOIN image_tag it ON it.tag_id = t.id"+
+		" WHERE it.image_id IN ("+placeholders(len(ids))+") ORDER BY it.image_id, it.tag_id", ids...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var imageID int64
+		var name string
+		if err = rows.Scan(&imageID, &name); err != nil {
+			return nil, err
+		}
+		tags[imageID] = append(tags[imageID], name)
+	}
+
+	return tags, rows.Err()
+}
+
+// selectRenditionsByImageIDs returns the renditions of images, by image id
+func (repository *SQLRepository) selectRenditionsByImageIDs(ids []interface{}) (map[int64]map[string]*Rendition,
+	error) {
+	rows, err := repository.Conn.Query("SELECT r.image_id, r.name, r.path, r.width, r.height FROM image_rendition r"+
+		" WHERE r.image_id IN ("+placeholders(len(ids))+")", ids...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
+
+	renditions := make(map[int64]map[string]*Rendition)
+	for rows.Next() {
+		var imageID int64
+		var name string
+		var rendition Rendition
+		err = rows.Scan(&imageID, &name, &rendition.Key, &rendition.Width, &rendition.Height)
+		if err != nil {
+			return nil, err
+		}
+
+		if renditions[imageID] == nil {
+			renditions[imageID] = make(map[string]*Rendition)
+		}
+		rendition.URL = UploadURL + rendition.Key
+		renditions[imageID][name] = &rendition
+	}
+
+	return renditions, rows.Err()
+}
+
+// selectPalettesByImageIDs returns the dominant colors of images, by image id
+func (repository *SQLRepository) selectPalettesByImageIDs(ids []interface{}) (map[int64][]imaging.PaletteColor,
+	error) {
+	rows, err := repository.Conn.Query("SELECT pc.image_id, pc.color, pc.weight FROM image_color pc"+
+		" WHERE pc.image_id IN ("+placeholders(len(ids))+") ORDER BY pc.image_id, pc.position", ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	palettes := make(map[int64][]imaging.PaletteColor)
+	for rows.Next() {
+		var imageID int64
+		var hex string
+		var weight float64
+		if err = rows.Scan(&imageID, &hex, &weight); err != nil {
+			return nil, err
+		}
+
+		c, err := imaging.ParseHexColor(hex)
+		if err != nil {
+			return nil, err
+		}
+		palettes[imageID] = append(palettes[imageID], imaging.NewPaletteColor(c, weight))
+	}
+
+	return palettes, rows.Err()
+}
+
+// InsertImage posts a new image, with a generated slug
+func (repository *SQLRepository) InsertImage(image *Image) error {
 
 	image.Type = ""
 	image.CreatedAt = time.Now()
@@ -215,16 +450,11 @@ func (repository *Repository) insertImage(image *Image) error {
 		}
 	}
 
-	res, errExec := stmt.Exec(image.Name, image.Slug, image.Description, image.Type, image.CreatedAt, image.UpdatedAt,
-		image.CategoryID)
-	if errExec != nil {
-		return fmt.Errorf("could not exec stmt: %v", errExec)
-	}
-
-	lastInsertedID, errInsert := res.LastInsertId()
-
+	lastInsertedID, errInsert := repository.Driver.Insert(repository.Conn, "INSERT INTO image(name, slug, description,"+
+		" type, created_at, updated_at, category_id) VALUES(?,?,?,?,?,?,?)", image.Name, image.Slug, image.Description,
+		image.Type, image.CreatedAt, image.UpdatedAt, image.CategoryID)
 	if errInsert != nil {
-		return fmt.Errorf("could not retrieve last inserted ID: %v", errInsert)
+		return fmt.Errorf("could not insert image: %v", errInsert)
 	}
 
 	image.ID = lastInsertedID
@@ -232,25 +462,27 @@ func (repository *Repository) insertImage(image *Image) error {
 	return nil
 }
 
-// updateImage by ID
-func (repository *Repository) updateImage(image *Image, id int64) error {
-	stmt, err := repository.Conn.Prepare("UPDATE image SET name=(?), description=(?), type=(?)," +
+// UpdateImage by ID
+func (repository *SQLRepository) UpdateImage(image *Image, id int64) error {
+	stmt, err := repository.Conn.Prepare("UPDATE image SET name=(?), description=(?)," +
 		"updated_at=(?) WHERE id=(?)")
 	if err != nil {
 		return err
 	}
-	var slug string
+	var slug, typeExt string
 	var createdAt time.Time
-	row := repository.Conn.QueryRow(`SELECT i.slug, i.created_at FROM image i WHERE i.id=?`, id)
-	if err := row.Scan(&slug, &createdAt); err != nil {
+	row := repository.Conn.QueryRow(`SELECT i.slug, i.type, i.created_at FROM image i WHERE i.id=?`, id)
+	if err := row.Scan(&slug, &typeExt, &createdAt); err != nil {
 		return err
 	}
 
+	// the type is the one of the stored file, set by uploads only
 	image.CreatedAt = createdAt
 	image.Slug = slug
+	image.Type = typeExt
 	image.UpdatedAt = time.Now()
 
-	_, errExec := stmt.Exec(image.Name, image.Description, image.Type, image.UpdatedAt, id)
+	_, errExec := stmt.Exec(image.Name, image.Description, image.UpdatedAt, id)
 
 	if errExec != nil {
 		return errExec
@@ -261,8 +493,70 @@ func (repository *Repository) updateImage(image *Image, id int64) error {
 	return nil
 }
 
-// deleteCategory by ID
-func (repository *Repository) deleteImage(id int64) (int64, error) {
+// UpdateImageFile saves the type, hashes, size and placeholder of the stored file of an image
+func (repository *SQLRepository) UpdateImageFile(image *Image) error {
+	image.UpdatedAt = time.Now()
+
+	// the perceptual hash bits are stored in a signed column
+	var perceptualHash sql.NullInt64
+	if image.PerceptualHash != nil {
+		perceptualHash = sql.NullInt64{Int64: int64(*image.PerceptualHash), Valid: true}
+	}
+
+	_, err := repository.Conn.Exec("UPDATE image SET type=(?), hash=(?), perceptual_hash=(?), width=(?), height=(?),"+
+		" blurhash=(?), updated_at=(?) WHERE id=(?)", image.Type, nullableString(image.Hash), perceptualHash,
+		nullableInt(image.Width), nullableInt(image.Height), nullableString(image.BlurHash), image.UpdatedAt, image.ID)
+
+	return err
+}
+
+// ClearImageFile marks an image as not uploaded once its file is deleted
+func (repository *SQLRepository) ClearImageFile(id int64) error {
+	_, err := repository.Conn.Exec("UPDATE image SET type='', hash=NULL, perceptual_hash=NULL, width=NULL,"+
+		" height=NULL, blurhash=NULL, updated_at=(?) WHERE id=(?)", time.Now(), id)
+
+	return err
+}
+
+// PerceptualHash is the perceptual hash of an uploaded image
+type PerceptualHash struct {
+	ImageID int64
+	Hash    uint64
+}
+
+// SelectPerceptualHashes returns the perceptual hashes of all uploaded images
+func (repository *SQLRepository) SelectPerceptualHashes() ([]PerceptualHash, error) {
+	rows, err := repository.Conn.Query("SELECT i.id, i.perceptual_hash FROM image i" +
+		" WHERE i.perceptual_hash IS NOT NULL ORDER BY i.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []PerceptualHash
+	for rows.Next() {
+		var imageID, hash int64
+		if err = rows.Scan(&imageID, &hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, PerceptualHash{ImageID: imageID, Hash: uint64(hash)})
+	}
+
+	return hashes, rows.Err()
+}
+
+// nullableString returns NULL for an empty string
+func nullableString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullableInt returns NULL for zero
+func nullableInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+// DeleteImage by ID
+func (repository *SQLRepository) DeleteImage(id int64) (int64, error) {
 
 	res, err := repository.Conn.Exec("DELETE FROM image WHERE id=(?)", id)
 	if err != nil {
@@ -271,7 +565,7 @@ func (repository *Repository) deleteImage(id int64) (int64, error) {
 	return res.RowsAffected()
 }
 
-func (repository *Repository) checkIfRowExists(tableName string, WhereColumn string, whereValue interface{}) (int64, error) {
+func (repository *SQLRepository) checkIfRowExists(tableName string, WhereColumn string, whereValue interface{}) (int64, error) {
 	query := "SELECT id FROM " + tableName + " WHERE " + WhereColumn + "=(?)"
 	row := repository.Conn.QueryRow(query, whereValue)
 
@@ -288,22 +582,178 @@ func (repository *Repository) checkIfRowExists(tableName string, WhereColumn str
 }
 
 // add tag id and image id to Many To Many Table
-func (repository *Repository) linkTagToImage(imageID int64, tagID int64) error {
+func (repository *SQLRepository) LinkTagToImage(imageID int64, tagID int64) error {
 
 	stmt, err := repository.Conn.Prepare("INSERT INTO image_tag(image_id, tag_id)" +
 		"VALUES(?,?)")
 	if err != nil {
 		return err
 	}
-	res, errExec := stmt.Exec(imageID, tagID)
-	if errExec != nil {
-		return errExec
+	_, errExec := stmt.Exec(imageID, tagID)
+
+	return errExec
+}
+
+// UnlinkTagsFromImage removes all tags of an image, the tags are kept
+func (repository *SQLRepository) UnlinkTagsFromImage(imageID int64) error {
+	_, err := repository.Conn.Exec("DELETE FROM image_tag WHERE image_id=(?)", imageID)
+	if err != nil {
+		return fmt.Errorf("could not unlink tags: %v", err)
 	}
 
-	_, errInsert := res.LastInsertId()
-	if errInsert != nil {
-		return errInsert
+	return nil
+}
+
+// InsertRendition saves a rendition generated for an image
+func (repository *SQLRepository) InsertRendition(imageID int64, name string, rendition *Rendition) error {
+	_, err := repository.Conn.Exec("INSERT INTO image_rendition(image_id, name, path, width, height)"+
+		" VALUES(?,?,?,?,?)", imageID, name, rendition.Key, rendition.Width, rendition.Height)
+	if err != nil {
+		return fmt.Errorf("could not insert rendition: %v", err)
+	}
+
+	return nil
+}
+
+// SelectRenditionsByImageID gets all renditions of an image by name
+func (repository *SQLRepository) SelectRenditionsByImageID(imageID int64) (map[string]*Rendition, error) {
+	rows, err := repository.Conn.Query("SELECT r.name, r.path, r.width, r.height FROM image_rendition r"+
+		" WHERE r.image_id = (?)", imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var renditions map[string]*Rendition
+
+	for rows.Next() {
+		var name string
+		var rendition Rendition
+		err := rows.Scan(&name, &rendition.Key, &rendition.Width, &rendition.Height)
+		if err != nil {
+			return nil, err
+		}
+
+		if renditions == nil {
+			renditions = make(map[string]*Rendition)
+		}
+		rendition.URL = UploadURL + rendition.Key
+		renditions[name] = &rendition
+	}
+
+	return renditions, rows.Err()
+}
+
+// DeleteRenditions deletes all renditions of an image
+func (repository *SQLRepository) DeleteRenditions(imageID int64) error {
+	_, err := repository.Conn.Exec("DELETE FROM image_rendition WHERE image_id=(?)", imageID)
+	return err
+}
+
+// InsertMetadata saves the metadata extracted from an image file
+func (repository *SQLRepository) InsertMetadata(imageID int64, metadata *imaging.Metadata) error {
+	var capturedAt sql.NullTime
+	if metadata.CapturedAt != nil {
+		capturedAt = sql.NullTime{Time: *metadata.CapturedAt, Valid: true}
+	}
+
+	var latitude, longitude sql.NullFloat64
+	if metadata.Latitude != nil && metadata.Longitude != nil {
+		latitude = sql.NullFloat64{Float64: *metadata.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: *metadata.Longitude, Valid: true}
+	}
+
+	_, err := repository.Conn.Exec("INSERT INTO image_metadata(image_id, camera_make, camera_model, lens,"+
+		" exposure_time, f_number, iso, focal_length, captured_at, orientation, latitude, longitude, width,"+
+		" height, copyright, private) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", imageID, metadata.CameraMake,
+		metadata.CameraModel, metadata.Lens, metadata.ExposureTime, metadata.FNumber, metadata.ISO,
+		metadata.FocalLength, capturedAt, metadata.Orientation, latitude, longitude, metadata.Width,
+		metadata.Height, metadata.Copyright, metadata.Private)
+	if err != nil {
+		return fmt.Errorf("could not insert metadata: %v", err)
 	}
 
 	return nil
 }
+
+// SelectMetadataByImageID retrieves the metadata of an image file
+func (repository *SQLRepository) SelectMetadataByImageID(imageID int64) (*imaging.Metadata, error) {
+	row := repository.Conn.QueryRow("SELECT m.camera_make, m.camera_model, m.lens, m.exposure_time, m.f_number,"+
+		" m.iso, m.focal_length, m.captured_at, m.orientation, m.latitude, m.longitude, m.width, m.height,"+
+		" m.copyright, m.private FROM image_metadata m WHERE m.image_id = (?)", imageID)
+
+	var metadata imaging.Metadata
+	var capturedAt sql.NullTime
+	var latitude, longitude sql.NullFloat64
+
+	switch err := row.Scan(&metadata.CameraMake, &metadata.CameraModel, &metadata.Lens, &metadata.ExposureTime,
+		&metadata.FNumber, &metadata.ISO, &metadata.FocalLength, &capturedAt, &metadata.Orientation, &latitude,
+		&longitude, &metadata.Width, &metadata.Height, &metadata.Copyright, &metadata.Private); err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+		if capturedAt.Valid {
+			metadata.CapturedAt = &capturedAt.Time
+		}
+		if latitude.Valid && longitude.Valid {
+			metadata.Latitude = &latitude.Float64
+			metadata.Longitude = &longitude.Float64
+		}
+		return &metadata, nil
+	default:
+		return nil, err
+	}
+}
+
+// DeleteMetadata deletes the metadata of an image file
+func (repository *SQLRepository) DeleteMetadata(imageID int64) error {
+	_, err := repository.Conn.Exec("DELETE FROM image_metadata WHERE image_id=(?)", imageID)
+	return err
+}
+
+// InsertPalette saves the dominant colors of an image file
+func (repository *SQLRepository) InsertPalette(imageID int64, palette []imaging.PaletteColor) error {
+	for position, paletteColor := range palette {
+		l, a, b := imaging.Lab(paletteColor.Color)
+		_, err := repository.Conn.Exec("INSERT INTO image_color(image_id, position, color, weight, lab_l, lab_a,"+
+			" lab_b) VALUES(?,?,?,?,?,?,?)", imageID, position, paletteColor.Hex, paletteColor.Weight, l, a, b)
+		if err != nil {
+			return fmt.Errorf("could not insert palette color: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SelectPaletteByImageID retrieves the dominant colors of an image file, most covering first
+func (repository *SQLRepository) SelectPaletteByImageID(imageID int64) ([]imaging.PaletteColor, error) {
+	rows, err := repository.Conn.Query("SELECT pc.color, pc.weight FROM image_color pc"+
+		" WHERE pc.image_id = (?) ORDER BY pc.position", imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var palette []imaging.PaletteColor
+	for rows.Next() {
+		var hex string
+		var weight float64
+		if err = rows.Scan(&hex, &weight); err != nil {
+			return nil, err
+		}
+
+		c, err := imaging.ParseHexColor(hex)
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, imaging.NewPaletteColor(c, weight))
+	}
+
+	return palette, rows.Err()
+}
+
+// DeletePalette deletes the dominant colors of an image file
+func (repository *SQLRepository) DeletePalette(imageID int64) error {
+	_, err := repository.Conn.Exec("DELETE FROM image_color WHERE image_id=(?)", imageID)
+	return err
+}
@@ -3,23 +3,30 @@ package image
 import (
 	"fmt"
 	"github.com/gorilla/mux"
-	"image_gallery/category"
-	"image_gallery/database"
 	"image_gallery/helpers"
+	"image_gallery/imaging"
 	cLog "image_gallery/logger"
 	"image_gallery/router"
+	"image_gallery/storage"
 	"image_gallery/tag"
-	"io/ioutil"
-	"mime"
-	"mime/multipart"
 	"net/http"
-	"os"
 	"strconv"
 )
 
 // Handler is the home handler
 type Handler struct {
-	Logger *cLog.Logger
+	Logger   *cLog.Logger
+	Store    Store
+	Storage  storage.Storage
+	Presets  []Preset
+	Renderer *Renderer
+	Upload   UploadConfig
+	// Resumable holds the uploads sent in chunks
+	Resumable *Resumable
+	// OwnerToken authenticates the owner, who can read private metadata
+	OwnerToken string
+	// TagNames normalises the names of the tags of images
+	TagNames tag.NameConfig
 }
 
 // Routes returns handler routes
@@ -37,6 +44,36 @@ func (h *Handler) Routes() router.Routes {
 			Pattern:     "/images",
 			HandlerFunc: h.getAllImages,
 		},
+		router.Route{
+			Name:        "Get the images of a tag",
+			Method:      "GET",
+			Pattern:     "/tags/{id}/images",
+			HandlerFunc: h.getImagesByTag,
+		},
+		router.Route{
+			Name:        "Render an image",
+			Method:      "GET",
+			Pattern:     "/images/{id}/render",
+			HandlerFunc: h.renderImage,
+		},
+		router.Route{
+			Name:        "Get similar images",
+			Method:      "GET",
+			Pattern:     "/images/{id}/similar",
+			HandlerFunc: h.getSimilarImages,
+		},
+		router.Route{
+			Name:        "Get suggested tags of an image",
+			Method:      "GET",
+			Pattern:     "/images/{id}/suggested-tags",
+			HandlerFunc: h.getSuggestedTags,
+		},
+		router.Route{
+			Name:        "Get near duplicate images",
+			Method:      "GET",
+			Pattern:     "/admin/near-duplicates",
+			HandlerFunc: h.getDuplicateClusters,
+		},
 		router.Route{
 			Name:        "Post an image",
 			Method:      "POST",
@@ -55,28 +92,67 @@ func (h *Handler) Routes() router.Routes {
 			Pattern:     "/images/{id}",
 			HandlerFunc: h.deleteImage,
 		},
+		router.Route{
+			Name:        "Post an image with its file",
+			Method:      "POST",
+			Pattern:     "/images/upload",
+			HandlerFunc: h.createImageWithFile,
+		},
+		router.Route{
+			Name:        "Bulk upload images",
+			Method:      "POST",
+			Pattern:     "/images/bulk",
+			HandlerFunc: h.bulkUploadImages,
+		},
 		router.Route{
 			Name:        "Upload an image",
 			Method:      "POST",
 			Pattern:     "/upload/{id}",
 			HandlerFunc: h.upload,
 		},
+		router.Route{
+			Name:        "Create a resumable upload",
+			Method:      "POST",
+			Pattern:     "/upload/{id}/resumable",
+			HandlerFunc: h.createResumableUpload,
+		},
+		router.Route{
+			Name:        "Get a resumable upload offset",
+			Method:      "HEAD",
+			Pattern:     "/upload/{id}/resumable/{upload_id}",
+			HandlerFunc: h.headResumableUpload,
+		},
+		router.Route{
+			Name:        "Send a resumable upload chunk",
+			Method:      "PATCH",
+			Pattern:     "/upload/{id}/resumable/{upload_id}",
+			HandlerFunc: h.patchResumableUpload,
+		},
+		router.Route{
+			Name:        "Cancel a resumable upload",
+			Method:      "DELETE",
+			Pattern:     "/upload/{id}/resumable/{upload_id}",
+			HandlerFunc: h.deleteResumableUpload,
+		},
 	}
 }
 
-const maxUploadSize = 2 * 1024 * 1024 // 2 mb
-// UploadPath const to set upload path for all images
-const UploadPath = "/go/uploads/"
+// FileKey returns the storage key of the image file, files uploaded before
+// content addressing are stored under the image id
+func FileKey(image *Image) string {
+	if image.Hash != "" {
+		return BlobKey(image.Hash, image.Type)
+	}
+
+	return strconv.FormatInt(image.ID, 10) + "/" + image.Slug + image.Type
+}
 
 func (h *Handler) getImagebyID(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("calling %v", r.URL.Path)
 
 	muxVars := mux.Vars(r)
-	db := database.DbConn
 
-	repository := Repository{Conn: db}
-	categoryRepository := category.Repository{Conn: db}
-	tagRepository := tag.Repository{Conn: db}
+	repository := h.Store.Images()
 
 	id, err := helpers.ParseInt64(muxVars["id"])
 	if err != nil {
@@ -84,7 +160,7 @@ func (h *Handler) getImagebyID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	imageSelected, err := repository.selectImageByID(id)
+	imageSelected, err := repository.SelectImageByID(id)
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image")
@@ -96,11 +172,11 @@ func (h *Handler) getImagebyID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	categoryRetrieved, err := categoryRepository.SelectCategoryByID(imageSelected.CategoryID)
+	categoryRetrieved, err := h.Store.Categories().SelectCategoryByID(imageSelected.CategoryID)
 
 	imageSelected.Category = categoryRetrieved
 
-	tags, err := tagRepository.GetAllTagsByImageID(id)
+	tags, err := h.Store.Tags().GetAllTagsByImageID(id)
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tags")
@@ -109,6 +185,31 @@ func (h *Handler) getImagebyID(w http.ResponseWriter, r *http.Request) {
 
 	imageSelected.TagsNames = tags
 
+	imageSelected.Renditions, err = repository.SelectRenditionsByImageID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve renditions")
+		return
+	}
+
+	imageSelected.Metadata, err = repository.SelectMetadataByImageID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve metadata")
+		return
+	}
+
+	imageSelected.Palette, err = repository.SelectPaletteByImageID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve palette")
+		return
+	}
+
+	if imageSelected.Metadata != nil && imageSelected.Metadata.Private && !helpers.IsOwner(r, h.OwnerToken) {
+		imageSelected.Metadata = imageSelected.Metadata.Redacted()
+	}
+
 	h.Logger.Infof("image retrieved: %v", imageSelected)
 	helpers.WriteJSON(w, http.StatusOK, imageSelected)
 }
@@ -116,29 +217,56 @@ func (h *Handler) getImagebyID(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) getAllImages(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("calling %v", r.URL.Path)
 
-	db := database.DbConn
-	repository := Repository{Conn: db}
+	filters := make(map[FilterName]interface{})
 
-	filters := make(map[filterName]interface{})
-
-	order := r.URL.Query().Get(string(filterByDateOfUpdate))
+	order := r.URL.Query().Get(string(FilterByDateOfUpdate))
 	if order != "" {
-		filters[filterByDateOfUpdate] = order
+		filters[FilterByDateOfUpdate] = order
 	}
 
-	tagID, _ := helpers.ParseInt64(r.URL.Query().Get(string(filterByTag)))
+	// a tag is filtered by id, or by name or alias, with its descendants when
+	// descendants is set
+	if value := r.URL.Query().Get(string(FilterByTag)); value != "" {
+		tagID, err := helpers.ParseInt64(value)
+		if err != nil {
+			tagSelected, err := h.Store.Tags().ResolveTag(h.TagNames.Normalize(value))
+			if err != nil {
+				h.Logger.Error(err)
+				helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tag")
+				return
+			}
+			if tagSelected == nil {
+				helpers.WriteJSON(w, http.StatusNotFound, "no images found")
+				return
+			}
+			tagID = tagSelected.ID
+		}
 
-	if tagID != 0 {
-		filters[filterByTag] = tagID
+		descendants, _ := strconv.ParseBool(r.URL.Query().Get("descendants"))
+		switch {
+		case tagID != 0 && descendants:
+			filters[FilterByTagTree] = tagID
+		case tagID != 0:
+			filters[FilterByTag] = tagID
+		}
 	}
 
-	categoryID, _ := helpers.ParseInt64(r.URL.Query().Get(string(filterByCategory)))
+	categoryID, _ := helpers.ParseInt64(r.URL.Query().Get(string(FilterByCategory)))
 
 	if categoryID != 0 {
-		filters[filterByCategory] = categoryID
+		filters[FilterByCategory] = categoryID
 	}
 
-	images, err := repository.retrieveAllImages(filters, db)
+	if value := r.URL.Query().Get(string(FilterByColor)); value != "" {
+		filter, err := parseColorFilter(value, r.URL.Query().Get("tolerance"))
+		if err != nil {
+			helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filters[FilterByColor] = filter
+	}
+
+	images, err := h.Store.Images().RetrieveAllImages(filters)
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve images")
@@ -154,13 +282,93 @@ func (h *Handler) getAllImages(w http.ResponseWriter, r *http.Request) {
 	helpers.WriteJSON(w, http.StatusOK, images)
 }
 
-func (h *Handler) createImage(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) getImagesByTag(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	tagSelected, err := h.Store.Tags().SelectTagBy("id", id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tag")
+		return
+	}
+
+	if tagSelected == nil {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag does not exist")
+		return
+	}
+
+	filter := FilterByTag
+	if descendants, _ := strconv.ParseBool(r.URL.Query().Get("descendants")); descendants {
+		filter = FilterByTagTree
+	}
+
+	images, err := h.Store.Images().RetrieveAllImages(map[FilterName]interface{}{filter: id})
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve images")
+		return
+	}
+
+	if images == nil {
+		images = make([]*Image, 0)
+	}
+
+	h.Logger.Infof("images of tag %d retrieved", id)
+	helpers.WriteJSON(w, http.StatusOK, images)
+}
+
+// defaultSuggestedTags is the number of tags suggested for an image when no limit is set
+const defaultSuggestedTags = 10
+
+// getSuggestedTags lists the tags often linked to the images sharing a tag
+// with the image, which the image is not linked to
+func (h *Handler) getSuggestedTags(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("calling %v", r.URL.Path)
 
-	db := database.DbConn
-	imageRepository := Repository{Conn: db}
-	categoryRepository := category.Repository{Conn: db}
-	tagRepository := tag.Repository{Conn: db}
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid image id")
+		return
+	}
+
+	limit, err := helpers.ParseLimit(r, defaultSuggestedTags, tag.MaxSuggestions)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	image, err := h.Store.Images().SelectImageByID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image")
+		return
+	}
+
+	if image == nil {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this image does not exist")
+		return
+	}
+
+	suggestions, err := h.Store.Tags().SelectCoOccurringTags(id, limit)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to suggest tags")
+		return
+	}
+
+	h.Logger.Infof("%d tags suggested for image %d", len(suggestions), id)
+	helpers.WriteJSON(w, http.StatusOK, suggestions)
+}
+
+func (h *Handler) createImage(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
 
 	var imageToCreate Image
 	err := helpers.ReadValidateJSON(w, r, &imageToCreate)
@@ -170,22 +378,23 @@ func (h *Handler) createImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = imageRepository.insertImage(&imageToCreate)
+	// the image is only saved with all its tags
+	err = h.Store.Transaction(func(store Store) error {
+		if err := store.Images().InsertImage(&imageToCreate); err != nil {
+			return err
+		}
+		if imageToCreate.TagsNames != nil {
+			return saveTags(store, h.TagNames, &imageToCreate)
+		}
+		return nil
+	})
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to save image")
 		return
 	}
 
-	if imageToCreate.TagsNames != nil {
-		err = saveTags(imageRepository, tagRepository, &imageToCreate)
-		if err != nil {
-			h.Logger.Error(err)
-			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "could not save tags linked to image")
-		}
-	}
-
-	categoryRetrieved, err := categoryRepository.SelectCategoryByID(imageToCreate.CategoryID)
+	categoryRetrieved, err := h.Store.Categories().SelectCategoryByID(imageToCreate.CategoryID)
 	if err != nil {
 		h.Logger.Error(err)
 		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image category")
@@ -206,9 +415,6 @@ func (h *Handler) updateImage(w http.ResponseWriter, r *http.Request) {
 		h.Logger.Error(err)
 		return
 	}
-	db := database.DbConn
-	repository := Repository{Conn: db}
-	tagRepository := tag.Repository{Conn: db}
 
 	var image Image
 
@@ -218,20 +424,25 @@ func (h *Handler) updateImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = repository.updateImage(&image, id)
+	err = h.Store.Transaction(func(store Store) error {
+		if err := store.Images().UpdateImage(&image, id); err != nil {
+			return err
+		}
+		// the tags sent replace the tags of the image
+		if image.TagsNames != nil {
+			if err := store.Images().UnlinkTagsFromImage(id); err != nil {
+				return err
+			}
+			return saveTags(store, h.TagNames, &image)
+		}
+		return nil
+	})
 	if err != nil {
 		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to update image")
 		return
 	}
 
-	if image.TagsNames != nil {
-		err = saveTags(repository, tagRepository, &image)
-		if err != nil {
-			h.Logger.Error(err)
-			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "could not save tags linked to image")
-		}
-	}
-
 	h.Logger.Infof("updated image: %v", image)
 	helpers.WriteJSON(w, http.StatusOK, image)
 }
@@ -247,10 +458,7 @@ func (h *Handler) deleteImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db := database.DbConn
-	repository := Repository{Conn: db}
-
-	image, err := repository.selectImageByID(id)
+	image, err := h.Store.Images().SelectImageByID(id)
 	if err != nil {
 		h.Logger.Error(err)
 		return
@@ -262,168 +470,153 @@ func (h *Handler) deleteImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Hard delete mode deletes both image and image metadata
-	if r.URL.Query().Get("delete_mode") == "hard" {
-
-		rowsAffected, err := repository.deleteImage(id)
-		if err != nil {
-			h.Logger.Error(err)
-			return
-		}
+	hardDelete := r.URL.Query().Get("delete_mode") == "hard"
 
-		h.Logger.Infof("%d image deleted with ID: %v", rowsAffected, id)
-	}
+	// rows are deleted together, files are only deleted once they are no
+	// longer referenced
+	var shared bool
+	var renditions map[string]*Rendition
+	err = h.Store.Transaction(func(store Store) error {
+		repository := store.Images()
 
-	path := UploadPath + muxVars["id"] + "/" + image.Slug + image.Type
+		var err error
 
-	if _, err := os.Stat(path); os.IsExist(err) {
-		err = os.Remove(path)
+		// files uploaded again for other images are kept
+		shared, err = fileShared(repository, image)
 		if err != nil {
-			h.Logger.Error(err)
-			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "could not delete image")
-			return
+			return err
 		}
-	}
 
-	h.Logger.Infof("image deleted")
-	helpers.WriteJSON(w, http.StatusNoContent, "Image deleted")
+		renditions, err = repository.SelectRenditionsByImageID(id)
+		if err != nil {
+			return fmt.Errorf("could not retrieve image renditions: %v", err)
+		}
 
-}
+		if err = repository.DeleteRenditions(id); err != nil {
+			return fmt.Errorf("could not delete image renditions: %v", err)
+		}
 
-func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
-	h.Logger.Infof("calling %v", r.URL.Path)
+		if err = repository.DeleteMetadata(id); err != nil {
+			return fmt.Errorf("could not delete image metadata: %v", err)
+		}
 
-	muxVars := mux.Vars(r)
-	db := database.DbConn
+		if err = repository.DeletePalette(id); err != nil {
+			return fmt.Errorf("could not delete image palette: %v", err)
+		}
 
-	if r.Method != http.MethodPost {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
+		// Hard delete mode deletes both image and image metadata
+		if hardDelete {
+			rowsAffected, err := repository.DeleteImage(id)
+			if err != nil {
+				return err
+			}
 
-	repository := Repository{Conn: db}
+			h.Logger.Infof("%d image deleted with ID: %v", rowsAffected, id)
+			return nil
+		}
 
-	id, err := helpers.ParseInt64(muxVars["id"])
+		// the image is kept without its file, which may now be uploaded again
+		return repository.ClearImageFile(id)
+	})
 	if err != nil {
 		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "could not delete image")
 		return
 	}
 
-	image, err := repository.selectImageByID(id)
-	if err != nil {
-		h.Logger.Errorf("could not retrieve image by id : %v", err)
-		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Could not check if image has already been uploaded")
-	}
+	h.deleteFiles(image, renditions, shared)
 
-	if image == nil {
-		h.Logger.Infof("image metadata with id %d does not exists", id)
-		helpers.WriteErrorJSON(w, http.StatusBadRequest, "image id"+muxVars["id"]+" does not exist")
-		return
-	}
+	h.Logger.Infof("image deleted")
+	helpers.WriteJSON(w, http.StatusNoContent, "Image deleted")
 
-	if image.Type != "" {
-		h.Logger.Errorf("image has already been uploaded to file server")
-		helpers.WriteErrorJSON(w, http.StatusBadRequest, "You already have uploaded this image")
-		return
-	}
+}
 
-	file, handle, err := r.FormFile("file")
-	if err != nil {
-		h.Logger.Errorf("get file from form data failed : %v", err)
-		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Could not upload file")
-	}
-	defer file.Close()
+// deleteFiles removes the stored file of a deleted image, its renditions and
+// cached renders. The file and renditions are kept when they are shared with
+// another image, failures leave unused files which are only logged
+func (h *Handler) deleteFiles(image *Image, renditions map[string]*Rendition, shared bool) {
+	if !shared {
+		keys := make([]string, 0, len(renditions)+1)
+		if image.Type != "" {
+			keys = append(keys, FileKey(image))
+		}
+		for _, rendition := range renditions {
+			keys = append(keys, rendition.Key)
+		}
 
-	fileSize := handle.Size
-	// validate file size
-	if fileSize > maxUploadSize {
-		helpers.WriteErrorJSON(w, http.StatusBadRequest, "File cannot exceed 2MB")
-		return
+		for _, key := range keys {
+			if err := h.Storage.Delete(key); err != nil && err != storage.ErrNotExist {
+				h.Logger.Errorf("could not delete image file %s: %v", key, err)
+			}
+		}
 	}
 
-	mimeType := handle.Header.Get("Content-Type")
-	switch mimeType {
-	case "image/jpeg":
-		err = saveFile(w, file, handle, image)
-		if err != nil {
-			h.Logger.Errorf("could not save file: %v", err)
-			helpers.WriteErrorJSON(w, http.StatusBadRequest, "File could not be uploaded")
-			return
-		}
-	case "image/png":
-		err = saveFile(w, file, handle, image)
-		if err != nil {
-			h.Logger.Errorf("could not save file: %v", err)
-			helpers.WriteErrorJSON(w, http.StatusBadRequest, "File could not be uploaded")
-			return
-		}
-	default:
-		helpers.WriteErrorJSON(w, http.StatusBadRequest, "The format file is not valid.")
+	if err := h.Renderer.clear(image); err != nil {
+		h.Logger.Errorf("could not delete cached renders: %v", err)
 	}
 }
 
-func saveFile(w http.ResponseWriter, file multipart.File, handle *multipart.FileHeader, image *Image) error {
+// parseColorFilter reads the color and tolerance query parameters
+func parseColorFilter(value string, tolerance string) (ColorFilter, error) {
+	filter := ColorFilter{Tolerance: defaultColorTolerance}
 
-	data, err := ioutil.ReadAll(file)
-	db := database.DbConn
-	repository := Repository{Conn: db}
+	var err error
+	filter.Color, err = imaging.ParseHexColor(value)
 	if err != nil {
-		return fmt.Errorf("could not read file: %v", err)
+		return filter, err
 	}
 
-	dirName := strconv.FormatInt(image.ID, 10)
-	fileName := image.Slug
-	extensions, err := mime.ExtensionsByType(handle.Header.Get("Content-Type"))
-
-	if _, err := os.Stat(UploadPath + dirName); os.IsNotExist(err) {
-		err = os.Mkdir(UploadPath+dirName, 0755)
-		if err != nil {
-			return fmt.Errorf("could not write directory: %v", err)
+	if tolerance != "" {
+		filter.Tolerance, err = strconv.ParseFloat(tolerance, 64)
+		if err != nil || filter.Tolerance < 0 || filter.Tolerance > 100 {
+			return filter, fmt.Errorf("tolerance must be a number between 0 and 100")
 		}
 	}
 
-	err = ioutil.WriteFile(UploadPath+dirName+"/"+fileName+extensions[0], data, 0755)
-	if err != nil {
-		return fmt.Errorf("could not write file: %v", err)
-	}
-
-	image.Type = extensions[0]
-
-	err = repository.updateImage(image, image.ID)
-	if err != nil {
-		return fmt.Errorf("could not update image type: %v", err)
-	}
-
-	helpers.WriteJSON(w, http.StatusCreated, "File uploaded successfully!.")
-	return nil
+	return filter, nil
 }
 
-func saveTags(imageRepository Repository, tagRepository tag.Repository, imageTagged *Image) error {
+// saveTags links an image to its tags, tags which do not exist are created.
+// Names are normalised and aliases resolved to their tag, which is linked
+// once, the names of the image are replaced by the names of its tags
+func saveTags(store Store, tagNames tag.NameConfig, imageTagged *Image) error {
+	imageRepository, tagRepository := store.Images(), store.Tags()
+	linked := make(map[int64]bool)
+	names := make([]string, 0, len(imageTagged.TagsNames))
 	for _, tagName := range imageTagged.TagsNames {
+		tagName = tagNames.Normalize(tagName)
+		if tagName == "" {
+			continue
+		}
 
-		tagByName, err := tagRepository.SelectTagBy("name", tagName)
+		tagByName, err := tagRepository.ResolveTag(tagName)
 		if err != nil {
 			return fmt.Errorf("could not check if tag already exists %v", err)
 		}
 
-		if tagByName != nil {
-			err = imageRepository.linkTagToImage(imageTagged.ID, tagByName.ID)
+		// a tag created meanwhile by another request is used instead
+		if tagByName == nil {
+			tagByName = &tag.Tag{Name: tagName}
+
+			err = tagRepository.UpsertTag(tagByName)
 			if err != nil {
 				return fmt.Errorf("could not save tag %v", err)
 			}
-			continue
 		}
 
-		newTag := &tag.Tag{Name: tagName}
-
-		err = tagRepository.InsertTag(newTag)
-		if err != nil {
-			return fmt.Errorf("could not save tag %v", err)
+		if linked[tagByName.ID] {
+			continue
 		}
-		err = imageRepository.linkTagToImage(imageTagged.ID, newTag.ID)
+		linked[tagByName.ID] = true
+		names = append(names, tagByName.Name)
+
+		err = imageRepository.LinkTagToImage(imageTagged.ID, tagByName.ID)
 		if err != nil {
 			return fmt.Errorf("could not save tag %v", err)
 		}
 	}
+
+	imageTagged.TagsNames = names
+
 	return nil
 }
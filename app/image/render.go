@@ -0,0 +1,290 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	stdimage "image"
+	"image_gallery/helpers"
+	"image_gallery/imaging"
+	"image_gallery/storage"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/gorilla/mux"
+)
+
+// RenderConfig for images transformed on the fly
+type RenderConfig struct {
+	CachePath     string `env:"RENDER_CACHE_PATH" envDefault:"/go/cache/renders/"`
+	MaxSize       int    `env:"RENDER_MAX_SIZE" envDefault:"2000"`
+	SizeStep      int    `env:"RENDER_SIZE_STEP" envDefault:"50"`
+	MaxConcurrent int    `env:"RENDER_MAX_CONCURRENT" envDefault:"4"`
+}
+
+// Renderer produces transformed versions of image files and caches them on disk
+type Renderer struct {
+	Config RenderConfig
+	Cache  *storage.Local
+	slots  chan struct{}
+}
+
+// NewRenderer returns a renderer configured by RENDER_* env vars
+func NewRenderer() (*Renderer, error) {
+	cfg := RenderConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		return nil, fmt.Errorf("%+v", err)
+	}
+
+	if cfg.MaxSize <= 0 || cfg.SizeStep <= 0 || cfg.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("render sizes and concurrency must be positive")
+	}
+
+	cache, err := storage.NewLocal(cfg.CachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Renderer{
+		Config: cfg,
+		Cache:  cache,
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+	}, nil
+}
+
+type fitMode string
+
+const fitCover fitMode = "cover"
+const fitContain fitMode = "contain"
+
+// renderParams are the transformations requested for a render
+type renderParams struct {
+	Width  int
+	Height int
+	Fit    fitMode
+	Format string
+}
+
+// parseRenderParams reads and validates the render query parameters
+func (renderer *Renderer) parseRenderParams(r *http.Request, image *Image) (*renderParams, error) {
+	query := r.URL.Query()
+	params := renderParams{
+		Fit:    fitMode(query.Get("fit")),
+		Format: query.Get("format"),
+	}
+
+	var err error
+	for name, dst := range map[string]*int{"w": &params.Width, "h": &params.Height} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		*dst, err = strconv.Atoi(value)
+		if err != nil || *dst <= 0 {
+			return nil, fmt.Errorf("%s must be a positive integer", name)
+		}
+
+		if *dst > renderer.Config.MaxSize {
+			return nil, fmt.Errorf("%s cannot be greater than %d", name, renderer.Config.MaxSize)
+		}
+
+		if *dst%renderer.Config.SizeStep != 0 {
+			return nil, fmt.Errorf("%s must be a multiple of %d", name, renderer.Config.SizeStep)
+		}
+	}
+
+	if params.Width == 0 && params.Height == 0 {
+		return nil, fmt.Errorf("w or h must be set")
+	}
+
+	switch params.Fit {
+	case "":
+		params.Fit = fitContain
+	case fitCover, fitContain:
+	default:
+		return nil, fmt.Errorf("fit must be cover or contain")
+	}
+
+	// the original format is kept, formats which cannot be encoded fall back to
+	// png as transparency is not known before decoding
+	if params.Format == "" {
+		params.Format = "png"
+		if f, ok := imaging.FormatByExtension(image.Type); ok && f.Encodable {
+			params.Format = f.Name
+		}
+	}
+
+	if f, ok := imaging.FormatByName(params.Format); !ok || !f.Encodable {
+		return nil, fmt.Errorf("format must be one of %s", encodableFormats())
+	}
+
+	return &params, nil
+}
+
+// cacheKey returns the key of a render in the cache, renders are grouped by image
+func (renderer *Renderer) cacheKey(image *Image, orientation int, params *renderParams) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s%s/%d/%d/%d/%s/%s", image.Slug, image.Type, orientation,
+		params.Width, params.Height, params.Fit, params.Format)))
+
+	format, _ := imaging.FormatByName(params.Format)
+
+	return strconv.FormatInt(image.ID, 10) + "/" + hex.EncodeToString(sum[:]) + format.Extension()
+}
+
+// render transforms the original file displayed with its EXIF orientation and
+// stores the result in the cache
+func (renderer *Renderer) render(original io.Reader, orientation int, key string, params *renderParams) error {
+	// limit the number of images decoded at the same time
+	renderer.slots <- struct{}{}
+	defer func() { <-renderer.slots }()
+
+	src, err := imaging.Decode(original)
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+
+	width, height := params.Width, params.Height
+	bounds := src.Image().Bounds()
+	srcWidth, srcHeight := imaging.OrientedSize(bounds.Dx(), bounds.Dy(), orientation)
+	if width == 0 {
+		width = srcWidth * height / srcHeight
+	}
+	if height == 0 {
+		height = srcHeight * width / srcWidth
+	}
+
+	dst := src.Transform(func(frame stdimage.Image) stdimage.Image {
+		frame = imaging.Orient(frame, orientation)
+		if params.Fit == fitCover {
+			return imaging.Cover(frame, width, height)
+		}
+		return imaging.Contain(frame, width, height)
+	})
+
+	format, _ := imaging.FormatByName(params.Format)
+
+	var buf bytes.Buffer
+	if err = dst.Encode(&buf, format); err != nil {
+		return fmt.Errorf("could not encode render: %v", err)
+	}
+
+	return renderer.Cache.Put(key, &buf, int64(buf.Len()), format.MimeType)
+}
+
+// encodableFormats lists the formats renders can be encoded to
+func encodableFormats() string {
+	names := make([]string, 0, len(imaging.Formats))
+	for _, f := range imaging.Formats {
+		if f.Encodable {
+			names = append(names, f.Name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// clear removes all cached renders of an image
+func (renderer *Renderer) clear(image *Image) error {
+	files, err := renderer.Cache.List(strconv.FormatInt(image.ID, 10) + "/")
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = renderer.Cache.Delete(file.Key)
+		if err != nil && err != storage.ErrNotExist {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) renderImage(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	muxVars := mux.Vars(r)
+	id, err := helpers.ParseInt64(muxVars["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid image id")
+		return
+	}
+
+	image, err := h.Store.Images().SelectImageByID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image")
+		return
+	}
+
+	if image == nil || image.Type == "" {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this image has not been uploaded")
+		return
+	}
+
+	params, err := h.Renderer.parseRenderParams(r, image)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metadata, err := h.Store.Images().SelectMetadataByImageID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image metadata")
+		return
+	}
+	orientation := 0
+	if metadata != nil {
+		orientation = metadata.Orientation
+	}
+
+	key := h.Renderer.cacheKey(image, orientation, params)
+
+	info, err := h.Renderer.Cache.Stat(key)
+	if err == storage.ErrNotExist {
+		var original io.ReadCloser
+		original, err = h.Storage.Get(FileKey(image))
+		if err != nil {
+			h.Logger.Errorf("could not open original file: %v", err)
+			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to read image file")
+			return
+		}
+		defer original.Close()
+
+		err = h.Renderer.render(original, orientation, key, params)
+		if err != nil {
+			h.Logger.Errorf("could not render image: %v", err)
+			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to render image")
+			return
+		}
+
+		info, err = h.Renderer.Cache.Stat(key)
+	}
+	if err != nil {
+		h.Logger.Errorf("could not stat render: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to render image")
+		return
+	}
+
+	file, err := h.Renderer.Cache.Get(key)
+	if err != nil {
+		h.Logger.Errorf("could not open render: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to render image")
+		return
+	}
+	defer file.Close()
+
+	format, _ := imaging.FormatByName(params.Format)
+
+	w.Header().Set("Content-Type", format.MimeType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	// the cache is a local storage so the file can seek
+	http.ServeContent(w, r, "", info.ModTime, file.(io.ReadSeeker))
+}
@@ -0,0 +1,119 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	stdimage "image"
+	"image_gallery/imaging"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+)
+
+// UploadURL is the url prefix under which stored files are served
+const UploadURL = "/uploads/"
+
+// Rendition is a resized copy of an image file
+type Rendition struct {
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Preset describes a rendition generated on upload
+type Preset struct {
+	Name string
+	Size int
+}
+
+// presetNamePattern is the format of preset names, which are part of rendition keys
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// RenditionConfig for renditions generated on upload
+type RenditionConfig struct {
+	Presets []string `env:"RENDITION_PRESETS" envSeparator:"," envDefault:"thumb:200,medium:800,large:1600"`
+}
+
+// LoadPresets parses presets from the RENDITION_PRESETS env var, formatted as name:size
+func LoadPresets() ([]Preset, error) {
+	cfg := RenditionConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		return nil, fmt.Errorf("%+v", err)
+	}
+
+	presets := make([]Preset, 0, len(cfg.Presets))
+	names := make(map[string]bool)
+	for _, p := range cfg.Presets {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(p), ":", 2)
+		if len(parts) != 2 || !presetNamePattern.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid rendition preset %q, names are made of a-z, 0-9, _ and -", p)
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate rendition preset name %q", parts[0])
+		}
+		names[parts[0]] = true
+
+		size, err := strconv.Atoi(parts[1])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid rendition preset size %q", p)
+		}
+
+		presets = append(presets, Preset{Name: parts[0], Size: size})
+	}
+
+	return presets, nil
+}
+
+// RenditionKey returns the storage key of a rendition, next to the original file,
+// ext differs from the image type when the original format cannot be encoded
+func RenditionKey(image *Image, name string, ext string) string {
+	if image.Hash != "" {
+		return BlobKey(image.Hash, "_"+name+ext)
+	}
+
+	return strconv.FormatInt(image.ID, 10) + "/" + image.Slug + "_" + name + ext
+}
+
+// generateRenditions stores a rendition of the decoded original file for every
+// preset, displayed with its EXIF orientation. Animated gifs keep their animation
+func (h *Handler) generateRenditions(src *imaging.Picture, orientation int,
+	image *Image) (map[string]*Rendition, error) {
+	var err error
+	format := imaging.OutputFormat(src.Format, src.Image())
+
+	renditions := make(map[string]*Rendition, len(h.Presets))
+	for _, preset := range h.Presets {
+		size := preset.Size
+		dst := src.Transform(func(frame stdimage.Image) stdimage.Image {
+			return imaging.Fit(imaging.Orient(frame, orientation), size)
+		})
+
+		var buf bytes.Buffer
+		if err = dst.Encode(&buf, format); err != nil {
+			return nil, fmt.Errorf("could not encode %s rendition: %v", preset.Name, err)
+		}
+
+		key := RenditionKey(image, preset.Name, format.Extension())
+		err = h.Storage.Put(key, &buf, int64(buf.Len()), format.MimeType)
+		if err != nil {
+			return nil, fmt.Errorf("could not store %s rendition: %v", preset.Name, err)
+		}
+
+		bounds := dst.Image().Bounds()
+		renditions[preset.Name] = &Rendition{
+			Key:    key,
+			URL:    UploadURL + key,
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		}
+	}
+
+	return renditions, nil
+}
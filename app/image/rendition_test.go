@@ -0,0 +1,44 @@
+package image
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadPresets(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []Preset
+		err   string
+	}{
+		{value: "thumb:200, medium_2:800,", want: []Preset{{Name: "thumb", Size: 200}, {Name: "medium_2", Size: 800}}},
+		{value: "x-large:3200", want: []Preset{{Name: "x-large", Size: 3200}}},
+		{value: "thumb", err: "invalid rendition preset"},
+		{value: ":200", err: "invalid rendition preset"},
+		{value: "Thumb:200", err: "invalid rendition preset"},
+		{value: "../thumb:200", err: "invalid rendition preset"},
+		{value: "thumb:0", err: "invalid rendition preset size"},
+		{value: "thumb:200,medium:800,thumb:400", err: `duplicate rendition preset name "thumb"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			t.Setenv("RENDITION_PRESETS", test.value)
+
+			presets, err := LoadPresets()
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(presets, test.want) {
+				t.Errorf("got presets %+v, want %+v", presets, test.want)
+			}
+		})
+	}
+}
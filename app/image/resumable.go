@@ -0,0 +1,559 @@
+package image
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image_gallery/helpers"
+	"image_gallery/imaging"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/gorilla/mux"
+)
+
+// tus protocol headers, see https://tus.io/protocols/resumable-upload.html
+const (
+	tusVersion          = "1.0.0"
+	tusResumableHeader  = "Tus-Resumable"
+	uploadOffsetHeader  = "Upload-Offset"
+	uploadLengthHeader  = "Upload-Length"
+	uploadExpiresHeader = "Upload-Expires"
+	offsetContentType   = "application/offset+octet-stream"
+)
+
+// TusHeaders are the headers clients send and read during resumable uploads
+var TusHeaders = []string{
+	tusResumableHeader, uploadOffsetHeader, uploadLengthHeader, uploadExpiresHeader, "Upload-Metadata",
+	"Tus-Version", "Tus-Extension", "Tus-Max-Size", "Location",
+}
+
+var errUploadNotFound = errors.New("resumable upload not found")
+
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ResumableConfig for uploads sent in chunks with the tus protocol
+type ResumableConfig struct {
+	Path       string        `env:"TUS_PATH" envDefault:"/go/tmp/tus/"`
+	Expiration time.Duration `env:"TUS_EXPIRATION" envDefault:"24h"`
+}
+
+// Resumable keeps the chunks of resumable uploads on disk until they are complete
+type Resumable struct {
+	Config ResumableConfig
+	mutex  sync.Mutex
+	// busy uploads are being written by a request
+	busy map[string]bool
+}
+
+// resumableUpload is saved next to the uploaded data as a json file
+type resumableUpload struct {
+	ID        string            `json:"id"`
+	ImageID   int64             `json:"image_id"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// Offset is the size of the data received so far
+	Offset int64 `json:"-"`
+}
+
+// NewResumable returns resumable uploads configured by TUS_* env vars
+func NewResumable() (*Resumable, error) {
+	cfg := ResumableConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		return nil, fmt.Errorf("%+v", err)
+	}
+
+	if cfg.Expiration <= 0 {
+		return nil, fmt.Errorf("resumable upload expiration must be positive")
+	}
+
+	if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+		return nil, fmt.Errorf("could not create resumable uploads directory: %v", err)
+	}
+
+	return &Resumable{
+		Config: cfg,
+		busy:   make(map[string]bool),
+	}, nil
+}
+
+func (resumable *Resumable) infoPath(id string) string {
+	return filepath.Join(resumable.Config.Path, id+".info")
+}
+
+func (resumable *Resumable) dataPath(id string) string {
+	return filepath.Join(resumable.Config.Path, id+".bin")
+}
+
+// create starts a new upload of length bytes for an image
+func (resumable *Resumable) create(imageID int64, length int64, metadata map[string]string) (*resumableUpload, error) {
+	resumable.removeExpired()
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("could not generate upload id: %v", err)
+	}
+
+	upload := &resumableUpload{
+		ID:        hex.EncodeToString(random),
+		ImageID:   imageID,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(resumable.Config.Expiration).UTC(),
+	}
+
+	if err := ioutil.WriteFile(resumable.dataPath(upload.ID), nil, 0644); err != nil {
+		return nil, fmt.Errorf("could not create upload file: %v", err)
+	}
+
+	info, err := json.Marshal(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ioutil.WriteFile(resumable.infoPath(upload.ID), info, 0644); err != nil {
+		os.Remove(resumable.dataPath(upload.ID))
+		return nil, fmt.Errorf("could not create upload info file: %v", err)
+	}
+
+	return upload, nil
+}
+
+// load reads an upload and the size of its data, expired uploads are not found
+func (resumable *Resumable) load(id string) (*resumableUpload, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, errUploadNotFound
+	}
+
+	info, err := ioutil.ReadFile(resumable.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, errUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read upload info file: %v", err)
+	}
+
+	var upload resumableUpload
+	if err = json.Unmarshal(info, &upload); err != nil {
+		return nil, fmt.Errorf("could not decode upload info file: %v", err)
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, errUploadNotFound
+	}
+
+	stat, err := os.Stat(resumable.dataPath(id))
+	if os.IsNotExist(err) {
+		return nil, errUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read upload file: %v", err)
+	}
+	upload.Offset = stat.Size()
+
+	return &upload, nil
+}
+
+// lock marks an upload as busy, false is returned when it already is
+func (resumable *Resumable) lock(id string) bool {
+	resumable.mutex.Lock()
+	defer resumable.mutex.Unlock()
+
+	if resumable.busy[id] {
+		return false
+	}
+	resumable.busy[id] = true
+
+	return true
+}
+
+func (resumable *Resumable) unlock(id string) {
+	resumable.mutex.Lock()
+	defer resumable.mutex.Unlock()
+
+	delete(resumable.busy, id)
+}
+
+// write appends a chunk to the upload data, bytes written before an error
+// are kept so the client can resume from there
+func (resumable *Resumable) write(upload *resumableUpload, r io.Reader) error {
+	f, err := os.OpenFile(resumable.dataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open upload file: %v", err)
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset))
+	upload.Offset += n
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// open returns the data of a complete upload
+func (resumable *Resumable) open(upload *resumableUpload) (*os.File, error) {
+	return os.Open(resumable.dataPath(upload.ID))
+}
+
+// remove deletes the data and info files of an upload
+func (resumable *Resumable) remove(id string) error {
+	if err := os.Remove(resumable.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(resumable.infoPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// removeExpired deletes uploads which were not completed in time
+func (resumable *Resumable) removeExpired() {
+	files, err := filepath.Glob(filepath.Join(resumable.Config.Path, "*.info"))
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".info")
+		if _, err = resumable.load(id); err == errUploadNotFound && resumable.lock(id) {
+			resumable.remove(id)
+			resumable.unlock(id)
+		}
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, made of comma
+// separated keys followed by their base64 encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata header")
+		}
+
+		var value []byte
+		if len(parts) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %s", parts[0])
+			}
+		}
+		metadata[parts[0]] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// checkTusVersion makes sure the client speaks the same protocol version
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set(tusResumableHeader, tusVersion)
+
+	if r.Header.Get(tusResumableHeader) != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		helpers.WriteErrorJSON(w, http.StatusPreconditionFailed, "Tus-Resumable must be "+tusVersion)
+		return false
+	}
+
+	return true
+}
+
+// uploadableImage returns the image of the id url parameter if no file has
+// been uploaded for it yet, errors are written to w
+func (h *Handler) uploadableImage(w http.ResponseWriter, r *http.Request) (*Image, bool) {
+	muxVars := mux.Vars(r)
+	id, err := helpers.ParseInt64(muxVars["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid image id")
+		return nil, false
+	}
+
+	image, err := h.Store.Images().SelectImageByID(id)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image by id : %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not check if image has already been uploaded")
+		return nil, false
+	}
+
+	if image == nil {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "image id "+muxVars["id"]+" does not exist")
+		return nil, false
+	}
+
+	if image.Type != "" {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "You already have uploaded this image")
+		return nil, false
+	}
+
+	return image, true
+}
+
+// loadResumableUpload returns the upload of the url parameters, errors are written to w
+func (h *Handler) loadResumableUpload(w http.ResponseWriter, r *http.Request) (*resumableUpload, bool) {
+	muxVars := mux.Vars(r)
+
+	upload, err := h.Resumable.load(muxVars["upload_id"])
+	if err == nil && strconv.FormatInt(upload.ImageID, 10) != muxVars["id"] {
+		err = errUploadNotFound
+	}
+	if err == errUploadNotFound {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "upload does not exist or has expired")
+		return nil, false
+	}
+	if err != nil {
+		h.Logger.Errorf("could not load resumable upload: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not read upload")
+		return nil, false
+	}
+
+	return upload, true
+}
+
+func (h *Handler) createResumableUpload(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get(uploadLengthHeader), 10, 64)
+	if err != nil || length <= 0 {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Upload-Length must be a positive integer")
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// options are checked now so the client does not send the whole file in vain
+	get := func(name string) string { return metadata[name] }
+	if _, err = parseUploadOptions(get, nil); err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	image, ok := h.uploadableImage(w, r)
+	if !ok {
+		return
+	}
+
+	imageCategory, err := h.Store.Categories().SelectCategoryByID(image.CategoryID)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image category : %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not retrieve image category")
+		return
+	}
+
+	if maxSize := h.Upload.MaxSizeFor(imageCategory); length > maxSize {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+		helpers.WriteErrorJSON(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File cannot exceed %d bytes", maxSize))
+		return
+	}
+
+	upload, err := h.Resumable.create(image.ID, length, metadata)
+	if err != nil {
+		h.Logger.Errorf("could not create resumable upload: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not create upload")
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+upload.ID)
+	w.Header().Set(uploadExpiresHeader, upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) headResumableUpload(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	upload, ok := h.loadResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	w.Header().Set(uploadExpiresHeader, upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patchResumableUpload(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != offsetContentType {
+		helpers.WriteErrorJSON(w, http.StatusUnsupportedMediaType, "Content-Type must be "+offsetContentType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Upload-Offset must be a positive integer")
+		return
+	}
+
+	// the offset is read once the upload is locked, a chunk written meanwhile would make it stale
+	id := mux.Vars(r)["upload_id"]
+	if !h.Resumable.lock(id) {
+		helpers.WriteErrorJSON(w, http.StatusLocked, "upload is being written by another request")
+		return
+	}
+	defer h.Resumable.unlock(id)
+
+	upload, ok := h.loadResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if offset != upload.Offset {
+		helpers.WriteErrorJSON(w, http.StatusConflict,
+			fmt.Sprintf("Upload-Offset %d does not match the upload offset %d", offset, upload.Offset))
+		return
+	}
+
+	if r.ContentLength > upload.Length-upload.Offset {
+		helpers.WriteErrorJSON(w, http.StatusRequestEntityTooLarge, "chunk exceeds the upload length")
+		return
+	}
+
+	err = h.Resumable.write(upload, r.Body)
+	if err != nil {
+		h.Logger.Errorf("could not write resumable upload chunk: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not write chunk")
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(uploadExpiresHeader, upload.ExpiresAt.Format(http.TimeFormat))
+
+	if upload.Offset == upload.Length {
+		h.completeResumableUpload(w, r, upload)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeResumableUpload validates and saves the file once all chunks are
+// received. The upload is removed once saved or rejected, it is kept after
+// other errors so the client can retry with an empty chunk
+func (h *Handler) completeResumableUpload(w http.ResponseWriter, r *http.Request, upload *resumableUpload) {
+	remove := false
+	defer func() {
+		if !remove {
+			return
+		}
+		if err := h.Resumable.remove(upload.ID); err != nil {
+			h.Logger.Errorf("could not remove resumable upload: %v", err)
+		}
+	}()
+
+	image, ok := h.uploadableImage(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := h.Resumable.open(upload)
+	if err != nil {
+		h.Logger.Errorf("could not open resumable upload: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not read upload")
+		return
+	}
+	defer file.Close()
+
+	info, err := imaging.Validate(file, h.Upload.Limits())
+	if _, ok := err.(*imaging.ValidationError); ok {
+		remove = true
+	}
+	if err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	imageCategory, err := h.Store.Categories().SelectCategoryByID(image.CategoryID)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image category : %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not retrieve image category")
+		return
+	}
+
+	options, err := parseUploadOptions(func(name string) string { return upload.Metadata[name] }, imageCategory)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.saveFile(file, upload.Length, info, image, options)
+	if _, ok := err.(*imaging.ValidationError); ok {
+		remove = true
+		h.writeValidationError(w, err)
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("could not save file: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "File could not be uploaded")
+		return
+	}
+
+	remove = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) deleteResumableUpload(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	id := mux.Vars(r)["upload_id"]
+	if !h.Resumable.lock(id) {
+		helpers.WriteErrorJSON(w, http.StatusLocked, "upload is being written by another request")
+		return
+	}
+	defer h.Resumable.unlock(id)
+
+	upload, ok := h.loadResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Resumable.remove(upload.ID); err != nil {
+		h.Logger.Errorf("could not remove resumable upload: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not delete upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
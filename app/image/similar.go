@@ -0,0 +1,237 @@
+package image
+
+import (
+	"fmt"
+	"image_gallery/helpers"
+	"image_gallery/imaging"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultSimilarThreshold is the Hamming distance under which images are similar
+const defaultSimilarThreshold = 10
+
+// defaultDuplicateThreshold is the Hamming distance under which images are near duplicates
+const defaultDuplicateThreshold = 4
+
+// maxSimilarResults is the number of similar images returned at most
+const maxSimilarResults = 100
+
+// similarImage is an image ranked by the distance of its perceptual hash
+type similarImage struct {
+	Distance int    `json:"distance"`
+	Image    *Image `json:"image"`
+}
+
+// duplicateCluster groups images which are near duplicates of each other
+type duplicateCluster struct {
+	MaxDistance int      `json:"max_distance"`
+	Images      []*Image `json:"images"`
+}
+
+// parseThreshold reads the threshold query parameter, a Hamming distance
+func parseThreshold(r *http.Request, defaultThreshold int) (int, error) {
+	value := r.URL.Query().Get("threshold")
+	if value == "" {
+		return defaultThreshold, nil
+	}
+
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 || threshold > 64 {
+		return 0, fmt.Errorf("threshold must be an integer between 0 and 64")
+	}
+
+	return threshold, nil
+}
+
+// getSimilarImages lists the images whose perceptual hash is within the
+// threshold of the image one, closest first
+func (h *Handler) getSimilarImages(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	repository := h.Store.Images()
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid image id")
+		return
+	}
+
+	threshold, err := parseThreshold(r, defaultSimilarThreshold)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	image, err := repository.SelectImageByID(id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image")
+		return
+	}
+
+	if image == nil || image.Type == "" {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this image has not been uploaded")
+		return
+	}
+
+	if image.PerceptualHash == nil {
+		helpers.WriteErrorJSON(w, http.StatusConflict, "this image was uploaded before similarity search,"+
+			" upload it again to compare it")
+		return
+	}
+
+	hashes, err := repository.SelectPerceptualHashes()
+	if err != nil {
+		h.Logger.Errorf("could not retrieve perceptual hashes: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to search similar images")
+		return
+	}
+
+	similar := make([]*similarImage, 0)
+	for _, hash := range hashes {
+		distance := imaging.HammingDistance(*image.PerceptualHash, hash.Hash)
+		if hash.ImageID != image.ID && distance <= threshold {
+			similar = append(similar, &similarImage{Distance: distance, Image: &Image{ID: hash.ImageID}})
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	if len(similar) > maxSimilarResults {
+		similar = similar[:maxSimilarResults]
+	}
+
+	for _, result := range similar {
+		result.Image, err = selectListedImage(h.Store, result.Image.ID)
+		if err != nil {
+			h.Logger.Errorf("could not retrieve similar image: %v", err)
+			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to search similar images")
+			return
+		}
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, similar)
+}
+
+// getDuplicateClusters reports groups of near duplicate images, each image of
+// a group being within the threshold of another one. Only the owner can list them
+func (h *Handler) getDuplicateClusters(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	if !helpers.IsOwner(r, h.OwnerToken) {
+		helpers.WriteErrorJSON(w, http.StatusForbidden, "only the owner can list near duplicates")
+		return
+	}
+
+	threshold, err := parseThreshold(r, defaultDuplicateThreshold)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashes, err := h.Store.Images().SelectPerceptualHashes()
+	if err != nil {
+		h.Logger.Errorf("could not retrieve perceptual hashes: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to list near duplicates")
+		return
+	}
+
+	clusters := make([]*duplicateCluster, 0)
+	for _, members := range clusterHashes(hashes, threshold) {
+		cluster := &duplicateCluster{Images: make([]*Image, 0, len(members))}
+
+		for i, member := range members {
+			for _, other := range members[i+1:] {
+				if distance := imaging.HammingDistance(member.Hash, other.Hash); distance > cluster.MaxDistance {
+					cluster.MaxDistance = distance
+				}
+			}
+
+			image, err := selectListedImage(h.Store, member.ImageID)
+			if err != nil {
+				h.Logger.Errorf("could not retrieve near duplicate image: %v", err)
+				helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to list near duplicates")
+				return
+			}
+			cluster.Images = append(cluster.Images, image)
+		}
+
+		clusters = append(clusters, cluster)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, clusters)
+}
+
+// clusterHashes groups hashes linked by a distance within the threshold,
+// groups of a single image are left out. Largest groups come first
+func clusterHashes(hashes []PerceptualHash, threshold int) [][]PerceptualHash {
+	parents := make([]int, len(hashes))
+	for i := range parents {
+		parents[i] = i
+	}
+
+	var root func(i int) int
+	root = func(i int) int {
+		if parents[i] != i {
+			parents[i] = root(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if imaging.HammingDistance(hashes[i].Hash, hashes[j].Hash) <= threshold {
+				parents[root(j)] = root(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]PerceptualHash)
+	var roots []int
+	for i, hash := range hashes {
+		r := root(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], hash)
+	}
+
+	clusters := make([][]PerceptualHash, 0)
+	for _, r := range roots {
+		if len(groups[r]) > 1 {
+			clusters = append(clusters, groups[r])
+		}
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i]) > len(clusters[j])
+	})
+
+	return clusters
+}
+
+// selectListedImage retrieves an image listed by a similarity search with its
+// tags and renditions
+func selectListedImage(store Store, id int64) (*Image, error) {
+	image, err := store.Images().SelectImageByID(id)
+	if err != nil || image == nil {
+		return image, err
+	}
+
+	image.TagsNames, err = store.Tags().GetAllTagsByImageID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	image.Renditions, err = store.Images().SelectRenditionsByImageID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
@@ -0,0 +1,79 @@
+package image
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+		err   bool
+	}{
+		{query: "", want: 7},
+		{query: "threshold=0", want: 0},
+		{query: "threshold=64", want: 64},
+		{query: "threshold=-1", err: true},
+		{query: "threshold=65", err: true},
+		{query: "threshold=ten", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			threshold, err := parseThreshold(httptest.NewRequest("GET", "/images/1/similar?"+test.query, nil), 7)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got threshold %d, want an error", threshold)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if threshold != test.want {
+				t.Errorf("got threshold %d, want %d", threshold, test.want)
+			}
+		})
+	}
+}
+
+func TestClusterHashes(t *testing.T) {
+	hashes := []PerceptualHash{
+		{ImageID: 1, Hash: 0x0},
+		{ImageID: 2, Hash: 0xFFFF0000},
+		{ImageID: 3, Hash: 0x3},
+		// within a distance of 2, 4 is only close to 3 and joins the cluster of 1 through 3
+		{ImageID: 4, Hash: 0xF},
+		{ImageID: 5, Hash: 0xFFFF0001},
+		{ImageID: 6, Hash: 0xFFFFFFFFFFFFFFFF},
+	}
+
+	tests := []struct {
+		name      string
+		threshold int
+		want      [][]int64
+	}{
+		{name: "largest clusters first", threshold: 2, want: [][]int64{{1, 3, 4}, {2, 5}}},
+		{name: "single pair", threshold: 1, want: [][]int64{{2, 5}}},
+		{name: "no near duplicate", threshold: 0, want: [][]int64{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([][]int64, 0)
+			for _, cluster := range clusterHashes(hashes, test.threshold) {
+				var ids []int64
+				for _, hash := range cluster {
+					ids = append(ids, hash.ImageID)
+				}
+				got = append(got, ids)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got clusters %v, want %v", got, test.want)
+			}
+		})
+	}
+}
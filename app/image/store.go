@@ -0,0 +1,87 @@
+package image
+
+import (
+	"database/sql"
+	"fmt"
+	"image_gallery/category"
+	"image_gallery/database"
+	"image_gallery/tag"
+)
+
+// Store gives access to the repositories the image handler works with
+type Store interface {
+	Images() Repository
+	Categories() category.Repository
+	Tags() tag.Repository
+	// Transaction runs fn with a store whose changes are committed when fn
+	// returns nil and rolled back otherwise
+	Transaction(fn func(store Store) error) error
+}
+
+// SQLStore is the Store of a SQL database
+type SQLStore struct {
+	// Conn is the connection pool, or the transaction of a store given to Transaction
+	Conn   database.Querier
+	Driver database.Driver
+}
+
+// Images returns the image repository
+func (s *SQLStore) Images() Repository {
+	return &SQLRepository{Conn: s.Driver.Conn(s.Conn), Driver: s.Driver}
+}
+
+// Categories returns the category repository
+func (s *SQLStore) Categories() category.Repository {
+	return &category.SQLRepository{Conn: s.Driver.Conn(s.Conn), Driver: s.Driver}
+}
+
+// Tags returns the tag repository
+func (s *SQLStore) Tags() tag.Repository {
+	return &tag.SQLRepository{Conn: s.Driver.Conn(s.Conn), Driver: s.Driver}
+}
+
+// Transaction runs fn inside a database transaction, a transaction started
+// within another one joins it
+func (s *SQLStore) Transaction(fn func(store Store) error) error {
+	db, ok := s.Conn.(*sql.DB)
+	if !ok {
+		return fn(s)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	if err = fn(&SQLStore{Conn: tx, Driver: s.Driver}); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			return fmt.Errorf("%v, could not rollback transaction: %v", err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TagStore returns the tag.Store of a store, its transactions are the
+// transactions of store
+func TagStore(store Store) tag.Store {
+	return &tagStore{store: store}
+}
+
+// tagStore is the tag.Store of a Store
+type tagStore struct {
+	store Store
+}
+
+// Tags returns the tag repository
+func (s *tagStore) Tags() tag.Repository {
+	return s.store.Tags()
+}
+
+// Transaction runs fn inside a transaction of the store
+func (s *tagStore) Transaction(fn func(store tag.Store) error) error {
+	return s.store.Transaction(func(store Store) error {
+		return fn(&tagStore{store: store})
+	})
+}
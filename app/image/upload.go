@@ -0,0 +1,592 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"image_gallery/category"
+	"image_gallery/helpers"
+	"image_gallery/imaging"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/gorilla/mux"
+)
+
+// maxFormValuesSize is the maximum size of all form values sent with a file
+const maxFormValuesSize = 32 * 1024
+
+// multipartOverhead is allowed on top of the file size for the multipart
+// envelope and the other form values
+const multipartOverhead = 2 * maxFormValuesSize
+
+// UploadConfig for uploaded files validation
+type UploadConfig struct {
+	// MaxSize of uploaded files in bytes, categories can override it
+	MaxSize   int64 `env:"UPLOAD_MAX_SIZE" envDefault:"2097152"`
+	MaxWidth  int   `env:"UPLOAD_MAX_WIDTH" envDefault:"10000"`
+	MaxHeight int   `env:"UPLOAD_MAX_HEIGHT" envDefault:"10000"`
+	MaxPixels int64 `env:"UPLOAD_MAX_PIXELS" envDefault:"50000000"`
+	// Formats are the names of accepted image formats
+	Formats []string `env:"UPLOAD_FORMATS" envSeparator:"," envDefault:"jpeg,png,gif,webp,avif,tiff"`
+	// TmpPath is the directory of files being received, the system one when empty
+	TmpPath string `env:"UPLOAD_TMP_PATH"`
+	// MaxArchiveSize of zip archives sent to bulk uploads in bytes
+	MaxArchiveSize int64 `env:"UPLOAD_MAX_ARCHIVE_SIZE" envDefault:"524288000"`
+	// MaxBulkFiles is the number of files a bulk upload can create
+	MaxBulkFiles int `env:"UPLOAD_MAX_BULK_FILES" envDefault:"1000"`
+}
+
+// LoadUploadConfig reads the upload config from UPLOAD_* env vars
+func LoadUploadConfig() (UploadConfig, error) {
+	cfg := UploadConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, fmt.Errorf("%+v", err)
+	}
+
+	if cfg.MaxSize <= 0 || cfg.MaxArchiveSize <= 0 || cfg.MaxBulkFiles <= 0 {
+		return cfg, fmt.Errorf("upload max sizes and bulk files must be positive")
+	}
+
+	for i, name := range cfg.Formats {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := imaging.FormatByName(name); !ok {
+			return cfg, fmt.Errorf("unknown upload format %q", name)
+		}
+		cfg.Formats[i] = name
+	}
+
+	return cfg, nil
+}
+
+// Limits returns the limits used to validate uploaded images
+func (cfg UploadConfig) Limits() imaging.Limits {
+	return imaging.Limits{
+		MaxWidth:  cfg.MaxWidth,
+		MaxHeight: cfg.MaxHeight,
+		MaxPixels: cfg.MaxPixels,
+		Formats:   cfg.Formats,
+	}
+}
+
+// MaxSizeFor returns the maximum size of files uploaded in a category
+func (cfg UploadConfig) MaxSizeFor(imageCategory *category.Category) int64 {
+	if imageCategory != nil && imageCategory.MaxUploadSize != nil {
+		return *imageCategory.MaxUploadSize
+	}
+
+	return cfg.MaxSize
+}
+
+// uploadError is a client error while receiving a file
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	muxVars := mux.Vars(r)
+
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	repository := h.Store.Images()
+
+	id, err := helpers.ParseInt64(muxVars["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+
+	image, err := repository.SelectImageByID(id)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image by id : %v", err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "Could not check if image has already been uploaded")
+		return
+	}
+
+	if image == nil {
+		h.Logger.Infof("image metadata with id %d does not exists", id)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "image id"+muxVars["id"]+" does not exist")
+		return
+	}
+
+	if image.Type != "" {
+		h.Logger.Errorf("image has already been uploaded to file server")
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "You already have uploaded this image")
+		return
+	}
+
+	imageCategory, err := h.Store.Categories().SelectCategoryByID(image.CategoryID)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image category : %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not retrieve image category")
+		return
+	}
+
+	maxSize := h.Upload.MaxSizeFor(imageCategory)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+
+	file, fileSize, values, err := h.receiveFile(r, func(url.Values) int64 { return maxSize })
+	if err != nil {
+		if uploadErr, ok := err.(*uploadError); ok {
+			h.Logger.Infof("rejected upload: %v", uploadErr)
+			helpers.WriteErrorJSON(w, uploadErr.Status, uploadErr.Message)
+			return
+		}
+		h.Logger.Errorf("could not receive file: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not upload file")
+		return
+	}
+	defer removeTempFile(file)
+
+	options, err := parseUploadOptions(values.Get, imageCategory)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the content is checked, the Content-Type sent by the client is ignored
+	info, err := imaging.Validate(file, h.Upload.Limits())
+	if err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	err = h.saveFile(file, fileSize, info, image, options)
+	if _, ok := err.(*imaging.ValidationError); ok {
+		h.writeValidationError(w, err)
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("could not save file: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "File could not be uploaded")
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusCreated, "File uploaded successfully!.")
+}
+
+// receiveFile streams the file part of a multipart request to a temporary
+// file, the other parts are returned as form values. maxSize returns the size
+// limit of the file from the form values sent before it
+func (h *Handler) receiveFile(r *http.Request, maxSize func(url.Values) int64) (*os.File, int64, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, 0, nil, &uploadError{Status: http.StatusBadRequest, Message: "Request must be multipart/form-data"}
+	}
+
+	var file *os.File
+	var size, limit int64
+	values := url.Values{}
+	valuesSize := int64(0)
+
+	fail := func(err error) (*os.File, int64, url.Values, error) {
+		removeTempFile(file)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			message := "Request is too large"
+			if limit > 0 {
+				message = fmt.Sprintf("File cannot exceed %d bytes", limit)
+			}
+			err = &uploadError{Status: http.StatusRequestEntityTooLarge, Message: message}
+		}
+
+		return nil, 0, nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if !errors.As(err, &maxBytesErr) {
+				err = &uploadError{Status: http.StatusBadRequest, Message: "Invalid multipart form data"}
+			}
+			return fail(err)
+		}
+
+		if part.FormName() != "file" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValuesSize-valuesSize+1))
+			if err != nil {
+				return fail(err)
+			}
+
+			valuesSize += int64(len(value))
+			if valuesSize > maxFormValuesSize {
+				return fail(&uploadError{Status: http.StatusRequestEntityTooLarge, Message: "Form values are too large"})
+			}
+
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if file != nil {
+			return fail(&uploadError{Status: http.StatusBadRequest, Message: "Only one file can be uploaded"})
+		}
+
+		limit = maxSize(values)
+		if file, size, err = h.copyToTempFile(part, limit); err != nil {
+			return fail(err)
+		}
+	}
+
+	if file == nil {
+		return fail(&uploadError{Status: http.StatusBadRequest, Message: "Missing file"})
+	}
+
+	return file, size, values, nil
+}
+
+// copyToTempFile streams r to a temporary file rewound to its start, an
+// *http.MaxBytesError is returned when r is larger than limit
+func (h *Handler) copyToTempFile(r io.Reader, limit int64) (*os.File, int64, error) {
+	file, err := ioutil.TempFile(h.Upload.TmpPath, "upload-")
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create temporary file: %v", err)
+	}
+
+	// one more byte than allowed tells the file is too large
+	size, err := io.Copy(file, io.LimitReader(r, limit+1))
+	if err == nil && size > limit {
+		err = &http.MaxBytesError{Limit: limit}
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		removeTempFile(file)
+		return nil, 0, err
+	}
+
+	return file, size, nil
+}
+
+// removeTempFile closes and deletes a temporary file, nil files are ignored
+func removeTempFile(file *os.File) {
+	if file == nil {
+		return
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// writeValidationError tells the client why its file was rejected
+func (h *Handler) writeValidationError(w http.ResponseWriter, err error) {
+	validationErr, ok := err.(*imaging.ValidationError)
+	if !ok {
+		h.Logger.Errorf("could not validate file: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "File could not be read")
+		return
+	}
+
+	h.Logger.Infof("rejected file: %v", validationErr)
+	if validationErr.Unsupported {
+		helpers.WriteErrorJSON(w, http.StatusUnsupportedMediaType, validationErr.Message)
+		return
+	}
+	helpers.WriteErrorJSON(w, http.StatusUnprocessableEntity, validationErr.Message)
+}
+
+// uploadOptions change how an uploaded file is stored, defaults are set by the image category
+type uploadOptions struct {
+	// StripMetadata removes EXIF, XMP and IPTC blocks from the stored file
+	StripMetadata bool
+	// KeepMetadata keeps stripped metadata in database, readable only by the owner
+	KeepMetadata bool
+	// LinkDuplicate creates an image linked to the stored file when the same
+	// content was already uploaded, instead of returning the existing image
+	LinkDuplicate bool
+}
+
+// parseUploadOptions reads the strip_metadata and keep_metadata values, get
+// returns the value of an option such as a form value
+func parseUploadOptions(get func(string) string, imageCategory *category.Category) (uploadOptions, error) {
+	options := uploadOptions{KeepMetadata: true}
+	if imageCategory != nil {
+		options.StripMetadata = imageCategory.StripMetadata
+	}
+
+	for name, dst := range map[string]*bool{
+		"strip_metadata": &options.StripMetadata,
+		"keep_metadata":  &options.KeepMetadata,
+		"link_duplicate": &options.LinkDuplicate,
+	} {
+		value := get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("%s must be a boolean", name)
+		}
+		*dst = parsed
+	}
+
+	return options, nil
+}
+
+// saveFile stores a validated file with its renditions and metadata, the rows
+// of the file are saved in a single transaction and the file is removed when
+// they cannot be saved
+func (h *Handler) saveFile(file io.ReadSeeker, size int64, info *imaging.Info, image *Image,
+	options uploadOptions) error {
+
+	prepared, err := h.prepareFile(file, size, options)
+	if err != nil {
+		return err
+	}
+	defer prepared.Close()
+
+	if err = h.storeBlob(prepared, info, image.ID); err != nil {
+		return err
+	}
+
+	err = h.Store.Transaction(func(store Store) error {
+		return storeFile(store.Images(), prepared, info, image)
+	})
+	if err != nil {
+		h.removeFiles(prepared)
+		return err
+	}
+
+	return nil
+}
+
+// storeFile saves the rows of a file written by storeBlob, when the same
+// content is stored for another image it is linked with its renditions
+func storeFile(repository Repository, prepared *preparedFile, info *imaging.Info, image *Image) error {
+	// the image whose file was reused may have been deleted with its file since
+	if !prepared.stored {
+		linked, err := repository.SelectImageByHash(prepared.Hash, image.ID)
+		if err != nil {
+			return fmt.Errorf("could not check if file is already stored: %v", err)
+		}
+		if linked == nil {
+			return fmt.Errorf("file %s was deleted while being linked", prepared.Hash)
+		}
+	}
+
+	image.Type = info.Format.Extension()
+	image.Hash = prepared.Hash
+	image.PerceptualHash = prepared.PerceptualHash
+	image.Width, image.Height, image.BlurHash = prepared.Width, prepared.Height, prepared.BlurHash
+
+	err := repository.UpdateImageFile(image)
+	if err != nil {
+		return fmt.Errorf("could not update image type: %v", err)
+	}
+
+	image.Metadata = prepared.Metadata
+
+	err = repository.InsertMetadata(image.ID, image.Metadata)
+	if err != nil {
+		return err
+	}
+
+	image.Palette = prepared.Palette
+
+	err = repository.InsertPalette(image.ID, image.Palette)
+	if err != nil {
+		return err
+	}
+
+	image.Renditions = prepared.Renditions
+
+	for name, rendition := range image.Renditions {
+		err = repository.InsertRendition(image.ID, name, rendition)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createImageWithFile creates an image and stores its file from a single multipart
+// request, nothing is kept when the file cannot be stored
+func (h *Handler) createImageWithFile(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	categoryRepository := h.Store.Categories()
+
+	// the category limit applies when category_id is sent before the file
+	maxSize := func(values url.Values) int64 {
+		id, err := helpers.ParseInt64(values.Get("category_id"))
+		if err != nil {
+			return h.Upload.MaxSize
+		}
+
+		imageCategory, err := categoryRepository.SelectCategoryByID(id)
+		if err != nil {
+			return h.Upload.MaxSize
+		}
+
+		return h.Upload.MaxSizeFor(imageCategory)
+	}
+
+	file, fileSize, values, err := h.receiveFile(r, maxSize)
+	if err != nil {
+		if uploadErr, ok := err.(*uploadError); ok {
+			h.Logger.Infof("rejected upload: %v", uploadErr)
+			helpers.WriteErrorJSON(w, uploadErr.Status, uploadErr.Message)
+			return
+		}
+		h.Logger.Errorf("could not receive file: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "Could not upload file")
+		return
+	}
+	defer removeTempFile(file)
+
+	imageToCreate := Image{
+		Name:        values.Get("name"),
+		Description: values.Get("description"),
+		TagsNames:   formTags(values),
+	}
+
+	imageToCreate.CategoryID, err = helpers.ParseInt64(values.Get("category_id"))
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "category_id must be an integer")
+		return
+	}
+
+	if err = imageToCreate.Validate(); err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	imageCategory, err := categoryRepository.SelectCategoryByID(imageToCreate.CategoryID)
+	if err != nil {
+		h.Logger.Errorf("could not retrieve image category : %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve image category")
+		return
+	}
+	if imageCategory == nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "category does not exist")
+		return
+	}
+
+	// category_id may have been sent after the file
+	if limit := h.Upload.MaxSizeFor(imageCategory); fileSize > limit {
+		helpers.WriteErrorJSON(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File cannot exceed %d bytes", limit))
+		return
+	}
+
+	options, err := parseUploadOptions(values.Get, imageCategory)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.createImageFromFile(&imageToCreate, file, fileSize, options)
+	if err != nil {
+		if _, ok := err.(*imaging.ValidationError); ok {
+			h.writeValidationError(w, err)
+			return
+		}
+		if duplicateErr, ok := err.(*duplicateError); ok {
+			h.Logger.Infof("duplicate upload: %v", duplicateErr)
+			helpers.WriteJSON(w, http.StatusOK, duplicateErr.Image)
+			return
+		}
+		h.Logger.Errorf("could not create image with file: %v", err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to save image")
+		return
+	}
+
+	imageToCreate.Category = imageCategory
+
+	h.Logger.Infof("saved image: %v", imageToCreate)
+	helpers.WriteJSON(w, http.StatusCreated, imageToCreate)
+}
+
+// createImageFromFile validates a received file then saves the image, its tags
+// and the file in a single transaction, nothing is kept on failure. A
+// duplicateError is returned when the file is already stored, unless the
+// options link the new image to it
+func (h *Handler) createImageFromFile(imageToCreate *Image, file *os.File, size int64, options uploadOptions) error {
+	info, err := imaging.Validate(file, h.Upload.Limits())
+	if err != nil {
+		return err
+	}
+
+	prepared, err := h.prepareFile(file, size, options)
+	if err != nil {
+		return err
+	}
+	defer prepared.Close()
+
+	if !options.LinkDuplicate {
+		duplicate, err := findDuplicate(h.Store, prepared.Hash)
+		if err != nil {
+			return fmt.Errorf("could not check if file is already stored: %v", err)
+		}
+		if duplicate != nil {
+			return &duplicateError{Image: duplicate}
+		}
+	}
+
+	if err = h.storeBlob(prepared, info, 0); err != nil {
+		return err
+	}
+
+	err = h.Store.Transaction(func(store Store) error {
+		if err := store.Images().InsertImage(imageToCreate); err != nil {
+			return err
+		}
+		if imageToCreate.TagsNames != nil {
+			if err := saveTags(store, h.TagNames, imageToCreate); err != nil {
+				return err
+			}
+		}
+		return storeFile(store.Images(), prepared, info, imageToCreate)
+	})
+
+	if err != nil {
+		h.removeFiles(prepared)
+		return err
+	}
+
+	return nil
+}
+
+// formTags reads tags sent as repeated or comma separated tags form values
+func formTags(values url.Values) []string {
+	var tags []string
+	for _, value := range values["tags"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				tags = append(tags, name)
+			}
+		}
+	}
+
+	return tags
+}
+
+// removeFiles deletes the file written by storeBlob for an image whose rows
+// could not be saved, unless another image uses it
+func (h *Handler) removeFiles(prepared *preparedFile) {
+	if prepared.stored {
+		h.removeBlob(prepared.Hash)
+	}
+}
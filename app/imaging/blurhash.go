@@ -0,0 +1,130 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// blurHashSampleSize is the largest side of the copy a BlurHash is computed from
+const blurHashSampleSize = 64
+
+// blurHashComponents is the number of components along the largest side
+const blurHashComponents = 4
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash returns the BlurHash of img displayed with its EXIF orientation
+// (https://blurha.sh), a short string decoded by clients into a blurred
+// placeholder of the image. Images are described with 4 components along
+// their largest side and 3 along the other
+func BlurHash(img image.Image, orientation int) string {
+	sample := Orient(Fit(img, blurHashSampleSize), orientation)
+	bounds := sample.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	xComponents, yComponents := blurHashComponents, blurHashComponents-1
+	if height > width {
+		xComponents, yComponents = yComponents, xComponents
+	}
+
+	pixels := make([][3]float64, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(sample.At(x, y)).(color.RGBA)
+			pixels = append(pixels, [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)})
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) *
+						math.Cos(math.Pi*float64(j*y)/float64(height))
+					for k, v := range pixels[y*width+x] {
+						factor[k] += basis * v
+					}
+				}
+			}
+
+			for k := range factor {
+				factor[k] /= float64(width * height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encodeBase83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		var quantised [3]int
+		for k, v := range factor {
+			quantised[k] = int(math.Max(0, math.Min(18, math.Floor(signedSqrt(v/maxValue)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2))
+	}
+
+	return hash.String()
+}
+
+func encodeBase83(value int, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = base83Characters[value%83]
+		value /= 83
+	}
+
+	return string(encoded)
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signedSqrt(v float64) float64 {
+	if v < 0 {
+		return -math.Sqrt(-v)
+	}
+	return math.Sqrt(v)
+}
@@ -0,0 +1,57 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBlurHash(t *testing.T) {
+	// a 32x24 gradient, red along its width and green along its height
+	gradient := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			gradient.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 10), B: 128, A: 255})
+		}
+	}
+
+	// hashes of the displayed gradient encoded by the reference algorithm of https://blurha.sh
+	tests := []struct {
+		name        string
+		orientation int
+		want        string
+	}{
+		{name: "without orientation", orientation: 0, want: "LxH27k2swxX8mHWWjtf7gJfjfQfj"},
+		{name: "normal", orientation: 1, want: "LxH27k2swxX8mHWWjtf7gJfjfQfj"},
+		{name: "rotated half a turn", orientation: 3, want: "L*H27k|_$5xGp=oKjtj@gcfjfQfj"},
+		// the portrait is described with 3 components along its width and 4 along its height
+		{name: "rotated clockwise", orientation: 6, want: "TxH27kq8gc2sX6fjwxjtfQX8f%fj"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BlurHash(gradient, test.orientation); got != test.want {
+				t.Errorf("got hash %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrientedSize(t *testing.T) {
+	for orientation := 0; orientation <= 9; orientation++ {
+		width, height := OrientedSize(400, 200, orientation)
+
+		wantWidth, wantHeight := 400, 200
+		if orientation >= 5 && orientation <= 8 {
+			wantWidth, wantHeight = 200, 400
+		}
+		if width != wantWidth || height != wantHeight {
+			t.Errorf("orientation %d: got size %dx%d, want %dx%d", orientation, width, height, wantWidth, wantHeight)
+		}
+
+		bounds := Orient(image.NewRGBA(image.Rect(0, 0, 400, 200)), orientation).Bounds()
+		if bounds.Dx() != wantWidth || bounds.Dy() != wantHeight {
+			t.Errorf("orientation %d: got oriented image of %v, want %dx%d", orientation, bounds, wantWidth, wantHeight)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// jpeg markers used to find metadata segments
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerEOI  = 0xD9
+	markerAPP1 = 0xE1
+	markerAPPD = 0xED
+	markerCOM  = 0xFE
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// segment is a jpeg marker segment, Data excludes the marker and length
+type segment struct {
+	Marker byte
+	Data   []byte
+}
+
+// chunk is a png chunk
+type chunk struct {
+	Type string
+	Data []byte
+}
+
+// readJPEGSegments reads the marker segments located before the image data
+func readJPEGSegments(r io.Reader) ([]segment, error) {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, fmt.Errorf("missing jpeg start of image")
+	}
+
+	var segments []segment
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return nil, err
+		}
+
+		if marker == markerSOS || marker == markerEOI {
+			return segments, nil
+		}
+
+		// standalone markers have no length
+		if marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			continue
+		}
+
+		var length uint16
+		if err = binary.Read(br, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("could not read segment length: %v", err)
+		}
+		if length < 2 {
+			return nil, fmt.Errorf("invalid segment length")
+		}
+
+		data := make([]byte, length-2)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("truncated jpeg segment: %v", err)
+		}
+
+		segments = append(segments, segment{Marker: marker, Data: data})
+	}
+}
+
+// readMarker reads the next marker, skipping fill bytes
+func readMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("could not read jpeg marker: %v", err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("invalid jpeg marker")
+	}
+
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, fmt.Errorf("could not read jpeg marker: %v", err)
+		}
+	}
+
+	return b, nil
+}
+
+// readPNGChunks reads the chunks located before the image data
+func readPNGChunks(r io.Reader) ([]chunk, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, fmt.Errorf("missing png signature")
+	}
+
+	var chunks []chunk
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("truncated png chunk: %v", err)
+		}
+
+		length := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:])
+
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			return chunks, nil
+		}
+
+		if length > 1<<24 {
+			return nil, fmt.Errorf("png chunk %s is too large", chunkType)
+		}
+
+		// data and crc
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("truncated png chunk: %v", err)
+		}
+
+		chunks = append(chunks, chunk{Type: chunkType, Data: data[:length]})
+	}
+}
+
+// maxMetadataChunk is the largest metadata chunk read from a webp file
+const maxMetadataChunk = 1 << 24
+
+// riffChunk is a chunk of a webp file, located by the offset of its data
+type riffChunk struct {
+	Type   string
+	Offset int64
+	Length uint32
+}
+
+// seekReaderAt reads a ReadSeeker at given offsets, reads cannot run concurrently
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(s.r, p)
+}
+
+// readRIFFChunks lists the chunks of a webp file without reading their data,
+// metadata chunks are stored after the image data
+func readRIFFChunks(r io.ReaderAt) ([]riffChunk, error) {
+	var header [12]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return nil, fmt.Errorf("missing webp header")
+	}
+
+	// the size counts the WEBP form type, a smaller size would underflow
+	size := binary.LittleEndian.Uint32(header[4:8])
+	if size < 4 {
+		return nil, fmt.Errorf("invalid webp file size")
+	}
+	end := 8 + int64(size)
+
+	var chunks []riffChunk
+	for offset := int64(len(header)); offset+8 <= end; {
+		var chunkHeader [8]byte
+		if _, err := r.ReadAt(chunkHeader[:], offset); err != nil {
+			return nil, fmt.Errorf("truncated webp chunk: %v", err)
+		}
+
+		c := riffChunk{
+			Type:   string(chunkHeader[:4]),
+			Offset: offset + 8,
+			Length: binary.LittleEndian.Uint32(chunkHeader[4:]),
+		}
+		if c.Offset+int64(c.Length) > end {
+			return nil, fmt.Errorf("truncated webp chunk")
+		}
+		chunks = append(chunks, c)
+
+		// chunks are padded to an even size
+		offset = c.Offset + int64(c.Length) + int64(c.Length&1)
+	}
+
+	return chunks, nil
+}
+
+// readRIFFChunkData reads the data of a webp chunk, at most max bytes
+func readRIFFChunkData(r io.ReaderAt, c riffChunk, max uint32) ([]byte, error) {
+	if c.Length > max {
+		return nil, fmt.Errorf("webp chunk %s is too large", c.Type)
+	}
+
+	data := make([]byte, c.Length)
+	if _, err := r.ReadAt(data, c.Offset); err != nil {
+		return nil, fmt.Errorf("truncated webp chunk: %v", err)
+	}
+
+	return data, nil
+}
+
+// tiff limits of the metadata copied out of a tiff file
+const (
+	maxTIFFEntries = 1000
+	maxTIFFValue   = 1 << 16
+	maxTIFFExif    = 1 << 20
+)
+
+// tiffTypeSizes are the sizes of the tiff field types, by type
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// tiffSubIFDs are the tags pointing to the IFDs holding the exif, gps and interoperability fields
+var tiffSubIFDs = map[uint16]bool{0x8769: true, 0x8825: true, 0xA005: true}
+
+// tiffCopier copies the first IFD of a tiff file with its sub IFDs into a
+// compact tiff block, the image data is left out
+type tiffCopier struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	out   []byte
+}
+
+// readTIFFExif returns the exif block of a tiff file: its first IFD, its
+// exif, gps and interoperability IFDs and their values. Only those are read,
+// IFDs are often stored after the image data
+func readTIFFExif(r io.ReaderAt) ([]byte, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, fmt.Errorf("missing tiff header: %v", err)
+	}
+
+	c := &tiffCopier{r: r}
+	switch string(header[:4]) {
+	case "II*\x00":
+		c.order = binary.LittleEndian
+	case "MM\x00*":
+		c.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("missing tiff header")
+	}
+
+	// the first IFD follows the header in the copy
+	c.out = append(c.out, header[:]...)
+	c.order.PutUint32(c.out[4:], 8)
+	if _, err := c.copyIFD(c.order.Uint32(header[4:]), 0); err != nil {
+		return nil, err
+	}
+
+	return c.out, nil
+}
+
+// copyIFD copies the IFD at offset and returns its offset in the copy, the
+// next IFD is not copied
+func (c *tiffCopier) copyIFD(offset uint32, depth int) (uint32, error) {
+	var count [2]byte
+	if _, err := c.r.ReadAt(count[:], int64(offset)); err != nil {
+		return 0, fmt.Errorf("truncated tiff IFD: %v", err)
+	}
+	n := int(c.order.Uint16(count[:]))
+	if n > maxTIFFEntries {
+		return 0, fmt.Errorf("tiff IFD has too many entries")
+	}
+
+	entries := make([]byte, 12*n)
+	if _, err := c.r.ReadAt(entries, int64(offset)+2); err != nil {
+		return 0, fmt.Errorf("truncated tiff IFD: %v", err)
+	}
+	if len(c.out)+2+len(entries)+4 > maxTIFFExif {
+		return 0, fmt.Errorf("tiff metadata are too large")
+	}
+
+	// the IFD is written first, the values it points to after it
+	start := len(c.out)
+	c.out = append(c.out, make([]byte, 2+len(entries)+4)...)
+
+	kept := 0
+	for i := 0; i < n; i++ {
+		entry := entries[12*i : 12*i+12]
+		tag, fieldType := c.order.Uint16(entry), c.order.Uint16(entry[2:])
+		size, ok := tiffTypeSizes[fieldType]
+		if !ok {
+			continue
+		}
+		length := uint64(size) * uint64(c.order.Uint32(entry[4:]))
+
+		switch {
+		case tiffSubIFDs[tag] && depth < 2:
+			sub, err := c.copyIFD(c.order.Uint32(entry[8:]), depth+1)
+			if err != nil {
+				return 0, err
+			}
+			c.order.PutUint32(entry[8:], sub)
+		case length > 4:
+			if length > maxTIFFValue {
+				continue
+			}
+			value, err := c.copyValue(c.order.Uint32(entry[8:]), uint32(length))
+			if err != nil {
+				return 0, err
+			}
+			c.order.PutUint32(entry[8:], value)
+		}
+
+		copy(c.out[start+2+12*kept:], entry)
+		kept++
+	}
+	c.order.PutUint16(c.out[start:], uint16(kept))
+
+	return uint32(start), nil
+}
+
+// copyValue copies a value stored outside of its IFD entry and returns its offset in the copy
+func (c *tiffCopier) copyValue(offset uint32, length uint32) (uint32, error) {
+	if len(c.out)+int(length) > maxTIFFExif {
+		return 0, fmt.Errorf("tiff metadata are too large")
+	}
+
+	value := make([]byte, length)
+	if _, err := c.r.ReadAt(value, int64(offset)); err != nil {
+		return 0, fmt.Errorf("truncated tiff value: %v", err)
+	}
+
+	// values start on a word boundary
+	if len(c.out)%2 == 1 {
+		c.out = append(c.out, 0)
+	}
+	start := len(c.out)
+	c.out = append(c.out, value...)
+
+	return uint32(start), nil
+}
+
+// countGIFFrames walks the blocks of a gif without decoding its image data
+// and returns its number of frames, counting stops once it exceeds max when
+// max is positive
+func countGIFFrames(r io.Reader, max int) (int, error) {
+	br := bufio.NewReader(r)
+
+	// header and logical screen descriptor
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err := skipColorTable(br, header[10]); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	for {
+		introducer, err := br.ReadByte()
+		if err != nil {
+			return frames, io.ErrUnexpectedEOF
+		}
+
+		switch introducer {
+		case 0x21: // extension
+			if _, err = br.ReadByte(); err != nil {
+				return frames, io.ErrUnexpectedEOF
+			}
+		case 0x2C: // image descriptor
+			descriptor := make([]byte, 9)
+			if _, err = io.ReadFull(br, descriptor); err != nil {
+				return frames, io.ErrUnexpectedEOF
+			}
+			if err = skipColorTable(br, descriptor[8]); err != nil {
+				return frames, err
+			}
+			// lzw minimum code size
+			if _, err = br.ReadByte(); err != nil {
+				return frames, io.ErrUnexpectedEOF
+			}
+
+			frames++
+			if max > 0 && frames > max {
+				return frames, nil
+			}
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return frames, fmt.Errorf("invalid gif block 0x%02x", introducer)
+		}
+
+		if err = skipGIFSubBlocks(br); err != nil {
+			return frames, err
+		}
+	}
+}
+
+// skipColorTable skips the color table announced by the packed fields of a gif descriptor
+func skipColorTable(br *bufio.Reader, packed byte) error {
+	if packed&0x80 == 0 {
+		return nil
+	}
+
+	size := 3 * (1 << ((packed & 0x07) + 1))
+	if n, _ := br.Discard(size); n != size {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// skipGIFSubBlocks skips data sub-blocks up to the terminating empty block
+func skipGIFSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if size == 0 {
+			return nil
+		}
+		if n, _ := br.Discard(int(size)); n != int(size) {
+			return io.ErrUnexpectedEOF
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"strings"
+
+	// register decoders used by image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "github.com/gen2brain/avif"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Format is an image format supported by the gallery
+type Format struct {
+	// Name is the format name returned by image.Decode
+	Name     string
+	MimeType string
+	// Extensions of the format, the first one is used for stored files
+	Extensions []string
+	// Encodable is false for formats derivatives are not encoded to, because
+	// they cannot be encoded or are not displayed by browsers
+	Encodable bool
+}
+
+// Extension returns the extension used for stored files
+func (f Format) Extension() string {
+	return f.Extensions[0]
+}
+
+// Formats are all supported formats
+var Formats = []Format{
+	{Name: "jpeg", MimeType: "image/jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Encodable: true},
+	{Name: "png", MimeType: "image/png", Extensions: []string{".png"}, Encodable: true},
+	{Name: "gif", MimeType: "image/gif", Extensions: []string{".gif"}, Encodable: true},
+	{Name: "webp", MimeType: "image/webp", Extensions: []string{".webp"}},
+	{Name: "avif", MimeType: "image/avif", Extensions: []string{".avif"}, Encodable: true},
+	{Name: "tiff", MimeType: "image/tiff", Extensions: []string{".tif", ".tiff"}},
+}
+
+// FormatByName returns the format matching an image.Decode format name
+func FormatByName(name string) (Format, bool) {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return Format{}, false
+}
+
+// FormatByMimeType returns the format matching a mime type
+func FormatByMimeType(mimeType string) (Format, bool) {
+	for _, f := range Formats {
+		if f.MimeType == mimeType {
+			return f, true
+		}
+	}
+
+	return Format{}, false
+}
+
+// FormatByExtension returns the format matching a file extension
+func FormatByExtension(ext string) (Format, bool) {
+	ext = strings.ToLower(ext)
+	for _, f := range Formats {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f, true
+			}
+		}
+	}
+
+	return Format{}, false
+}
+
+// DetectMimeType returns the mime type of a file from its first bytes, it
+// extends http.DetectContentType which does not know avif and tiff
+func DetectMimeType(head []byte) string {
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		switch string(head[8:12]) {
+		case "avif", "avis":
+			return "image/avif"
+		}
+	}
+
+	return http.DetectContentType(head)
+}
+
+// OutputFormat returns the format used to encode derivatives of an image in
+// format f, formats which cannot be encoded fall back to png for images with
+// transparency and to jpeg otherwise
+func OutputFormat(f Format, img image.Image) Format {
+	if f.Encodable {
+		return f
+	}
+
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		png, _ := FormatByName("png")
+		return png
+	}
+
+	jpeg, _ := FormatByName("jpeg")
+	return jpeg
+}
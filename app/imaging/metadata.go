@@ -0,0 +1,475 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Metadata are the informations extracted from EXIF, XMP and IPTC blocks of a file
+type Metadata struct {
+	CameraMake   string     `json:"camera_make,omitempty"`
+	CameraModel  string     `json:"camera_model,omitempty"`
+	Lens         string     `json:"lens,omitempty"`
+	ExposureTime string     `json:"exposure_time,omitempty"`
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focal_length,omitempty"`
+	CapturedAt   *time.Time `json:"captured_at,omitempty"`
+	Orientation  int        `json:"orientation,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Copyright    string     `json:"copyright,omitempty"`
+	Private      bool       `json:"private,omitempty"`
+}
+
+// Redacted returns a copy of the metadata without the informations which can
+// identify the photographer (location, camera, date...)
+func (m *Metadata) Redacted() *Metadata {
+	return &Metadata{
+		Orientation: m.Orientation,
+		Width:       m.Width,
+		Height:      m.Height,
+		Private:     m.Private,
+	}
+}
+
+// metadataBlocks are the raw metadata blocks found in a file
+type metadataBlocks struct {
+	exif []byte
+	xmp  []byte
+	iptc []byte
+}
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+// ExtractMetadata reads the metadata of an image file, values found in EXIF
+// take precedence over XMP which takes precedence over IPTC, only dimensions
+// are read from gif and avif files
+func ExtractMetadata(r io.ReadSeeker) (*Metadata, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image config: %v", err)
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	blocks, err := readMetadataBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &Metadata{
+		Width:  config.Width,
+		Height: config.Height,
+	}
+
+	if blocks.exif != nil {
+		// a broken exif block should not prevent the upload
+		_ = metadata.readExif(blocks.exif)
+	}
+	if blocks.xmp != nil {
+		_ = metadata.readXMP(blocks.xmp)
+	}
+	if blocks.iptc != nil {
+		metadata.readIPTC(blocks.iptc)
+	}
+
+	return metadata, nil
+}
+
+// readMetadataBlocks finds the exif, xmp and iptc blocks depending on the file format
+func readMetadataBlocks(r io.ReadSeeker) (*metadataBlocks, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("could not read file: %v", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	blocks := &metadataBlocks{}
+
+	switch {
+	case magic[0] == 0xFF && magic[1] == markerSOI:
+		segments, err := readJPEGSegments(r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range segments {
+			switch {
+			case s.Marker == markerAPP1 && bytes.HasPrefix(s.Data, exifHeader):
+				blocks.exif = s.Data
+			case s.Marker == markerAPP1 && bytes.HasPrefix(s.Data, xmpHeader):
+				blocks.xmp = s.Data[len(xmpHeader):]
+			case s.Marker == markerAPPD && bytes.HasPrefix(s.Data, photoshopHeader):
+				blocks.iptc = photoshopIPTC(s.Data[len(photoshopHeader):])
+			}
+		}
+	case magic[0] == pngSignature[0] && magic[1] == pngSignature[1]:
+		chunks, err := readPNGChunks(r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range chunks {
+			switch {
+			case c.Type == "eXIf":
+				blocks.exif = c.Data
+			case c.Type == "iTXt" && bytes.HasPrefix(c.Data, []byte("XML:com.adobe.xmp\x00")):
+				blocks.xmp = uncompressedITXt(c.Data)
+			}
+		}
+	case magic[0] == 'I' && magic[1] == 'I' || magic[0] == 'M' && magic[1] == 'M':
+		// exif is stored as a tiff file, so the metadata IFDs of a tiff file are its exif block
+		// as a broken exif block, broken metadata IFDs should not prevent the upload
+		if exif, err := readTIFFExif(seekReaderAt{r}); err == nil {
+			blocks.exif = exif
+		}
+	case magic[0] == 'R' && magic[1] == 'I':
+		chunks, err := readRIFFChunks(seekReaderAt{r})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range chunks {
+			if c.Type != "EXIF" && c.Type != "XMP " {
+				continue
+			}
+			data, err := readRIFFChunkData(seekReaderAt{r}, c, maxMetadataChunk)
+			if err != nil {
+				return nil, err
+			}
+			if c.Type == "EXIF" {
+				blocks.exif = data
+			} else {
+				blocks.xmp = data
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+// uncompressedITXt returns the text of an iTXt chunk, compressed texts are ignored
+func uncompressedITXt(data []byte) []byte {
+	// keyword, compression flag, compression method, language tag, translated keyword
+	parts := bytes.SplitN(data, []byte{0}, 2)
+	if len(parts) != 2 || len(parts[1]) < 2 || parts[1][0] != 0 {
+		return nil
+	}
+
+	rest := bytes.SplitN(parts[1][2:], []byte{0}, 3)
+	if len(rest) != 3 {
+		return nil
+	}
+
+	return rest[2]
+}
+
+func (m *Metadata) readExif(data []byte) error {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	texts := map[exif.FieldName]*string{
+		exif.Make:      &m.CameraMake,
+		exif.Model:     &m.CameraModel,
+		exif.LensModel: &m.Lens,
+		exif.Copyright: &m.Copyright,
+	}
+	for field, dst := range texts {
+		if tag, err := x.Get(field); err == nil {
+			if value, err := tag.StringVal(); err == nil {
+				*dst = cleanString(value)
+			}
+		}
+	}
+
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil {
+			m.ExposureTime = formatExposure(num, den)
+		}
+	}
+
+	rationals := map[exif.FieldName]*float64{
+		exif.FNumber:     &m.FNumber,
+		exif.FocalLength: &m.FocalLength,
+	}
+	for field, dst := range rationals {
+		if tag, err := x.Get(field); err == nil {
+			if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+				*dst = math.Round(float64(num)/float64(den)*100) / 100
+			}
+		}
+	}
+
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		m.ISO, _ = tag.Int(0)
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		m.Orientation, _ = tag.Int(0)
+	}
+
+	if capturedAt, err := x.DateTime(); err == nil {
+		m.CapturedAt = &capturedAt
+	}
+
+	if lat, long, err := x.LatLong(); err == nil && !math.IsNaN(lat) && !math.IsNaN(long) {
+		m.Latitude = &lat
+		m.Longitude = &long
+	}
+
+	return nil
+}
+
+// formatExposure formats an exposure time the way cameras display it (1/250, 2.5)
+func formatExposure(num, den int64) string {
+	if num <= 0 || den <= 0 {
+		return ""
+	}
+
+	if num < den {
+		return "1/" + strconv.FormatInt(int64(math.Round(float64(den)/float64(num))), 10)
+	}
+
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
+
+// xmpProperties maps the xmp properties to the fields they fill
+var xmpProperties = map[string]string{
+	"Make":             "make",
+	"Model":            "model",
+	"Lens":             "lens",
+	"LensModel":        "lens",
+	"rights":           "copyright",
+	"DateTimeOriginal": "captured_at",
+	"DateCreated":      "captured_at",
+	"GPSLatitude":      "latitude",
+	"GPSLongitude":     "longitude",
+}
+
+func (m *Metadata) readXMP(data []byte) error {
+	values := make(map[string]string)
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			for _, attr := range t.Attr {
+				if field, ok := xmpProperties[attr.Name.Local]; ok && values[field] == "" {
+					values[field] = attr.Value
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			// rdf containers (Alt, Seq, li...) hold the value of their parent property
+			for i := len(stack) - 1; i >= 0; i-- {
+				field, ok := xmpProperties[stack[i]]
+				if ok {
+					if text := strings.TrimSpace(string(t)); text != "" && values[field] == "" {
+						values[field] = text
+					}
+					break
+				}
+				if !isRDFContainer(stack[i]) {
+					break
+				}
+			}
+		}
+	}
+
+	setIfEmpty(&m.CameraMake, values["make"])
+	setIfEmpty(&m.CameraModel, values["model"])
+	setIfEmpty(&m.Lens, values["lens"])
+	setIfEmpty(&m.Copyright, values["copyright"])
+
+	if m.CapturedAt == nil {
+		m.CapturedAt = parseXMPDate(values["captured_at"])
+	}
+
+	if m.Latitude == nil && m.Longitude == nil {
+		lat, latErr := parseXMPCoordinate(values["latitude"])
+		long, longErr := parseXMPCoordinate(values["longitude"])
+		if latErr == nil && longErr == nil {
+			m.Latitude = &lat
+			m.Longitude = &long
+		}
+	}
+
+	return nil
+}
+
+func isRDFContainer(name string) bool {
+	switch name {
+	case "Alt", "Bag", "Seq", "li":
+		return true
+	default:
+		return false
+	}
+}
+
+func parseXMPDate(value string) *time.Time {
+	layouts := []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04Z07:00", "2006-01-02T15:04",
+		"2006-01-02"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+// parseXMPCoordinate parses a xmp gps coordinate formatted as DDD,MM.mmk or DDD,MM,SSk
+func parseXMPCoordinate(value string) (float64, error) {
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid coordinate")
+	}
+
+	ref := value[len(value)-1]
+	parts := strings.Split(value[:len(value)-1], ",")
+
+	var coordinate float64
+	for i, part := range parts {
+		if i > 2 {
+			return 0, fmt.Errorf("invalid coordinate")
+		}
+
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		coordinate += v / math.Pow(60, float64(i))
+	}
+
+	switch ref {
+	case 'S', 'W':
+		return -coordinate, nil
+	case 'N', 'E':
+		return coordinate, nil
+	default:
+		return 0, fmt.Errorf("invalid coordinate reference")
+	}
+}
+
+// photoshopIPTC returns the IPTC-NAA resource of a photoshop image resource block
+func photoshopIPTC(data []byte) []byte {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(data[4:6])
+
+		// pascal string name padded to an even length
+		nameLength := int(data[6]) + 1
+		if nameLength%2 != 0 {
+			nameLength++
+		}
+
+		offset := 6 + nameLength
+		if len(data) < offset+4 {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if size < 0 || len(data) < offset+size {
+			return nil
+		}
+
+		if id == 0x0404 {
+			return data[offset : offset+size]
+		}
+
+		if size%2 != 0 {
+			size++
+		}
+		if len(data) < offset+size {
+			return nil
+		}
+		data = data[offset+size:]
+	}
+
+	return nil
+}
+
+func (m *Metadata) readIPTC(data []byte) {
+	var date, hour string
+
+	for len(data) >= 5 && data[0] == 0x1C {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:5]))
+
+		// extended datasets are not used by the fields we read
+		if size&0x8000 != 0 || len(data) < 5+size {
+			return
+		}
+		value := string(data[5 : 5+size])
+		data = data[5+size:]
+
+		if record != 2 {
+			continue
+		}
+
+		switch dataset {
+		case 116:
+			setIfEmpty(&m.Copyright, cleanString(value))
+		case 55:
+			date = value
+		case 60:
+			hour = value
+		}
+	}
+
+	if m.CapturedAt == nil && len(date) == 8 {
+		clock := "000000"
+		if len(hour) >= 6 {
+			clock = hour[:6]
+		}
+
+		if t, err := time.Parse("20060102150405", date+clock); err == nil {
+			m.CapturedAt = &t
+		}
+	}
+}
+
+func setIfEmpty(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
+
+func cleanString(value string) string {
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
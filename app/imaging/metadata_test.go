@@ -0,0 +1,165 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTestFile returns the content of a file of the testdata directory
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+// cameraMetadata are the metadata of the EXIF block of the test files
+var cameraMetadata = Metadata{
+	CameraMake:   "Canon",
+	CameraModel:  "Canon EOS 5D",
+	Lens:         "EF50mm f/1.8",
+	ExposureTime: "1/250",
+	FNumber:      2.8,
+	ISO:          400,
+	FocalLength:  50,
+	Orientation:  6,
+	Copyright:    "Jane Doe",
+}
+
+func TestExtractMetadata(t *testing.T) {
+	withSize := func(m Metadata, width int, height int) Metadata {
+		m.Width, m.Height = width, height
+		return m
+	}
+
+	tests := []struct {
+		file string
+		// cut is the number of bytes removed from the end of the file
+		cut  int
+		want Metadata
+		// capturedAt and location are compared formatted
+		capturedAt string
+		location   string
+	}{
+		{
+			file:       "exif.jpg",
+			want:       withSize(cameraMetadata, 16, 8),
+			capturedAt: "2020-04-28 19:25:49",
+			location:   "48.8582,2.2944",
+		},
+		{
+			file:       "exif.webp",
+			want:       withSize(cameraMetadata, 1, 1),
+			capturedAt: "2020-04-28 19:25:49",
+			location:   "48.8582,2.2944",
+		},
+		{
+			file:       "exif.tif",
+			want:       withSize(cameraMetadata, 4, 2),
+			capturedAt: "2020-04-28 19:25:49",
+			location:   "48.8582,2.2944",
+		},
+		{
+			// the png only has the orientation in EXIF, other values are read from XMP
+			file: "xmp.png",
+			want: Metadata{CameraMake: "Nikon", CameraModel: "D750", Lens: "50mm f/1.4", Orientation: 3,
+				Copyright: "John Roe", Width: 16, Height: 8},
+			capturedAt: "2021-06-01 10:30:00",
+			location:   "45.5083,-73.5700",
+		},
+		{
+			// the values of the gps IFD are cut, the image data is complete
+			file: "exif.tif",
+			cut:  51,
+			want: Metadata{Width: 4, Height: 2},
+		},
+		{file: "comment.gif", want: Metadata{Width: 4, Height: 4}},
+		{file: "photo.avif", want: Metadata{Width: 8, Height: 8}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			file := readTestFile(t, test.file)
+			metadata, err := ExtractMetadata(bytes.NewReader(file[:len(file)-test.cut]))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := *metadata
+			var capturedAt, location string
+			if got.CapturedAt != nil {
+				capturedAt = got.CapturedAt.Format("2006-01-02 15:04:05")
+			}
+			if got.Latitude != nil && got.Longitude != nil {
+				location = fmt.Sprintf("%.4f,%.4f", *got.Latitude, *got.Longitude)
+			}
+			got.CapturedAt, got.Latitude, got.Longitude = nil, nil, nil
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got metadata %+v, want %+v", got, test.want)
+			}
+			if capturedAt != test.capturedAt {
+				t.Errorf("got capture date %q, want %q", capturedAt, test.capturedAt)
+			}
+			if location != test.location {
+				t.Errorf("got location %q, want %q", location, test.location)
+			}
+		})
+	}
+}
+
+func TestReadRIFFChunks(t *testing.T) {
+	webp := readTestFile(t, "exif.webp")
+
+	// withSize returns the webp file with another RIFF size
+	withSize := func(size byte) []byte {
+		file := append([]byte{}, webp...)
+		copy(file[4:8], []byte{size, 0, 0, 0})
+		return file
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+		want []string
+		err  string
+	}{
+		{name: "extended file", file: webp, want: []string{"VP8X", "ALPH", "VP8 ", "EXIF", "XMP "}},
+		{name: "size below form type", file: withSize(3), err: "invalid webp file size"},
+		{name: "chunk beyond size", file: withSize(40), err: "truncated webp chunk"},
+		{name: "truncated chunk header", file: webp[:bytes.Index(webp, []byte("XMP "))+2], err: "truncated webp chunk"},
+		{name: "not a webp file", file: readTestFile(t, "xmp.png"), err: "missing webp header"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks, err := readRIFFChunks(bytes.NewReader(test.file))
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var types []string
+			for _, c := range chunks {
+				types = append(types, c.Type)
+			}
+			if !reflect.DeepEqual(types, test.want) {
+				t.Errorf("got chunks %q, want %q", types, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// OrientedSize returns the size of an image displayed with its EXIF
+// orientation, orientations 5 to 8 swap width and height
+func OrientedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+
+	return width, height
+}
+
+// Orient returns img as displayed with its EXIF orientation
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dstWidth, dstHeight := OrientedSize(width, height, orientation)
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			i, j := dst.PixOffset(dx, dy), src.PixOffset(x, y)
+			copy(dst.Pix[i:i+4], src.Pix[j:j+4])
+		}
+	}
+
+	return dst
+}
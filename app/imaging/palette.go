@@ -0,0 +1,213 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PaletteSize is the maximum number of colors extracted from an image
+const PaletteSize = 5
+
+// paletteSampleSize is the largest side of the copy colors are extracted from
+const paletteSampleSize = 100
+
+// paletteMergeDistance is the L*a*b* distance under which extracted colors are merged
+const paletteMergeDistance = 10
+
+// PaletteColor is a dominant color of an image
+type PaletteColor struct {
+	Color color.RGBA `json:"-"`
+	Hex   string     `json:"color"`
+	// Weight is the part of the image covered by the color, from 0 to 1
+	Weight float64 `json:"weight"`
+}
+
+// NewPaletteColor returns the palette color c covering weight of an image
+func NewPaletteColor(c color.RGBA, weight float64) PaletteColor {
+	return PaletteColor{
+		Color:  c,
+		Hex:    fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+		Weight: weight,
+	}
+}
+
+// colorBox is a set of pixels split by the median cut
+type colorBox []color.RGBA
+
+// widestChannel returns the channel with the largest range of values and its range
+func (box colorBox) widestChannel() (int, uint8) {
+	min := [3]uint8{255, 255, 255}
+	var max [3]uint8
+	for _, c := range box {
+		for i, v := range [3]uint8{c.R, c.G, c.B} {
+			if v < min[i] {
+				min[i] = v
+			}
+			if v > max[i] {
+				max[i] = v
+			}
+		}
+	}
+
+	channel := 0
+	for i := 1; i < 3; i++ {
+		if max[i]-min[i] > max[channel]-min[channel] {
+			channel = i
+		}
+	}
+
+	return channel, max[channel] - min[channel]
+}
+
+// average returns the mean color of the box
+func (box colorBox) average() color.RGBA {
+	var r, g, b int
+	for _, c := range box {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+	n := len(box)
+
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 255}
+}
+
+// Palette returns the dominant colors of img, most covering first. Colors are
+// found with a median cut on a downscaled copy, transparent pixels are ignored
+func Palette(img image.Image) []PaletteColor {
+	sample := Fit(img, paletteSampleSize)
+	bounds := sample.Bounds()
+
+	pixels := make(colorBox, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(sample.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			pixels = append(pixels, color.RGBA{R: c.R, G: c.G, B: c.B, A: 255})
+		}
+	}
+
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := []colorBox{pixels}
+	for len(boxes) < PaletteSize {
+		// split the box with the widest range of colors
+		widest, widestRange, widestChannel := -1, uint8(0), 0
+		for i, box := range boxes {
+			channel, channelRange := box.widestChannel()
+			if channelRange > widestRange {
+				widest, widestRange, widestChannel = i, channelRange, channel
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool {
+			return channelValue(box[i], widestChannel) < channelValue(box[j], widestChannel)
+		})
+
+		median := len(box) / 2
+		boxes[widest] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	// a uniform area may be split in shades hardly distinguishable, they are merged
+	palette := make([]PaletteColor, 0, len(boxes))
+	for _, box := range boxes {
+		c, weight := box.average(), float64(len(box))/float64(len(pixels))
+
+		merged := false
+		for i, existing := range palette {
+			if colorDistance(existing.Color, c) < paletteMergeDistance {
+				palette[i] = NewPaletteColor(mixColors(existing.Color, existing.Weight, c, weight),
+					existing.Weight+weight)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			palette = append(palette, NewPaletteColor(c, weight))
+		}
+	}
+
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i].Weight > palette[j].Weight
+	})
+
+	return palette
+}
+
+// colorDistance returns the distance between two colors in the L*a*b* space
+func colorDistance(c1, c2 color.RGBA) float64 {
+	l1, a1, b1 := Lab(c1)
+	l2, a2, b2 := Lab(c2)
+
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+}
+
+// mixColors returns the average of two colors weighted by their coverage
+func mixColors(c1 color.RGBA, w1 float64, c2 color.RGBA, w2 float64) color.RGBA {
+	mix := func(v1, v2 uint8) uint8 {
+		return uint8(math.Round((float64(v1)*w1 + float64(v2)*w2) / (w1 + w2)))
+	}
+
+	return color.RGBA{R: mix(c1.R, c2.R), G: mix(c1.G, c2.G), B: mix(c1.B, c2.B), A: 255}
+}
+
+func channelValue(c color.RGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	default:
+		return c.B
+	}
+}
+
+// ParseHexColor parses a #rrggbb or #rgb color, the # is optional
+func ParseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+
+	value, err := strconv.ParseUint(s, 16, 32)
+	if len(s) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("color must be formatted as #rrggbb")
+	}
+
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}, nil
+}
+
+// Lab converts c to the CIE L*a*b* color space, where the euclidean distance
+// between two colors is close to the perceived difference
+func Lab(c color.RGBA) (float64, float64, float64) {
+	r, g, b := srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)
+
+	// sRGB to XYZ, relative to the D65 white point
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
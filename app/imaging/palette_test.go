@@ -0,0 +1,73 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		value string
+		want  color.RGBA
+		err   bool
+	}{
+		{value: "#dc141e", want: color.RGBA{R: 0xdc, G: 0x14, B: 0x1e, A: 255}},
+		{value: "DC141E", want: color.RGBA{R: 0xdc, G: 0x14, B: 0x1e, A: 255}},
+		{value: "#f0a", want: color.RGBA{R: 0xff, G: 0x00, B: 0xaa, A: 255}},
+		{value: "fff", want: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 255}},
+		{value: "", err: true},
+		{value: "#ff00", err: true},
+		{value: "#ff00000", err: true},
+		{value: "#gg0000", err: true},
+		{value: "0x1234", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			c, err := ParseHexColor(test.value)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got color %v, want an error", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != test.want {
+				t.Errorf("got color %v, want %v", c, test.want)
+			}
+		})
+	}
+}
+
+func TestPalette(t *testing.T) {
+	// three quarters red and one quarter blue, the transparent row is ignored
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 41))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 10 {
+				img.Set(x, y, color.NRGBA{R: 0x15, G: 0x28, B: 0xc7, A: 255})
+				continue
+			}
+			img.Set(x, y, color.NRGBA{R: 0xdc, G: 0x14, B: 0x1e, A: 255})
+		}
+	}
+	for x := 0; x < 40; x++ {
+		img.Set(x, 40, color.NRGBA{G: 255, A: 10})
+	}
+
+	want := []PaletteColor{
+		NewPaletteColor(color.RGBA{R: 0xdc, G: 0x14, B: 0x1e, A: 255}, 0.75),
+		NewPaletteColor(color.RGBA{R: 0x15, G: 0x28, B: 0xc7, A: 255}, 0.25),
+	}
+	if got := Palette(img); !reflect.DeepEqual(got, want) {
+		t.Errorf("got palette %+v, want %+v", got, want)
+	}
+
+	if got := Palette(image.NewNRGBA(image.Rect(0, 0, 4, 4))); got != nil {
+		t.Errorf("got palette %+v for a transparent image, want none", got)
+	}
+}
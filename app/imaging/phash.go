@@ -0,0 +1,72 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// dctSize is the side of the grayscale copy transformed by the DCT
+const dctSize = 32
+
+// hashSize is the side of the low frequencies kept in the hash
+const hashSize = 8
+
+// dctCosines are the DCT-II cosines of the kept frequencies
+var dctCosines = func() [hashSize][dctSize]float64 {
+	var cosines [hashSize][dctSize]float64
+	for u := 0; u < hashSize; u++ {
+		for x := 0; x < dctSize; x++ {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * dctSize))
+		}
+	}
+	return cosines
+}()
+
+// PerceptualHash returns the pHash of img: the lowest frequencies of the DCT
+// of a 32x32 grayscale copy, one bit per frequency above their median.
+// Visually similar images have hashes with a small Hamming distance
+func PerceptualHash(img image.Image) uint64 {
+	small := Resize(img, dctSize, dctSize).(*image.RGBA)
+
+	var pixels [dctSize][dctSize]float64
+	for y := 0; y < dctSize; y++ {
+		for x := 0; x < dctSize; x++ {
+			i := small.PixOffset(x, y)
+			r, g, b := small.Pix[i], small.Pix[i+1], small.Pix[i+2]
+			pixels[y][x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+
+	var frequencies [hashSize * hashSize]float64
+	for v := 0; v < hashSize; v++ {
+		for u := 0; u < hashSize; u++ {
+			sum := 0.0
+			for y := 0; y < dctSize; y++ {
+				for x := 0; x < dctSize; x++ {
+					sum += pixels[y][x] * dctCosines[u][x] * dctCosines[v][y]
+				}
+			}
+			frequencies[v*hashSize+u] = sum
+		}
+	}
+
+	sorted := frequencies
+	sort.Float64s(sorted[:])
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, frequency := range frequencies {
+		if frequency > median {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return hash
+}
+
+// HammingDistance returns the number of bits which differ between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
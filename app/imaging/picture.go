@@ -0,0 +1,147 @@
+package imaging
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Picture is a decoded image file, every frame of animated gifs is kept
+type Picture struct {
+	Format Format
+	// Frames of the picture, still images have a single frame
+	Frames []image.Image
+	// Delays between frames in 100ths of a second
+	Delays    []int
+	LoopCount int
+}
+
+// Decode reads an image file, animated gifs are decoded frame by frame
+func Decode(r io.Reader) (*Picture, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(6)
+	if err == nil && (string(magic) == "GIF87a" || string(magic) == "GIF89a") {
+		g, err := gif.DecodeAll(br)
+		if err != nil {
+			return nil, err
+		}
+
+		format, _ := FormatByName("gif")
+		return &Picture{
+			Format:    format,
+			Frames:    compositeFrames(g),
+			Delays:    g.Delay,
+			LoopCount: g.LoopCount,
+		}, nil
+	}
+
+	img, name, err := image.Decode(br)
+	if err != nil {
+		return nil, err
+	}
+
+	format, ok := FormatByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported image format %s", name)
+	}
+
+	return &Picture{Format: format, Frames: []image.Image{img}}, nil
+}
+
+// Image returns the picture, or the first frame of an animation
+func (p *Picture) Image() image.Image {
+	return p.Frames[0]
+}
+
+// Animated is true when the picture has more than one frame
+func (p *Picture) Animated() bool {
+	return len(p.Frames) > 1
+}
+
+// Transform returns a copy of the picture with fn applied on every frame
+func (p *Picture) Transform(fn func(image.Image) image.Image) *Picture {
+	frames := make([]image.Image, len(p.Frames))
+	for i, frame := range p.Frames {
+		frames[i] = fn(frame)
+	}
+
+	return &Picture{
+		Format:    p.Format,
+		Frames:    frames,
+		Delays:    p.Delays,
+		LoopCount: p.LoopCount,
+	}
+}
+
+// Encode writes the picture to w in format, animations are only kept by gif
+func (p *Picture) Encode(w io.Writer, format Format) error {
+	if format.Name != "gif" || !p.Animated() {
+		return Encode(w, p.Image(), format.Name)
+	}
+
+	// web safe colors leave room for a transparent entry
+	colors := append(color.Palette{color.Transparent}, palette.WebSafe...)
+
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(p.Frames)),
+		Delay:     p.Delays,
+		LoopCount: p.LoopCount,
+	}
+	for i, frame := range p.Frames {
+		paletted := image.NewPaletted(frame.Bounds(), colors)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, frame.Bounds().Min)
+		g.Image[i] = paletted
+	}
+
+	return gif.EncodeAll(w, g)
+}
+
+// compositeFrames renders every frame of a gif on the full canvas, frames of
+// a gif are often partial updates of the previous ones
+func compositeFrames(g *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, 0, len(g.Image))
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, cloneRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return frames
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+
+	return dst
+}
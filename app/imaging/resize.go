@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/gen2brain/avif"
+	"golang.org/x/image/draw"
+)
+
+// JPEGQuality is the quality used when encoding jpeg derivatives
+const JPEGQuality = 85
+
+// AVIFQuality is the quality used when encoding avif derivatives
+const AVIFQuality = 60
+
+// Fit scales img down so that its largest side is at most size, smaller
+// images are returned untouched
+func Fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width >= height {
+		height = height * size / width
+		width = size
+	} else {
+		width = width * size / height
+		height = size
+	}
+
+	return Resize(img, width, height)
+}
+
+// Resize scales img to exactly width x height
+func Resize(img image.Image, width, height int) image.Image {
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	return dst
+}
+
+// Encode writes img to w using format as returned by image.Decode
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "avif":
+		return avif.Encode(w, img, avif.Options{Quality: AVIFQuality, Speed: avif.DefaultSpeed})
+	default:
+		return fmt.Errorf("cannot encode %s images", format)
+	}
+}
+
+// Cover scales img to fill width x height and crops the overflow around the center
+func Cover(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	// crop the source to the target aspect ratio before scaling
+	crop := bounds
+	if srcWidth*height > srcHeight*width {
+		cropWidth := srcHeight * width / height
+		crop.Min.X += (srcWidth - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := srcWidth * height / width
+		crop.Min.Y += (srcHeight - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+
+	return dst
+}
+
+// Contain scales img to fit inside width x height keeping its aspect ratio
+func Contain(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	if srcWidth*height > srcHeight*width {
+		return Resize(img, width, srcHeight*width/srcWidth)
+	}
+
+	return Resize(img, srcWidth*height/srcHeight, height)
+}
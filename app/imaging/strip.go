@@ -0,0 +1,456 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/tiff"
+)
+
+// markerAPP0 is the jfif segment, which must stay the first segment
+const markerAPP0 = 0xE0
+
+// strippedChunks are the png chunks removed when stripping metadata
+var strippedChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// webp VP8X flags telling metadata chunks are present
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+// StripMetadata copies an image file from r to w without its EXIF, XMP,
+// IPTC and comments blocks, orientation is kept when it is not the default one
+// so the picture is still displayed the right way. A ValidationError is
+// returned for avif files, which cannot be stripped without re-encoding them
+func StripMetadata(w io.Writer, r io.ReadSeeker, orientation int) error {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(16)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("could not read file: %v", err)
+	}
+	if len(magic) < 2 {
+		return fmt.Errorf("could not read file: %v", io.ErrUnexpectedEOF)
+	}
+
+	switch {
+	case magic[0] == 0xFF && magic[1] == markerSOI:
+		return stripJPEG(w, br, orientation)
+	case magic[0] == pngSignature[0] && magic[1] == pngSignature[1]:
+		return stripPNG(w, br, orientation)
+	case bytes.HasPrefix(magic, []byte("GIF8")):
+		return stripGIF(w, br)
+	case DetectMimeType(magic) == "image/webp":
+		if _, err = r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return stripWebP(w, r, orientation)
+	case DetectMimeType(magic) == "image/avif":
+		// metadata items are referenced by offsets of the container, re-encoding
+		// would lose quality so the file is refused
+		return &ValidationError{Unsupported: true, Message: "metadata of avif files cannot be stripped"}
+	case DetectMimeType(magic) == "image/tiff":
+		return stripTIFF(w, br)
+	default:
+		return fmt.Errorf("cannot strip metadata of this file format")
+	}
+}
+
+func stripJPEG(w io.Writer, br *bufio.Reader, orientation int) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(soi[:]); err != nil {
+		return err
+	}
+
+	orientationWritten := orientation <= 1
+	writeOrientation := func() error {
+		if orientationWritten {
+			return nil
+		}
+		orientationWritten = true
+
+		data := append(append([]byte{}, exifHeader...), orientationTIFF(orientation)...)
+		return writeJPEGSegment(w, markerAPP1, data)
+	}
+
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return err
+		}
+
+		// the image data is copied untouched
+		if marker == markerSOS || marker == markerEOI {
+			if err = writeOrientation(); err != nil {
+				return err
+			}
+			if _, err = w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			_, err = io.Copy(w, br)
+			return err
+		}
+
+		if marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			if _, err = w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var length uint16
+		if err = binary.Read(br, binary.BigEndian, &length); err != nil {
+			return fmt.Errorf("could not read segment length: %v", err)
+		}
+		if length < 2 {
+			return fmt.Errorf("invalid segment length")
+		}
+
+		data := make([]byte, length-2)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("truncated jpeg segment: %v", err)
+		}
+
+		if marker != markerAPP0 {
+			if err = writeOrientation(); err != nil {
+				return err
+			}
+		}
+
+		switch marker {
+		case markerAPP1, markerAPPD, markerCOM:
+			continue
+		}
+
+		if err = writeJPEGSegment(w, marker, data); err != nil {
+			return err
+		}
+	}
+}
+
+func writeJPEGSegment(w io.Writer, marker byte, data []byte) error {
+	if len(data)+2 > 0xFFFF {
+		return fmt.Errorf("jpeg segment is too large")
+	}
+
+	header := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)+2))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+
+	return err
+}
+
+func stripPNG(w io.Writer, br *bufio.Reader, orientation int) error {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return fmt.Errorf("missing png signature")
+	}
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return fmt.Errorf("truncated png chunk: %v", err)
+		}
+
+		length := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:])
+
+		// eXIf must be placed before the image data
+		if chunkType == "IDAT" && orientation > 1 {
+			if err := writePNGChunk(w, "eXIf", orientationTIFF(orientation)); err != nil {
+				return err
+			}
+			orientation = 0
+		}
+
+		body := io.LimitReader(br, int64(length)+4)
+		if strippedChunks[chunkType] {
+			if _, err := io.Copy(ioutil.Discard, body); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if n, err := io.Copy(w, body); err != nil || n != int64(length)+4 {
+			return fmt.Errorf("truncated png chunk: %v", err)
+		}
+
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	buf := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	copy(buf[4:], chunkType)
+	buf = append(buf, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(buf[4:]))
+	buf = append(buf, crc...)
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// stripGIF copies a gif without its comments and XMP extensions
+func stripGIF(w io.Writer, br *bufio.Reader) error {
+	// header and logical screen descriptor
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("truncated gif header: %v", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if err := copyColorTable(w, br, header[10]); err != nil {
+		return err
+	}
+
+	for {
+		introducer, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("truncated gif: %v", err)
+		}
+
+		switch introducer {
+		case 0x21: // extension
+			label, err := br.ReadByte()
+			if err != nil {
+				return fmt.Errorf("truncated gif extension: %v", err)
+			}
+
+			blocks, err := readGIFSubBlocks(br)
+			if err != nil {
+				return err
+			}
+
+			// comments and XMP application extensions
+			if label == 0xFE || label == 0xFF && bytes.HasPrefix(blocks, []byte("\x0bXMP DataXMP")) {
+				continue
+			}
+
+			if _, err = w.Write([]byte{introducer, label}); err != nil {
+				return err
+			}
+			if _, err = w.Write(blocks); err != nil {
+				return err
+			}
+		case 0x2C: // image descriptor
+			descriptor := make([]byte, 9)
+			if _, err = io.ReadFull(br, descriptor); err != nil {
+				return fmt.Errorf("truncated gif image descriptor: %v", err)
+			}
+			if _, err = w.Write(append([]byte{introducer}, descriptor...)); err != nil {
+				return err
+			}
+			if err = copyColorTable(w, br, descriptor[8]); err != nil {
+				return err
+			}
+
+			// lzw minimum code size followed by the image data
+			codeSize, err := br.ReadByte()
+			if err != nil {
+				return fmt.Errorf("truncated gif image data: %v", err)
+			}
+			blocks, err := readGIFSubBlocks(br)
+			if err != nil {
+				return err
+			}
+			if _, err = w.Write(append([]byte{codeSize}, blocks...)); err != nil {
+				return err
+			}
+		case 0x3B: // trailer
+			_, err = w.Write([]byte{introducer})
+			return err
+		default:
+			return fmt.Errorf("invalid gif block 0x%02x", introducer)
+		}
+	}
+}
+
+// copyColorTable copies the color table announced by the packed fields of a
+// gif descriptor
+func copyColorTable(w io.Writer, br *bufio.Reader, packed byte) error {
+	if packed&0x80 == 0 {
+		return nil
+	}
+
+	size := int64(3 * (1 << ((packed & 0x07) + 1)))
+	if n, err := io.CopyN(w, br, size); err != nil || n != size {
+		return fmt.Errorf("truncated gif color table: %v", err)
+	}
+
+	return nil
+}
+
+// readGIFSubBlocks reads data sub-blocks as they are stored, with their
+// sizes and the terminating empty block
+func readGIFSubBlocks(br *bufio.Reader) ([]byte, error) {
+	var blocks []byte
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("truncated gif data: %v", err)
+		}
+		blocks = append(blocks, size)
+
+		if size == 0 {
+			return blocks, nil
+		}
+
+		data := make([]byte, size)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("truncated gif data: %v", err)
+		}
+		blocks = append(blocks, data...)
+	}
+}
+
+// stripWebP rewrites a webp without its EXIF and XMP chunks. The RIFF header
+// holds the file size, the chunks are listed first then copied one at a time
+func stripWebP(w io.Writer, r io.ReadSeeker, orientation int) error {
+	src := seekReaderAt{r}
+	chunks, err := readRIFFChunks(src)
+	if err != nil {
+		return err
+	}
+
+	var vp8x, exif []byte
+	kept := make([]riffChunk, 0, len(chunks))
+	size := int64(4)
+	for _, c := range chunks {
+		switch c.Type {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if vp8x, err = readRIFFChunkData(src, c, 1<<10); err != nil {
+				return err
+			}
+			if len(vp8x) < 1 {
+				return fmt.Errorf("invalid webp VP8X chunk")
+			}
+			vp8x[0] &^= webpFlagEXIF | webpFlagXMP
+			if orientation > 1 {
+				vp8x[0] |= webpFlagEXIF
+			}
+		}
+
+		kept = append(kept, c)
+		size += 8 + int64(c.Length) + int64(c.Length&1)
+	}
+
+	// orientation can only be set on extended files, which have a VP8X chunk
+	if vp8x != nil && orientation > 1 {
+		exif = orientationTIFF(orientation)
+		size += 8 + int64(len(exif))
+	}
+
+	header := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(header[4:], uint32(size))
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+
+	for _, c := range kept {
+		if c.Type == "VP8X" {
+			if err = writeRIFFChunk(w, c.Type, vp8x); err != nil {
+				return err
+			}
+			continue
+		}
+
+		chunkHeader := make([]byte, 8)
+		copy(chunkHeader, c.Type)
+		binary.LittleEndian.PutUint32(chunkHeader[4:], c.Length)
+		if _, err = w.Write(chunkHeader); err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.NewSectionReader(src, c.Offset, int64(c.Length)))
+		if err != nil {
+			return fmt.Errorf("could not copy webp chunk %s: %v", c.Type, err)
+		}
+		if n != int64(c.Length) {
+			return fmt.Errorf("truncated webp chunk %s", c.Type)
+		}
+		if c.Length%2 == 1 {
+			if _, err = w.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if exif != nil {
+		return writeRIFFChunk(w, "EXIF", exif)
+	}
+
+	return nil
+}
+
+func writeRIFFChunk(w io.Writer, chunkType string, data []byte) error {
+	buf := make([]byte, 8, 9+len(data))
+	copy(buf, chunkType)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(data)))
+
+	buf = append(buf, data...)
+	if len(data)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// stripTIFF re-encodes a tiff, the encoder only writes the tags describing
+// the image data
+func stripTIFF(w io.Writer, br *bufio.Reader) error {
+	img, err := tiff.Decode(br)
+	if err != nil {
+		return fmt.Errorf("could not decode tiff: %v", err)
+	}
+
+	return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+}
+
+// orientationTIFF returns a minimal big endian TIFF block holding only the orientation tag
+func orientationTIFF(orientation int) []byte {
+	block := []byte{
+		'M', 'M', 0x00, 0x2A, // byte order and magic number
+		0x00, 0x00, 0x00, 0x08, // offset of the first IFD
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // orientation, SHORT, count 1
+		0x00, 0x00, 0x00, 0x00, // value
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	binary.BigEndian.PutUint16(block[18:20], uint16(orientation))
+
+	return block
+}
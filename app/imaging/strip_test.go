@@ -0,0 +1,93 @@
+package imaging
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		file string
+		want Metadata
+	}{
+		{file: "exif.jpg", want: Metadata{Orientation: 6, Width: 16, Height: 8}},
+		{file: "xmp.png", want: Metadata{Orientation: 3, Width: 16, Height: 8}},
+		{file: "exif.webp", want: Metadata{Orientation: 6, Width: 1, Height: 1}},
+		{file: "comment.gif", want: Metadata{Width: 4, Height: 4}},
+		// the tiff encoder only writes the tags of the image data, the orientation stays in the image record
+		{file: "exif.tif", want: Metadata{Width: 4, Height: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			file := readTestFile(t, test.file)
+			original, err := ExtractMetadata(bytes.NewReader(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stripped bytes.Buffer
+			if err = StripMetadata(&stripped, bytes.NewReader(file), original.Orientation); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = Validate(bytes.NewReader(stripped.Bytes()), Limits{}); err != nil {
+				t.Fatalf("stripped file is not valid: %v", err)
+			}
+
+			metadata, err := ExtractMetadata(bytes.NewReader(stripped.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*metadata, test.want) {
+				t.Errorf("got metadata %+v, want %+v", *metadata, test.want)
+			}
+
+			for _, text := range []string{"Jane Doe", "John Roe", "IPTC owner", "a comment", "xmp"} {
+				if bytes.Contains(stripped.Bytes(), []byte(text)) {
+					t.Errorf("stripped file still contains %q", text)
+				}
+			}
+		})
+	}
+}
+
+func TestStripMetadataRefusedFiles(t *testing.T) {
+	webp := readTestFile(t, "exif.webp")
+	invalidSize := append([]byte{}, webp...)
+	copy(invalidSize[4:8], []byte{3, 0, 0, 0})
+
+	tests := []struct {
+		name string
+		file []byte
+		err  string
+		// validation is true when the error is a ValidationError
+		validation bool
+	}{
+		{name: "avif", file: readTestFile(t, "photo.avif"), err: "metadata of avif files cannot be stripped",
+			validation: true},
+		{name: "webp size below form type", file: invalidSize, err: "invalid webp file size"},
+		{name: "truncated webp", file: webp[:bytes.Index(webp, []byte("VP8 "))+12], err: "truncated webp chunk"},
+		{name: "truncated png", file: readTestFile(t, "xmp.png")[:100], err: "truncated png chunk"},
+		{name: "text", file: []byte("not an image at all"), err: "cannot strip metadata of this file format"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := StripMetadata(&bytes.Buffer{}, bytes.NewReader(test.file), 6)
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+
+			validationErr, ok := err.(*ValidationError)
+			if ok != test.validation {
+				t.Fatalf("got validation error %t, want %t", ok, test.validation)
+			}
+			if ok && !validationErr.Unsupported {
+				t.Errorf("avif files should be unsupported")
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"strings"
+)
+
+// Limits of the images accepted by Validate
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels protects against decompression bombs, small files decoding to huge images
+	MaxPixels int64
+	// Formats are the names of accepted formats, all Formats are accepted when empty
+	Formats []string
+}
+
+// accepts returns true when f is in the accepted formats
+func (limits Limits) accepts(f Format) bool {
+	if len(limits.Formats) == 0 {
+		return true
+	}
+
+	for _, name := range limits.Formats {
+		if name == f.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidationError is returned when a file is not an acceptable image, its
+// message is meant to be sent to the client
+type ValidationError struct {
+	// Unsupported is true when the file is valid but its format is not accepted
+	Unsupported bool
+	Message     string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Info describes a validated image
+type Info struct {
+	Format Format
+	Width  int
+	Height int
+}
+
+// Validate sniffs the content of r and fully decodes it to make sure it is a
+// complete image of a supported format within limits
+func Validate(r io.ReadSeeker, limits Limits) (*Info, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, invalid("file is empty")
+		}
+		return nil, err
+	}
+
+	sniffed := DetectMimeType(head[:n])
+	format, ok := FormatByMimeType(sniffed)
+	if !ok || !limits.accepts(format) {
+		return nil, &ValidationError{
+			Unsupported: true,
+			Message:     fmt.Sprintf("file content is %s, accepted formats are %s", sniffed, limits.acceptedMimeTypes()),
+		}
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	config, name, err := image.DecodeConfig(r)
+	if err != nil {
+		if isTruncated(err) {
+			return nil, invalid("file is truncated")
+		}
+		return nil, invalid("file is not a valid %s image: %v", format.Name, err)
+	}
+	if name != format.Name {
+		return nil, invalid("file content is detected as %s but decodes as %s", format.Name, name)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, invalid("image has invalid dimensions %dx%d", config.Width, config.Height)
+	}
+	if limits.MaxWidth > 0 && config.Width > limits.MaxWidth {
+		return nil, invalid("image width %d exceeds the maximum of %d pixels", config.Width, limits.MaxWidth)
+	}
+	if limits.MaxHeight > 0 && config.Height > limits.MaxHeight {
+		return nil, invalid("image height %d exceeds the maximum of %d pixels", config.Height, limits.MaxHeight)
+	}
+	if pixels := int64(config.Width) * int64(config.Height); limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+		return nil, invalid("image has %d pixels which exceeds the maximum of %d", pixels, limits.MaxPixels)
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// a full decode catches truncated and corrupted image data
+	if format.Name == "gif" {
+		err = validateAnimation(r, config, limits)
+	} else {
+		_, _, err = image.Decode(r)
+	}
+	if err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			return nil, err
+		}
+		if isTruncated(err) {
+			return nil, invalid("file is truncated")
+		}
+		return nil, invalid("image data is corrupted: %v", err)
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return &Info{
+		Format: format,
+		Width:  config.Width,
+		Height: config.Height,
+	}, nil
+}
+
+// isTruncated returns true when a decoder error is caused by a file ending too
+// early, decoders return io.EOF or io.ErrUnexpectedEOF or report the missing data
+// their own way
+func isTruncated(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// the jpeg decoder reports missing entropy data as short huffman data, the tiff
+	// and gif decoders report missing pixels as not enough data
+	for _, message := range []string{"unexpected EOF", "short Huffman data", "not enough pixel data",
+		"not enough image data"} {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateAnimation decodes every frame of a gif, frames are composited on
+// the whole canvas when decoded so they all count in the pixels limit. Frames
+// are counted before being decoded, so that a small file holding many frames
+// is rejected before their pixels are allocated
+func validateAnimation(r io.ReadSeeker, config image.Config, limits Limits) error {
+	canvas := int64(config.Width) * int64(config.Height)
+
+	maxFrames := 0
+	if limits.MaxPixels > 0 {
+		maxFrames = int(limits.MaxPixels / canvas)
+	}
+	frames, err := countGIFFrames(r, maxFrames)
+	if err != nil {
+		return err
+	}
+	if pixels := canvas * int64(frames); limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+		return invalid("animation has more than %d frames, which exceeds the maximum of %d pixels",
+			maxFrames, limits.MaxPixels)
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = gif.DecodeAll(r)
+
+	return err
+}
+
+func (limits Limits) acceptedMimeTypes() string {
+	mimeTypes := make([]string, 0, len(Formats))
+	for _, f := range Formats {
+		if limits.accepts(f) {
+			mimeTypes = append(mimeTypes, f.MimeType)
+		}
+	}
+
+	return strings.Join(mimeTypes, ", ")
+}
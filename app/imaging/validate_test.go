@@ -0,0 +1,127 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+// animation returns an animated gif of 10x10 pixels whose frames cover the whole canvas
+func animation(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black, color.White})
+		frame.SetColorIndex(i%10, i/10%10, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 0)
+	}
+
+	var file bytes.Buffer
+	if err := gif.EncodeAll(&file, g); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Bytes()
+}
+
+func TestValidate(t *testing.T) {
+	// cut returns the first n bytes of a test file
+	cut := func(name string, n int) []byte {
+		return readTestFile(t, name)[:n]
+	}
+
+	tests := []struct {
+		name   string
+		file   []byte
+		limits Limits
+		// err is the message of the ValidationError, the file is valid when empty
+		err         string
+		unsupported bool
+		width       int
+		height      int
+	}{
+		{name: "jpeg", file: readTestFile(t, "exif.jpg"), width: 16, height: 8},
+		{name: "png", file: readTestFile(t, "xmp.png"), width: 16, height: 8},
+		{name: "gif", file: readTestFile(t, "comment.gif"), width: 4, height: 4},
+		{name: "webp", file: readTestFile(t, "exif.webp"), width: 1, height: 1},
+		{name: "tiff", file: readTestFile(t, "exif.tif"), width: 4, height: 2},
+		{name: "avif", file: readTestFile(t, "photo.avif"), width: 8, height: 8},
+		{name: "empty file", file: []byte{}, err: "file is empty"},
+		{
+			name:        "text",
+			file:        []byte("not an image at all"),
+			err:         "file content is text/plain; charset=utf-8, accepted formats are image/png",
+			unsupported: true,
+			limits:      Limits{Formats: []string{"png"}},
+		},
+		{
+			name:        "format not accepted",
+			file:        readTestFile(t, "exif.jpg"),
+			err:         "file content is image/jpeg, accepted formats are image/png, image/gif",
+			unsupported: true,
+			limits:      Limits{Formats: []string{"png", "gif"}},
+		},
+		{
+			name:   "too wide",
+			file:   readTestFile(t, "exif.jpg"),
+			err:    "image width 16 exceeds the maximum of 10 pixels",
+			limits: Limits{MaxWidth: 10},
+		},
+		{
+			name:   "too many pixels",
+			file:   readTestFile(t, "xmp.png"),
+			err:    "image has 128 pixels which exceeds the maximum of 100",
+			limits: Limits{MaxPixels: 100},
+		},
+		{
+			name:   "gif animation at the limit",
+			file:   animation(t, 100),
+			limits: Limits{MaxPixels: 10000},
+			width:  10,
+			height: 10,
+		},
+		{
+			name:   "too many frames",
+			file:   animation(t, 5000),
+			err:    "animation has more than 100 frames, which exceeds the maximum of 10000 pixels",
+			limits: Limits{MaxPixels: 10000},
+		},
+		{name: "jpeg cut in header", file: cut("exif.jpg", 20), err: "file is truncated"},
+		{name: "jpeg cut in image data", file: cut("exif.jpg", 1500), err: "file is truncated"},
+		{name: "png cut in image data", file: cut("xmp.png", 700), err: "file is truncated"},
+		{name: "gif cut in image data", file: cut("comment.gif", 62), err: "file is truncated"},
+		{name: "webp cut in header", file: cut("exif.webp", 20), err: "file is truncated"},
+		{name: "webp cut in image data", file: cut("exif.webp", 60), err: "file is truncated"},
+		{name: "tiff cut in pixel data", file: cut("exif.tif", 20), err: "file is truncated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := Validate(bytes.NewReader(test.file), test.limits)
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(test.name, info.Format.Name) || info.Width != test.width || info.Height != test.height {
+					t.Errorf("got %s %dx%d, want %s %dx%d", info.Format.Name, info.Width, info.Height, test.name,
+						test.width, test.height)
+				}
+				return
+			}
+
+			validationErr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("got error %v, want validation error %q", err, test.err)
+			}
+			if validationErr.Message != test.err || validationErr.Unsupported != test.unsupported {
+				t.Errorf("got error %q unsupported %t, want %q unsupported %t", validationErr.Message,
+					validationErr.Unsupported, test.err, test.unsupported)
+			}
+		})
+	}
+}
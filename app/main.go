@@ -7,10 +7,13 @@ import (
 
 	"image_gallery/category"
 	"image_gallery/database"
+	"image_gallery/helpers"
 	"image_gallery/home"
 	"image_gallery/image"
 	cLog "image_gallery/logger"
 	"image_gallery/router"
+	"image_gallery/storage"
+	"image_gallery/tag"
 
 	"github.com/gorilla/handlers"
 )
@@ -18,8 +21,47 @@ import (
 func main() {
 	logger := cLog.GetLogger()
 
+	// gallery migrate [up | down [steps] | status] migrates the database and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(logger, os.Args[2:]); err != nil {
+			logger.Fatalf("could not migrate database: %v", err)
+		}
+		return
+	}
+
+	// gallery tags cleanup [-dry-run] merges the tags with the same normalised name and exits
+	if len(os.Args) > 1 && os.Args[1] == "tags" {
+		if err := runTags(logger, os.Args[2:]); err != nil {
+			logger.Fatalf("could not clean up tags: %v", err)
+		}
+		return
+	}
+
 	logger.Info("Server started on port 8080")
 
+	err := database.Connect()
+	if err != nil {
+		logger.Fatalf("could not connect to db: %v", err)
+	}
+
+	migrator, err := database.NewMigrator(database.DbConn, database.DbDriver, logger)
+	if err != nil {
+		logger.Fatalf("could not load migrations: %v", err)
+	}
+
+	if migrator.Config.OnStart {
+		if _, err = migrator.Up(); err != nil {
+			logger.Fatalf("could not migrate database: %v", err)
+		}
+	}
+
+	store := &image.SQLStore{Conn: database.DbConn, Driver: database.DbDriver}
+
+	tagNames, err := tag.LoadNameConfig()
+	if err != nil {
+		logger.Fatalf("could not load tag names config: %v", err)
+	}
+
 	apiRouter := router.Router{
 		Logger: logger,
 	}
@@ -31,24 +73,60 @@ func main() {
 
 	// Category handler
 	apiRouter.AddHandler(&category.Handler{
-		Logger: logger,
+		Logger:     logger,
+		Repository: store.Categories(),
 	})
 
-	// Images handler
-	apiRouter.AddHandler(&image.Handler{
+	// Tag handler
+	apiRouter.AddHandler(&tag.Handler{
 		Logger: logger,
+		Store:  image.TagStore(store),
+		Names:  tagNames,
 	})
 
-	err := database.Connect()
+	fileStorage, err := storage.Open()
 	if err != nil {
-		logger.Fatalf("could not connect to db: %v", err)
+		logger.Fatalf("could not open file storage: %v", err)
+	}
+
+	presets, err := image.LoadPresets()
+	if err != nil {
+		logger.Fatalf("could not load rendition presets: %v", err)
 	}
 
+	renderer, err := image.NewRenderer()
+	if err != nil {
+		logger.Fatalf("could not create image renderer: %v", err)
+	}
+
+	uploadConfig, err := image.LoadUploadConfig()
+	if err != nil {
+		logger.Fatalf("could not load upload config: %v", err)
+	}
+
+	resumable, err := image.NewResumable()
+	if err != nil {
+		logger.Fatalf("could not create resumable uploads: %v", err)
+	}
+
+	// Images handler
+	apiRouter.AddHandler(&image.Handler{
+		Logger:     logger,
+		Store:      store,
+		Storage:    fileStorage,
+		Presets:    presets,
+		Renderer:   renderer,
+		Upload:     uploadConfig,
+		Resumable:  resumable,
+		OwnerToken: os.Getenv("OWNER_TOKEN"),
+		TagNames:   tagNames,
+	})
+
 	muxRouter := apiRouter.Configure()
 
 	// handle file server
-	muxRouter.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/",
-		http.FileServer(http.Dir(image.UploadPath))))
+	muxRouter.PathPrefix(image.UploadURL).Handler(http.StripPrefix(image.UploadURL,
+		storage.FileServer(fileStorage)))
 
 	port := os.Getenv("API_PORT")
 	if port == "" {
@@ -61,8 +139,9 @@ func main() {
 		handlers.CORS(
 			// Allowed origins are specified in docker-compose.yaml
 			handlers.AllowedOrigins(strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",")),
-			handlers.AllowedHeaders([]string{"Content-Type"}),
-			handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE"}),
+			handlers.AllowedHeaders(append([]string{"Content-Type", helpers.OwnerTokenHeader}, image.TusHeaders...)),
+			handlers.ExposedHeaders(image.TusHeaders),
+			handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}),
 		)(muxRouter),
 	)
 
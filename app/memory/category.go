@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"database/sql"
+	"image_gallery/category"
+	"sort"
+	"time"
+)
+
+// categoryRepository is the category.Repository of a Store
+type categoryRepository struct {
+	store *Store
+}
+
+// SelectCategoryByID returns a copy of the category, nil when it does not exist
+func (r *categoryRepository) SelectCategoryByID(id int64) (*category.Category, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	row, ok := r.store.tables.categories[id]
+	if !ok {
+		return nil, nil
+	}
+
+	c := *row
+	return &c, nil
+}
+
+// RetrieveAllCategories returns copies of all categories, by id unless ordered by filters
+func (r *categoryRepository) RetrieveAllCategories(
+	filters map[category.FilterName]interface{}) ([]*category.Category, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var categories []*category.Category
+	for _, row := range r.store.tables.categories {
+		c := *row
+		categories = append(categories, &c)
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].ID < categories[j].ID
+	})
+
+	// as the SQL repository, ordered categories are limited to the first 3
+	if order, ok := filters[category.FilterByDateOfUpdate].(string); ok && (order == "asc" || order == "desc") {
+		sort.SliceStable(categories, func(i, j int) bool {
+			if order == "asc" {
+				return categories[i].UpdatedAt.Before(categories[j].UpdatedAt)
+			}
+			return categories[i].UpdatedAt.After(categories[j].UpdatedAt)
+		})
+		if len(categories) > 3 {
+			categories = categories[:3]
+		}
+	}
+
+	return categories, nil
+}
+
+// InsertCategory saves a new category and sets its id
+func (r *categoryRepository) InsertCategory(c *category.Category) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	t.lastCategoryID++
+
+	c.ID = t.lastCategoryID
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+
+	row := *c
+	t.categories[c.ID] = &row
+
+	return nil
+}
+
+// UpdateCategory replaces the category id, sql.ErrNoRows is returned when it does not exist
+func (r *categoryRepository) UpdateCategory(c *category.Category, id int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.tables.categories[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	c.ID = id
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = time.Now()
+
+	row := *c
+	r.store.tables.categories[id] = &row
+
+	return nil
+}
+
+// DeleteCategory deletes a category with its images
+func (r *categoryRepository) DeleteCategory(id int64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if _, ok := t.categories[id]; !ok {
+		return 0, nil
+	}
+
+	delete(t.categories, id)
+	for imageID, row := range t.images {
+		if row.CategoryID == id {
+			t.deleteImage(imageID)
+		}
+	}
+
+	return 1, nil
+}
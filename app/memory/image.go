@@ -0,0 +1,513 @@
+package memory
+
+import (
+	"database/sql"
+	"fmt"
+	"image_gallery/category"
+	"image_gallery/helpers"
+	"image_gallery/image"
+	"image_gallery/imaging"
+	"sort"
+	"time"
+)
+
+// imageRepository is the image.Repository of a Store
+type imageRepository struct {
+	store *Store
+}
+
+// imageRow returns a copy of the columns of an image, without its relations
+func imageRow(i *image.Image) *image.Image {
+	row := image.Image{
+		ID:          i.ID,
+		Name:        i.Name,
+		Slug:        i.Slug,
+		Description: i.Description,
+		Type:        i.Type,
+		Hash:        i.Hash,
+		Width:       i.Width,
+		Height:      i.Height,
+		BlurHash:    i.BlurHash,
+		CreatedAt:   i.CreatedAt,
+		UpdatedAt:   i.UpdatedAt,
+		CategoryID:  i.CategoryID,
+	}
+	if i.PerceptualHash != nil {
+		hash := *i.PerceptualHash
+		row.PerceptualHash = &hash
+	}
+
+	return &row
+}
+
+// sortedImages returns the images rows by id
+func (t *tables) sortedImages() []*image.Image {
+	rows := make([]*image.Image, 0, len(t.images))
+	for _, row := range t.images {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	return rows
+}
+
+// deleteImage deletes an image with its tags links, renditions, metadata and palette
+func (t *tables) deleteImage(id int64) {
+	delete(t.images, id)
+	delete(t.renditions, id)
+	delete(t.metadata, id)
+	delete(t.palettes, id)
+
+	links := make([]imageTag, 0, len(t.imageTags))
+	for _, link := range t.imageTags {
+		if link.ImageID != id {
+			links = append(links, link)
+		}
+	}
+	t.imageTags = links
+}
+
+// SelectImageByID returns a copy of the image, nil when it does not exist
+func (r *imageRepository) SelectImageByID(id int64) (*image.Image, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	row, ok := r.store.tables.images[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return imageRow(row), nil
+}
+
+// SelectImageByHash returns the first image whose stored file has the given
+// content hash, other than the image excludedID
+func (r *imageRepository) SelectImageByHash(hash string, excludedID int64) (*image.Image, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, row := range r.store.tables.sortedImages() {
+		if row.Hash == hash && row.ID != excludedID {
+			return imageRow(row), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RetrieveAllImages returns the images matching filters with their category,
+// tags, renditions and palette, by id unless ordered by filters
+func (r *imageRepository) RetrieveAllImages(filters map[image.FilterName]interface{}) ([]*image.Image, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+
+	var images []*image.Image
+	for _, row := range t.sortedImages() {
+		rowCategory, ok := t.categories[row.CategoryID]
+		if !ok || !t.matches(row, filters) {
+			continue
+		}
+
+		// as the SQL repository, the type, category id and upload limit are left out
+		listed := imageRow(row)
+		listed.Type = ""
+		listed.PerceptualHash = nil
+		listed.Category = &category.Category{
+			Name:          rowCategory.Name,
+			Description:   rowCategory.Description,
+			StripMetadata: rowCategory.StripMetadata,
+			CreatedAt:     rowCategory.CreatedAt,
+			UpdatedAt:     rowCategory.UpdatedAt,
+		}
+		listed.TagsNames = t.tagNames(row.ID)
+		listed.Renditions = t.copyRenditions(row.ID)
+		listed.Palette = append([]imaging.PaletteColor(nil), t.palettes[row.ID]...)
+
+		images = append(images, listed)
+	}
+
+	if order, ok := filters[image.FilterByDateOfUpdate].(string); ok {
+		sort.SliceStable(images, func(i, j int) bool {
+			switch order {
+			case "asc":
+				return images[i].UpdatedAt.Before(images[j].UpdatedAt)
+			case "desc":
+				return images[i].UpdatedAt.After(images[j].UpdatedAt)
+			default:
+				return false
+			}
+		})
+	}
+
+	return images, nil
+}
+
+// matches is true when an image row matches the category, tag and color filters
+func (t *tables) matches(row *image.Image, filters map[image.FilterName]interface{}) bool {
+	if categoryID, ok := filters[image.FilterByCategory].(int64); ok && row.CategoryID != categoryID {
+		return false
+	}
+
+	if tagID, ok := filters[image.FilterByTag].(int64); ok {
+		tagged := false
+		for _, link := range t.imageTags {
+			if link.ImageID == row.ID && link.TagID == tagID {
+				tagged = true
+				break
+			}
+		}
+		if !tagged {
+			return false
+		}
+	}
+
+	if tagID, ok := filters[image.FilterByTagTree].(int64); ok {
+		tags := t.descendants(tagID)
+		tagged := false
+		for _, link := range t.imageTags {
+			if link.ImageID == row.ID && tags[link.TagID] {
+				tagged = true
+				break
+			}
+		}
+		if !tagged {
+			return false
+		}
+	}
+
+	if filter, ok := filters[image.FilterByColor].(image.ColorFilter); ok {
+		l, a, b := imaging.Lab(filter.Color)
+		weight := 0.0
+		for _, paletteColor := range t.palettes[row.ID] {
+			cl, ca, cb := imaging.Lab(paletteColor.Color)
+			if (cl-l)*(cl-l)+(ca-a)*(ca-a)+(cb-b)*(cb-b) <= filter.Tolerance*filter.Tolerance {
+				weight += paletteColor.Weight
+			}
+		}
+		if weight < image.DominantColorWeight {
+			return false
+		}
+	}
+
+	return true
+}
+
+// InsertImage saves a new image with a generated slug and sets its id
+func (r *imageRepository) InsertImage(i *image.Image) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if _, ok := t.categories[i.CategoryID]; !ok {
+		return foreignKeyError("category", i.CategoryID)
+	}
+
+	i.Type = ""
+	i.CreatedAt = time.Now()
+	i.UpdatedAt = time.Now()
+
+	for {
+		i.Slug = helpers.GenerateAlphanumericToken(10)
+		if !t.slugExists(i.Slug) {
+			break
+		}
+	}
+
+	t.lastImageID++
+	i.ID = t.lastImageID
+	t.images[i.ID] = imageRow(i)
+
+	return nil
+}
+
+func (t *tables) slugExists(slug string) bool {
+	for _, row := range t.images {
+		if row.Slug == slug {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateImage saves the name, description and type of the image id,
+// sql.ErrNoRows is returned when it does not exist
+func (r *imageRepository) UpdateImage(i *image.Image, id int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.tables.images[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	i.ID = id
+	i.Slug = existing.Slug
+	i.Type = existing.Type
+	i.CreatedAt = existing.CreatedAt
+	i.UpdatedAt = time.Now()
+
+	row := imageRow(existing)
+	row.Name = i.Name
+	row.Description = i.Description
+	row.UpdatedAt = i.UpdatedAt
+	r.store.tables.images[id] = row
+
+	return nil
+}
+
+// UpdateImageFile saves the type, hashes, size and placeholder of the stored file of an image
+func (r *imageRepository) UpdateImageFile(i *image.Image) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.tables.images[i.ID]
+	if !ok {
+		return nil
+	}
+
+	i.UpdatedAt = time.Now()
+
+	row := imageRow(i)
+	row.Name = existing.Name
+	row.Slug = existing.Slug
+	row.Description = existing.Description
+	row.CreatedAt = existing.CreatedAt
+	row.CategoryID = existing.CategoryID
+	r.store.tables.images[i.ID] = row
+
+	return nil
+}
+
+// ClearImageFile marks an image as not uploaded once its file is deleted
+func (r *imageRepository) ClearImageFile(id int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.tables.images[id]
+	if !ok {
+		return nil
+	}
+
+	row := imageRow(existing)
+	row.Type = ""
+	row.Hash = ""
+	row.PerceptualHash = nil
+	row.Width = 0
+	row.Height = 0
+	row.BlurHash = ""
+	row.UpdatedAt = time.Now()
+	r.store.tables.images[id] = row
+
+	return nil
+}
+
+// DeleteImage deletes an image with its tags links, renditions, metadata and palette
+func (r *imageRepository) DeleteImage(id int64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.tables.images[id]; !ok {
+		return 0, nil
+	}
+
+	r.store.tables.deleteImage(id)
+
+	return 1, nil
+}
+
+// SelectPerceptualHashes returns the perceptual hashes of all uploaded images
+func (r *imageRepository) SelectPerceptualHashes() ([]image.PerceptualHash, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var hashes []image.PerceptualHash
+	for _, row := range r.store.tables.sortedImages() {
+		if row.PerceptualHash != nil {
+			hashes = append(hashes, image.PerceptualHash{ImageID: row.ID, Hash: *row.PerceptualHash})
+		}
+	}
+
+	return hashes, nil
+}
+
+// LinkTagToImage links an image to a tag
+func (r *imageRepository) LinkTagToImage(imageID int64, tagID int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if _, ok := t.images[imageID]; !ok {
+		return foreignKeyError("image", imageID)
+	}
+	if _, ok := t.tags[tagID]; !ok {
+		return foreignKeyError("tag", tagID)
+	}
+
+	for _, link := range t.imageTags {
+		if link.ImageID == imageID && link.TagID == tagID {
+			return fmt.Errorf("could not link tag: image %d is already linked to tag %d", imageID, tagID)
+		}
+	}
+
+	t.imageTags = append(t.imageTags, imageTag{ImageID: imageID, TagID: tagID})
+
+	return nil
+}
+
+// UnlinkTagsFromImage removes all tags of an image, the tags are kept
+func (r *imageRepository) UnlinkTagsFromImage(imageID int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	links := make([]imageTag, 0, len(t.imageTags))
+	for _, link := range t.imageTags {
+		if link.ImageID != imageID {
+			links = append(links, link)
+		}
+	}
+	t.imageTags = links
+
+	return nil
+}
+
+// InsertRendition saves a rendition generated for an image
+func (r *imageRepository) InsertRendition(imageID int64, name string, rendition *image.Rendition) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if _, ok := t.images[imageID]; !ok {
+		return foreignKeyError("image", imageID)
+	}
+	if _, ok := t.renditions[imageID][name]; ok {
+		return fmt.Errorf("could not insert rendition: duplicate rendition %s of image %d", name, imageID)
+	}
+
+	renditions := make(map[string]*image.Rendition, len(t.renditions[imageID])+1)
+	for existingName, existing := range t.renditions[imageID] {
+		renditions[existingName] = existing
+	}
+	renditions[name] = &image.Rendition{Key: rendition.Key, Width: rendition.Width, Height: rendition.Height}
+	t.renditions[imageID] = renditions
+
+	return nil
+}
+
+// SelectRenditionsByImageID gets all renditions of an image by name
+func (r *imageRepository) SelectRenditionsByImageID(imageID int64) (map[string]*image.Rendition, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.tables.copyRenditions(imageID), nil
+}
+
+// copyRenditions returns copies of the renditions of an image with their url, nil when there is none
+func (t *tables) copyRenditions(imageID int64) map[string]*image.Rendition {
+	var renditions map[string]*image.Rendition
+	for name, row := range t.renditions[imageID] {
+		if renditions == nil {
+			renditions = make(map[string]*image.Rendition)
+		}
+		rendition := *row
+		rendition.URL = image.UploadURL + rendition.Key
+		renditions[name] = &rendition
+	}
+
+	return renditions
+}
+
+// DeleteRenditions deletes all renditions of an image
+func (r *imageRepository) DeleteRenditions(imageID int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.tables.renditions, imageID)
+
+	return nil
+}
+
+// InsertMetadata saves the metadata extracted from an image file
+func (r *imageRepository) InsertMetadata(imageID int64, metadata *imaging.Metadata) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if _, ok := t.images[imageID]; !ok {
+		return foreignKeyError("image", imageID)
+	}
+	if _, ok := t.metadata[imageID]; ok {
+		return fmt.Errorf("could not insert metadata: image %d already has metadata", imageID)
+	}
+
+	row := *metadata
+	t.metadata[imageID] = &row
+
+	return nil
+}
+
+// SelectMetadataByImageID retrieves a copy of the metadata of an image file
+func (r *imageRepository) SelectMetadataByImageID(imageID int64) (*imaging.Metadata, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	row, ok := r.store.tables.metadata[imageID]
+	if !ok {
+		return nil, nil
+	}
+
+	metadata := *row
+	return &metadata, nil
+}
+
+// DeleteMetadata deletes the metadata of an image file
+func (r *imageRepository) DeleteMetadata(imageID int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.tables.metadata, imageID)
+
+	return nil
+}
+
+// InsertPalette saves the dominant colors of an image file
+func (r *imageRepository) InsertPalette(imageID int64, palette []imaging.PaletteColor) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if len(palette) == 0 {
+		return nil
+	}
+	if _, ok := t.images[imageID]; !ok {
+		return foreignKeyError("image", imageID)
+	}
+	if len(t.palettes[imageID]) > 0 {
+		return fmt.Errorf("could not insert palette color: image %d already has a palette", imageID)
+	}
+
+	t.palettes[imageID] = append([]imaging.PaletteColor(nil), palette...)
+
+	return nil
+}
+
+// SelectPaletteByImageID retrieves the dominant colors of an image file, most covering first
+func (r *imageRepository) SelectPaletteByImageID(imageID int64) ([]imaging.PaletteColor, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return append([]imaging.PaletteColor(nil), r.store.tables.palettes[imageID]...), nil
+}
+
+// DeletePalette deletes the dominant colors of an image file
+func (r *imageRepository) DeletePalette(imageID int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.tables.palettes, imageID)
+
+	return nil
+}
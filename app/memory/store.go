@@ -0,0 +1,160 @@
+// Package memory keeps categories, images and tags in memory. It implements
+// the repositories of the SQL database so that the whole API can be run
+// without one, in tests or demos: data is lost when the process exits
+package memory
+
+import (
+	"fmt"
+	"image_gallery/category"
+	"image_gallery/image"
+	"image_gallery/imaging"
+	"image_gallery/tag"
+	"sync"
+	"time"
+)
+
+// foreignKeyError is returned when a row references a missing row, as the
+// constraints of the SQL schema would
+func foreignKeyError(table string, id int64) error {
+	return fmt.Errorf("foreign key constraint fails: %s %d does not exist", table, id)
+}
+
+// imageTag links an image to a tag
+type imageTag struct {
+	ImageID int64
+	TagID   int64
+}
+
+// tagAlias is another name of a tag
+type tagAlias struct {
+	Name      string
+	TagID     int64
+	CreatedAt time.Time
+}
+
+// tables holds the rows of the database. Rows are never modified in place,
+// a changed row is replaced by a copy so that a snapshot only copies the maps
+type tables struct {
+	categories map[int64]*category.Category
+	images     map[int64]*image.Image
+	tags       map[int64]*tag.Tag
+	imageTags  []imageTag
+	// tagAliases are indexed by lower case name, names are compared ignoring case
+	tagAliases map[string]*tagAlias
+	renditions map[int64]map[string]*image.Rendition
+	metadata   map[int64]*imaging.Metadata
+	palettes   map[int64][]imaging.PaletteColor
+
+	lastCategoryID int64
+	lastImageID    int64
+	lastTagID      int64
+}
+
+func newTables() *tables {
+	return &tables{
+		categories: make(map[int64]*category.Category),
+		images:     make(map[int64]*image.Image),
+		tags:       make(map[int64]*tag.Tag),
+		tagAliases: make(map[string]*tagAlias),
+		renditions: make(map[int64]map[string]*image.Rendition),
+		metadata:   make(map[int64]*imaging.Metadata),
+		palettes:   make(map[int64][]imaging.PaletteColor),
+	}
+}
+
+// snapshot returns a copy of the tables sharing their rows
+func (t *tables) snapshot() *tables {
+	snapshot := *t
+
+	snapshot.categories = make(map[int64]*category.Category, len(t.categories))
+	for id, row := range t.categories {
+		snapshot.categories[id] = row
+	}
+	snapshot.images = make(map[int64]*image.Image, len(t.images))
+	for id, row := range t.images {
+		snapshot.images[id] = row
+	}
+	snapshot.tags = make(map[int64]*tag.Tag, len(t.tags))
+	for id, row := range t.tags {
+		snapshot.tags[id] = row
+	}
+	snapshot.imageTags = append([]imageTag(nil), t.imageTags...)
+	snapshot.tagAliases = make(map[string]*tagAlias, len(t.tagAliases))
+	for name, row := range t.tagAliases {
+		snapshot.tagAliases[name] = row
+	}
+	snapshot.renditions = make(map[int64]map[string]*image.Rendition, len(t.renditions))
+	for id, rows := range t.renditions {
+		snapshot.renditions[id] = rows
+	}
+	snapshot.metadata = make(map[int64]*imaging.Metadata, len(t.metadata))
+	for id, row := range t.metadata {
+		snapshot.metadata[id] = row
+	}
+	snapshot.palettes = make(map[int64][]imaging.PaletteColor, len(t.palettes))
+	for id, rows := range t.palettes {
+		snapshot.palettes[id] = rows
+	}
+
+	return &snapshot
+}
+
+// Store is an image.Store keeping its data in memory, safe for concurrent use
+type Store struct {
+	mu     sync.Mutex
+	tables *tables
+	// txMu runs a single transaction at once
+	txMu sync.Mutex
+}
+
+// NewStore returns an empty store
+func NewStore() *Store {
+	return &Store{tables: newTables()}
+}
+
+// Images returns the image repository
+func (s *Store) Images() image.Repository {
+	return &imageRepository{store: s}
+}
+
+// Categories returns the category repository
+func (s *Store) Categories() category.Repository {
+	return &categoryRepository{store: s}
+}
+
+// Tags returns the tag repository
+func (s *Store) Tags() tag.Repository {
+	return &tagRepository{store: s}
+}
+
+// Transaction runs fn and restores the data as it was before when fn fails.
+// Transactions run one at a time, but changes made outside of a transaction
+// while it runs are seen by it and lost if it is rolled back
+func (s *Store) Transaction(fn func(store image.Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	snapshot := s.tables.snapshot()
+	s.mu.Unlock()
+
+	if err := fn(&transaction{Store: s}); err != nil {
+		s.mu.Lock()
+		s.tables = snapshot
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// transaction is the store given to a transaction, transactions started
+// within it join it
+type transaction struct {
+	*Store
+}
+
+// Transaction runs fn within the current transaction
+func (tx *transaction) Transaction(fn func(store image.Store) error) error {
+	return fn(tx)
+}
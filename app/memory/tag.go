@@ -0,0 +1,470 @@
+package memory
+
+import (
+	"fmt"
+	"image_gallery/tag"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tagRepository is the tag.Repository of a Store
+type tagRepository struct {
+	store *Store
+}
+
+// SelectTagBy returns a copy of the tag whose id or name is whereValue. Names
+// are compared ignoring case, as the default MySQL collation does
+func (r *tagRepository) SelectTagBy(whereColumn string, whereValue interface{}) (*tag.Tag, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var match func(row *tag.Tag) bool
+	switch whereColumn {
+	case "id":
+		id, ok := whereValue.(int64)
+		if !ok {
+			return nil, fmt.Errorf("tag id must be an int64, got %T", whereValue)
+		}
+		match = func(row *tag.Tag) bool { return row.ID == id }
+	case "name":
+		name, ok := whereValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("tag name must be a string, got %T", whereValue)
+		}
+		match = func(row *tag.Tag) bool { return strings.EqualFold(row.Name, name) }
+	default:
+		return nil, fmt.Errorf("unknown tag column %q", whereColumn)
+	}
+
+	var found *tag.Tag
+	for _, row := range r.store.tables.tags {
+		if match(row) && (found == nil || row.ID < found.ID) {
+			found = row
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+
+	t := *found
+	t.ImageCount = r.store.tables.imageCount(t.ID)
+	return &t, nil
+}
+
+// RetrieveAllTags returns copies of all tags ordered by name ignoring case,
+// with their usage count
+func (r *tagRepository) RetrieveAllTags() ([]*tag.Tag, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	tags := make([]*tag.Tag, 0, len(r.store.tables.tags))
+	for _, row := range r.store.tables.tags {
+		t := *row
+		t.ImageCount = r.store.tables.imageCount(t.ID)
+		tags = append(tags, &t)
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := strings.ToLower(tags[i].Name), strings.ToLower(tags[j].Name)
+		if a != b {
+			return a < b
+		}
+		return tags[i].ID < tags[j].ID
+	})
+
+	return tags, nil
+}
+
+// InsertTag saves a new tag and sets its id, names are unique ignoring case
+func (r *tagRepository) InsertTag(t *tag.Tag) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if r.store.tables.tagByName(t.Name) != nil {
+		return fmt.Errorf("could not insert tag: duplicate tag name %s", t.Name)
+	}
+
+	return r.store.tables.insertTag(t)
+}
+
+// UpsertTag saves a new tag, or sets tag to the tag with the same name
+func (r *tagRepository) UpsertTag(t *tag.Tag) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if found := r.store.tables.tagByName(t.Name); found != nil {
+		*t = *found
+		return nil
+	}
+
+	return r.store.tables.insertTag(t)
+}
+
+// insertTag saves a new tag and sets its id
+func (t *tables) insertTag(newTag *tag.Tag) error {
+	if newTag.ParentID != nil {
+		if _, ok := t.tags[*newTag.ParentID]; !ok {
+			return foreignKeyError("tag", *newTag.ParentID)
+		}
+	}
+
+	t.lastTagID++
+
+	newTag.ID = t.lastTagID
+	newTag.CreatedAt = time.Now()
+	newTag.UpdatedAt = time.Now()
+
+	row := *newTag
+	t.tags[newTag.ID] = &row
+
+	return nil
+}
+
+// tagByName returns the tag named name ignoring case, nil when there is none
+func (t *tables) tagByName(name string) *tag.Tag {
+	var found *tag.Tag
+	for _, row := range t.tags {
+		if strings.EqualFold(row.Name, name) && (found == nil || row.ID < found.ID) {
+			found = row
+		}
+	}
+
+	return found
+}
+
+// UpdateTag renames a tag
+func (r *tagRepository) UpdateTag(t *tag.Tag, id int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.tables.tags[id]
+	if !ok {
+		return fmt.Errorf("tag %d does not exist", id)
+	}
+	if other := r.store.tables.tagByName(t.Name); other != nil && other.ID != id {
+		return fmt.Errorf("could not update tag: duplicate tag name %s", t.Name)
+	}
+
+	t.ID = id
+	t.CreatedAt = existing.CreatedAt
+	t.UpdatedAt = time.Now()
+
+	row := *existing
+	row.Name, row.UpdatedAt = t.Name, t.UpdatedAt
+	r.store.tables.tags[id] = &row
+
+	return nil
+}
+
+// MoveTag sets the parent of a tag
+func (r *tagRepository) MoveTag(id int64, parentID *int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	existing, ok := r.store.tables.tags[id]
+	if !ok {
+		return fmt.Errorf("tag %d does not exist", id)
+	}
+	if parentID != nil {
+		if _, ok := r.store.tables.tags[*parentID]; !ok {
+			return foreignKeyError("tag", *parentID)
+		}
+	}
+
+	row := *existing
+	row.ParentID, row.UpdatedAt = parentID, time.Now()
+	r.store.tables.tags[id] = &row
+
+	return nil
+}
+
+// SelectDescendantIDs returns the id of a tag and the ids of the tags below it,
+// nothing when the tag does not exist
+func (r *tagRepository) SelectDescendantIDs(id int64) ([]int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	ids := make([]int64, 0)
+	for tagID := range r.store.tables.descendants(id) {
+		ids = append(ids, tagID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// descendants returns the set of the id of a tag and the ids of the tags below it
+func (t *tables) descendants(id int64) map[int64]bool {
+	found := make(map[int64]bool)
+	if _, ok := t.tags[id]; !ok {
+		return found
+	}
+
+	found[id] = true
+	for added := true; added; {
+		added = false
+		for _, row := range t.tags {
+			if row.ParentID != nil && found[*row.ParentID] && !found[row.ID] {
+				found[row.ID] = true
+				added = true
+			}
+		}
+	}
+
+	return found
+}
+
+// DeleteTag deletes a tag and its links to images, its children are moved to
+// its parent
+func (r *tagRepository) DeleteTag(id int64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	deleted, ok := t.tags[id]
+	if !ok {
+		return 0, nil
+	}
+
+	for childID, child := range t.tags {
+		if child.ParentID != nil && *child.ParentID == id {
+			row := *child
+			row.ParentID = deleted.ParentID
+			t.tags[childID] = &row
+		}
+	}
+
+	links := t.imageTags[:0:0]
+	for _, link := range t.imageTags {
+		if link.TagID != id {
+			links = append(links, link)
+		}
+	}
+	t.imageTags = links
+	delete(t.tags, id)
+
+	for name, alias := range t.tagAliases {
+		if alias.TagID == id {
+			delete(t.tagAliases, name)
+		}
+	}
+
+	return 1, nil
+}
+
+// ResolveTag returns a copy of the tag named name or whose alias is name
+func (r *tagRepository) ResolveTag(name string) (*tag.Tag, error) {
+	found, err := r.SelectTagBy("name", name)
+	if err != nil || found != nil {
+		return found, err
+	}
+
+	r.store.mu.Lock()
+	alias, ok := r.store.tables.tagAliases[strings.ToLower(name)]
+	r.store.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	return r.SelectTagBy("id", alias.TagID)
+}
+
+// MergeTags links the images, aliases and children of a tag to another one, then deletes it
+func (r *tagRepository) MergeTags(sourceID int64, targetID int64) error {
+	r.store.mu.Lock()
+
+	t := r.store.tables
+	if _, ok := t.tags[targetID]; !ok {
+		r.store.mu.Unlock()
+		return foreignKeyError("tag", targetID)
+	}
+
+	linked := make(map[int64]bool)
+	for _, link := range t.imageTags {
+		if link.TagID == targetID {
+			linked[link.ImageID] = true
+		}
+	}
+
+	links := append([]imageTag(nil), t.imageTags...)
+	for _, link := range t.imageTags {
+		if link.TagID == sourceID && !linked[link.ImageID] {
+			linked[link.ImageID] = true
+			links = append(links, imageTag{ImageID: link.ImageID, TagID: targetID})
+		}
+	}
+	t.imageTags = links
+
+	for name, alias := range t.tagAliases {
+		if alias.TagID == sourceID {
+			moved := *alias
+			moved.TagID = targetID
+			t.tagAliases[name] = &moved
+		}
+	}
+
+	for childID, child := range t.tags {
+		if child.ParentID != nil && *child.ParentID == sourceID {
+			row := *child
+			row.ParentID = &targetID
+			t.tags[childID] = &row
+		}
+	}
+
+	r.store.mu.Unlock()
+
+	_, err := r.DeleteTag(sourceID)
+
+	return err
+}
+
+// InsertAlias adds an alias to a tag
+func (r *tagRepository) InsertAlias(tagID int64, name string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	if _, ok := t.tags[tagID]; !ok {
+		return foreignKeyError("tag", tagID)
+	}
+	if _, ok := t.tagAliases[strings.ToLower(name)]; ok {
+		return fmt.Errorf("could not insert tag alias: duplicate alias %s", name)
+	}
+
+	t.tagAliases[strings.ToLower(name)] = &tagAlias{Name: name, TagID: tagID, CreatedAt: time.Now()}
+
+	return nil
+}
+
+// SelectAliasesByTagID returns the aliases of a tag ordered by name
+func (r *tagRepository) SelectAliasesByTagID(tagID int64) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	aliases := make([]string, 0)
+	for _, alias := range r.store.tables.tagAliases {
+		if alias.TagID == tagID {
+			aliases = append(aliases, alias.Name)
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return strings.ToLower(aliases[i]) < strings.ToLower(aliases[j])
+	})
+
+	return aliases, nil
+}
+
+// RetrieveAllAliases returns the aliases of all tags ordered by name
+func (r *tagRepository) RetrieveAllAliases() (map[int64][]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	aliases := make(map[int64][]string)
+	for _, alias := range r.store.tables.tagAliases {
+		aliases[alias.TagID] = append(aliases[alias.TagID], alias.Name)
+	}
+	for _, names := range aliases {
+		sort.Slice(names, func(i, j int) bool {
+			return strings.ToLower(names[i]) < strings.ToLower(names[j])
+		})
+	}
+
+	return aliases, nil
+}
+
+// SelectCoOccurringTags counts, for each tag the image is not linked to, its
+// links to the images sharing a tag with the image, once per shared tag
+func (r *tagRepository) SelectCoOccurringTags(imageID int64, limit int) ([]*tag.Suggestion, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t := r.store.tables
+	own := make(map[int64]bool)
+	for _, link := range t.imageTags {
+		if link.ImageID == imageID {
+			own[link.TagID] = true
+		}
+	}
+
+	// shared counts the tags each other image shares with the image
+	shared := make(map[int64]int64)
+	for _, link := range t.imageTags {
+		if link.ImageID != imageID && own[link.TagID] {
+			shared[link.ImageID]++
+		}
+	}
+
+	counts := make(map[int64]int64)
+	for _, link := range t.imageTags {
+		if shared[link.ImageID] > 0 && !own[link.TagID] {
+			counts[link.TagID] += shared[link.ImageID]
+		}
+	}
+
+	suggestions := make([]*tag.Suggestion, 0, len(counts))
+	for tagID, count := range counts {
+		suggestion := &tag.Suggestion{Tag: *t.tags[tagID], CoOccurrences: count}
+		suggestion.ImageCount = t.imageCount(tagID)
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].CoOccurrences != suggestions[j].CoOccurrences {
+			return suggestions[i].CoOccurrences > suggestions[j].CoOccurrences
+		}
+		return strings.ToLower(suggestions[i].Name) < strings.ToLower(suggestions[j].Name)
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+// DeleteAlias removes an alias of a tag
+func (r *tagRepository) DeleteAlias(tagID int64, name string) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	alias, ok := r.store.tables.tagAliases[strings.ToLower(name)]
+	if !ok || alias.TagID != tagID {
+		return 0, nil
+	}
+
+	delete(r.store.tables.tagAliases, strings.ToLower(name))
+
+	return 1, nil
+}
+
+// GetAllTagsByImageID returns the names of the tags linked to an image, in the
+// order they were linked
+func (r *tagRepository) GetAllTagsByImageID(id int64) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.tables.tagNames(id), nil
+}
+
+// tagNames returns the names of the tags linked to an image
+func (t *tables) tagNames(imageID int64) []string {
+	var names []string
+	for _, link := range t.imageTags {
+		if link.ImageID == imageID {
+			names = append(names, t.tags[link.TagID].Name)
+		}
+	}
+
+	return names
+}
+
+// imageCount returns the number of images linked to a tag
+func (t *tables) imageCount(tagID int64) int64 {
+	images := make(map[int64]bool)
+	for _, link := range t.imageTags {
+		if link.TagID == tagID {
+			images[link.ImageID] = true
+		}
+	}
+
+	return int64(len(images))
+}
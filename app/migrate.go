@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"image_gallery/database"
+	cLog "image_gallery/logger"
+)
+
+// runMigrate runs the migrate subcommand: up, down [steps] or status
+func runMigrate(logger *cLog.Logger, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	if err := database.Connect(); err != nil {
+		return fmt.Errorf("could not connect to db: %v", err)
+	}
+
+	migrator, err := database.NewMigrator(database.DbConn, database.DbDriver, logger)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		logger.Infof("%d migrations applied", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive integer")
+			}
+		}
+
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			return err
+		}
+		logger.Infof("%d migrations reverted", reverted)
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-40s %s\n", status.Version, status.Name, appliedAt)
+		}
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down [steps] or status", command)
+	}
+
+	return nil
+}
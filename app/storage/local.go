@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files on the local filesystem under Root
+type Local struct {
+	Root string
+}
+
+// NewLocal returns a local storage, creating root directory if needed
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("could not create storage directory: %v", err)
+	}
+
+	return &Local{Root: root}, nil
+}
+
+// path converts a key to a filesystem path, refusing keys escaping root
+func (l *Local) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	return filepath.Join(l.Root, filepath.FromSlash(cleaned)), nil
+}
+
+// Put writes the file to a temporary file and moves it into place
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("could not write directory: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write file: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("could not write file: %v", err)
+	}
+
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("could not set file mode: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// Get opens the file, the returned reader is an *os.File and can seek
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	return f, err
+}
+
+// Stat returns info about the file
+func (l *Local) Stat(key string) (*FileInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrNotExist
+	}
+
+	return l.fileInfo(key, fi), nil
+}
+
+// Delete removes the file and its directory when it becomes empty
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+
+	// fails silently when the directory still contains files
+	if dir := filepath.Dir(p); dir != filepath.Clean(l.Root) {
+		_ = os.Remove(dir)
+	}
+
+	return nil
+}
+
+// List walks the directory of prefix and returns files matching prefix
+func (l *Local) List(prefix string) ([]*FileInfo, error) {
+	var files []*FileInfo
+
+	// only the directory holding the keys of prefix is walked, not the whole root
+	dir := l.Root
+	if prefixDir := path.Dir(prefix); prefixDir != "." && prefixDir != "/" {
+		var err error
+		if dir, err = l.path(prefixDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, l.fileInfo(key, fi))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %v", err)
+	}
+
+	return files, nil
+}
+
+func (l *Local) fileInfo(key string, fi os.FileInfo) *FileInfo {
+	return &FileInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     fi.ModTime(),
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// putFiles stores files whose content is their key
+func putFiles(t *testing.T, l *Local, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		if err := l.Put(key, strings.NewReader(key), int64(len(key)), ""); err != nil {
+			t.Fatalf("could not put %s: %v", key, err)
+		}
+	}
+}
+
+func TestLocalPutGet(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFiles(t, l, "ab/cd/file.png")
+
+	// a second put replaces the file
+	if err = l.Put("ab/cd/file.png", strings.NewReader("replaced"), 8, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := l.Get("/ab/../ab/cd/file.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "replaced" {
+		t.Errorf("got content %q, want %q", content, "replaced")
+	}
+
+	info, err := l.Stat("ab/cd/file.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "ab/cd/file.png" || info.Size != 8 || info.ContentType != "image/png" {
+		t.Errorf("unexpected file info %+v", info)
+	}
+
+	if _, err = l.Get("ab/cd/other.png"); err != ErrNotExist {
+		t.Errorf("got error %v getting a missing file, want %v", err, ErrNotExist)
+	}
+	if _, err = l.Stat("ab/cd"); err != ErrNotExist {
+		t.Errorf("got error %v for the stat of a directory, want %v", err, ErrNotExist)
+	}
+	if err = l.Put("..", strings.NewReader("root"), 4, ""); err == nil {
+		t.Errorf("a file was put at the root directory")
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFiles(t, l, "ab/cd/one.png", "ab/cd/one.webp", "ab/ce/two.png", "ab/cd/sub/three.png", "root.png")
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"ab/cd/one.png", "ab/cd/one.webp", "ab/cd/sub/three.png", "ab/ce/two.png", "root.png"}},
+		{prefix: "ab/c", want: []string{"ab/cd/one.png", "ab/cd/one.webp", "ab/cd/sub/three.png", "ab/ce/two.png"}},
+		{prefix: "ab/cd/", want: []string{"ab/cd/one.png", "ab/cd/one.webp", "ab/cd/sub/three.png"}},
+		{prefix: "ab/cd/one", want: []string{"ab/cd/one.png", "ab/cd/one.webp"}},
+		{prefix: "ab/cd/one.png", want: []string{"ab/cd/one.png"}},
+		{prefix: "ab/cf/", want: nil},
+		{prefix: "zz/yy/one", want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			files, err := l.List(test.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var keys []string
+			for _, file := range files {
+				keys = append(keys, file.Key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("got keys %q, want %q", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestLocalDelete(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	putFiles(t, l, "ab/cd/one.png", "ab/cd/two.png")
+
+	if err = l.Delete("ab/cd/one.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Stat("ab/cd/one.png"); err != ErrNotExist {
+		t.Errorf("got error %v for a deleted file, want %v", err, ErrNotExist)
+	}
+	if err = l.Delete("ab/cd/one.png"); err != ErrNotExist {
+		t.Errorf("got error %v deleting a deleted file, want %v", err, ErrNotExist)
+	}
+
+	// the directory is kept while it contains files
+	if _, err = l.Stat("ab/cd/two.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Delete("ab/cd/two.png"); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := l.List(""); err != nil || len(files) != 0 {
+		t.Errorf("got files %v and error %v after deleting all files", files, err)
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+)
+
+// S3 stores files in a bucket of an S3 compatible object storage (AWS, MinIO...)
+type S3 struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// NewS3 returns a S3 storage, creating the bucket if it does not exist
+func NewS3(cfg Config) (*S3, error) {
+	if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET must be set to use s3 storage")
+	}
+
+	client, err := minio.NewWithRegion(cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3UseSSL, cfg.S3Region)
+	if err != nil {
+		return nil, fmt.Errorf("could not create s3 client: %v", err)
+	}
+
+	exists, err := client.BucketExists(cfg.S3Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not check if bucket exists: %v", err)
+	}
+
+	if !exists {
+		err = client.MakeBucket(cfg.S3Bucket, cfg.S3Region)
+		if err != nil {
+			return nil, fmt.Errorf("could not create bucket: %v", err)
+		}
+	}
+
+	return &S3{Client: client, Bucket: cfg.S3Bucket}, nil
+}
+
+// Put uploads the file, multipart upload is used when size is unknown or large
+func (s *S3) Put(key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.Client.PutObject(s.Bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("could not put object: %v", err)
+	}
+
+	return nil
+}
+
+// Get opens the object, the returned reader is a *minio.Object and can seek
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	// GetObject is lazy, stat first so missing objects are reported now
+	if _, err := s.Stat(key); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.Client.GetObject(s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get object: %v", err)
+	}
+
+	return obj, nil
+}
+
+// Stat returns info about the object
+func (s *S3) Stat(key string) (*FileInfo, error) {
+	info, err := s.Client.StatObject(s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.convertError(err)
+	}
+
+	return s.fileInfo(info), nil
+}
+
+// Delete removes the object
+func (s *S3) Delete(key string) error {
+	if _, err := s.Stat(key); err != nil {
+		return err
+	}
+
+	if err := s.Client.RemoveObject(s.Bucket, key); err != nil {
+		return fmt.Errorf("could not remove object: %v", err)
+	}
+
+	return nil
+}
+
+// List returns all objects whose key starts with prefix
+func (s *S3) List(prefix string) ([]*FileInfo, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var files []*FileInfo
+	for info := range s.Client.ListObjectsV2(s.Bucket, prefix, true, doneCh) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("could not list objects: %v", info.Err)
+		}
+		if strings.HasSuffix(info.Key, "/") {
+			continue
+		}
+		files = append(files, s.fileInfo(info))
+	}
+
+	return files, nil
+}
+
+func (s *S3) fileInfo(info minio.ObjectInfo) *FileInfo {
+	return &FileInfo{
+		Key:         info.Key,
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		ModTime:     info.LastModified,
+	}
+}
+
+func (s *S3) convertError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotExist
+	default:
+		return err
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// FileServer returns a handler serving files from the storage, the request
+// path is used as key so it should be used with http.StripPrefix
+func FileServer(s Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+		info, err := s.Stat(key)
+		if err == ErrNotExist || key == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "could not read file", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := s.Get(key)
+		if err != nil {
+			http.Error(w, "could not read file", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+
+		// ServeContent handles ranges and conditional requests when possible
+		if rs, ok := file.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, path.Base(key), info.ModTime, rs)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = io.Copy(w, file)
+		}
+	})
+}
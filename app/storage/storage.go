@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+// ErrNotExist is returned when a file does not exist in the storage
+var ErrNotExist = errors.New("file does not exist")
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage is a file storage backend, keys are slash separated paths
+type Storage interface {
+	// Put stores the content of r under key, size can be -1 if unknown
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens the file stored under key, the caller must close it
+	Get(key string) (io.ReadCloser, error)
+	// Stat returns the file info of the file stored under key
+	Stat(key string) (*FileInfo, error)
+	// Delete removes the file stored under key
+	Delete(key string) error
+	// List returns all files whose key starts with prefix
+	List(prefix string) ([]*FileInfo, error)
+}
+
+// Config for storage backend
+type Config struct {
+	Driver      string `env:"STORAGE_DRIVER" envDefault:"local"`
+	LocalPath   string `env:"STORAGE_LOCAL_PATH" envDefault:"/go/uploads/"`
+	S3Endpoint  string `env:"S3_ENDPOINT"`
+	S3AccessKey string `env:"S3_ACCESS_KEY"`
+	S3SecretKey string `env:"S3_SECRET_KEY"`
+	S3Bucket    string `env:"S3_BUCKET"`
+	S3Region    string `env:"S3_REGION"`
+	S3UseSSL    bool   `env:"S3_USE_SSL"`
+}
+
+// Open returns the storage backend chosen by the STORAGE_DRIVER env var
+func Open() (Storage, error) {
+	cfg := Config{}
+	if err := env.Parse(&cfg); err != nil {
+		return nil, fmt.Errorf("%+v", err)
+	}
+
+	switch cfg.Driver {
+	case "local":
+		return NewLocal(cfg.LocalPath)
+	case "s3":
+		return NewS3(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
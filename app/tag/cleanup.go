@@ -0,0 +1,133 @@
+package tag
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+// CleanupReport lists the changes made by Cleanup
+type CleanupReport struct {
+	// Merged is the number of tags merged into a tag with the same name
+	Merged int
+	// Renamed is the number of tags renamed to their normalised name
+	Renamed int
+	// Changes describes each change
+	Changes []string
+}
+
+// Cleanup merges the tags whose names are the same once normalised into the
+// oldest of them, then renames the tags to their normalised name. It runs in
+// a single transaction, rolled back when dryRun is set
+func Cleanup(store Store, names NameConfig, dryRun bool) (*CleanupReport, error) {
+	report := &CleanupReport{}
+
+	err := store.Transaction(func(store Store) error {
+		repository := store.Tags()
+
+		tags, err := repository.RetrieveAllTags()
+		if err != nil {
+			return err
+		}
+
+		// tags are grouped by normalised name, the first tag of a group has the lowest id
+		var keys []string
+		groups := make(map[string][]*Tag)
+		for _, tag := range tags {
+			key := names.Key(tag.Name)
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			group := append(groups[key], tag)
+			if group[0].ID > tag.ID {
+				group[0], group[len(group)-1] = group[len(group)-1], group[0]
+			}
+			groups[key] = group
+		}
+
+		for _, key := range keys {
+			group := groups[key]
+			target := group[0]
+
+			for _, source := range group[1:] {
+				below, err := isDescendant(repository, target.ID, source.ID)
+				if err != nil {
+					return err
+				}
+				if below {
+					report.Changes = append(report.Changes,
+						fmt.Sprintf("could not merge tag %d %q into tag %d %q, which is below it", source.ID,
+							source.Name, target.ID, target.Name))
+					continue
+				}
+
+				if err = repository.MergeTags(source.ID, target.ID); err != nil {
+					return fmt.Errorf("could not merge tag %d into tag %d: %v", source.ID, target.ID, err)
+				}
+				report.Merged++
+				report.Changes = append(report.Changes,
+					fmt.Sprintf("merged tag %d %q into tag %d %q", source.ID, source.Name, target.ID, target.Name))
+
+				// the name is kept unless it still names a tag, as with a different case
+				existing, err := repository.ResolveTag(source.Name)
+				if err != nil {
+					return err
+				}
+				if existing == nil {
+					if err = repository.InsertAlias(target.ID, source.Name); err != nil {
+						return err
+					}
+				}
+			}
+
+			normalised := names.Normalize(target.Name)
+			if normalised == target.Name || normalised == "" {
+				continue
+			}
+
+			// a name taken by another tag through an alias is left as is
+			existing, err := repository.ResolveTag(normalised)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.ID != target.ID {
+				report.Changes = append(report.Changes,
+					fmt.Sprintf("could not rename tag %d %q, %q is used by tag %d", target.ID, target.Name,
+						normalised, existing.ID))
+				continue
+			}
+
+			if err = repository.UpdateTag(&Tag{Name: normalised}, target.ID); err != nil {
+				return fmt.Errorf("could not rename tag %d: %v", target.ID, err)
+			}
+			if _, err = repository.DeleteAlias(target.ID, normalised); err != nil {
+				return err
+			}
+			// the former name is kept unless it differs only by case
+			existing, err = repository.ResolveTag(target.Name)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				if err = repository.InsertAlias(target.ID, target.Name); err != nil {
+					return err
+				}
+			}
+			report.Renamed++
+			report.Changes = append(report.Changes,
+				fmt.Sprintf("renamed tag %d %q to %q", target.ID, target.Name, normalised))
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+
+	return report, nil
+}
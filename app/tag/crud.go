@@ -3,12 +3,59 @@ package tag
 import (
 	"database/sql"
 	"fmt"
+	"image_gallery/database"
 	"time"
 )
 
-// Repository struct for db connection
-type Repository struct {
-	Conn *sql.DB
+// Repository stores tags
+type Repository interface {
+	// SelectTagBy returns the tag whose column whereColumn is whereValue, nil when there is none
+	SelectTagBy(whereColumn string, whereValue interface{}) (*Tag, error)
+	// RetrieveAllTags returns all tags ordered by name, with the number of images they are linked to
+	RetrieveAllTags() ([]*Tag, error)
+	InsertTag(tag *Tag) error
+	// UpsertTag saves a new tag unless a tag with the same name exists, tag is
+	// then set to the existing one. Concurrent calls with a name save a single tag
+	UpsertTag(tag *Tag) error
+	UpdateTag(tag *Tag, id int64) error
+	// MoveTag sets the parent of a tag, nil moves it to the root of the tree
+	MoveTag(id int64, parentID *int64) error
+	// SelectDescendantIDs returns the id of a tag and the ids of its descendants
+	SelectDescendantIDs(id int64) ([]int64, error)
+	// DeleteTag deletes a tag and its links to images, its children are moved to
+	// its parent. It must run in a transaction
+	DeleteTag(id int64) (int64, error)
+	GetAllTagsByImageID(id int64) ([]string, error)
+	// ResolveTag returns the tag named name, or the tag name is an alias of,
+	// nil when there is none
+	ResolveTag(name string) (*Tag, error)
+	// MergeTags moves the images, aliases and children of the tag sourceID to
+	// the tag targetID then deletes it, it must run in a transaction. targetID
+	// must not be a descendant of sourceID
+	MergeTags(sourceID int64, targetID int64) error
+	InsertAlias(tagID int64, name string) error
+	SelectAliasesByTagID(tagID int64) ([]string, error)
+	// RetrieveAllAliases returns the aliases of all tags by tag id
+	RetrieveAllAliases() (map[int64][]string, error)
+	// SelectCoOccurringTags returns the tags linked to the images sharing a tag
+	// with an image, which the image is not linked to, most frequent first
+	SelectCoOccurringTags(imageID int64, limit int) ([]*Suggestion, error)
+	DeleteAlias(tagID int64, name string) (int64, error)
+}
+
+// Store gives access to the tag repository and runs transactions
+type Store interface {
+	Tags() Repository
+	// Transaction runs fn with a store whose changes are committed when fn
+	// returns nil and rolled back otherwise
+	Transaction(fn func(store Store) error) error
+}
+
+// SQLRepository is the Repository of a SQL database
+type SQLRepository struct {
+	// Conn runs queries written with ? placeholders, see Driver.Conn
+	Conn   database.Querier
+	Driver database.Driver
 }
 
 // Tag struct
@@ -17,6 +64,66 @@ type Tag struct {
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// ImageCount is the number of images linked to the tag
+	ImageCount int64 `json:"image_count"`
+	// ParentID is the id of the parent tag, nil at the root of the tree
+	ParentID *int64 `json:"parent_id"`
+	// Aliases are the other names of the tag
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Suggestion is a tag suggested for a query or an image
+type Suggestion struct {
+	Tag
+	// Alias is the alias matching the query, empty when the tag name matches
+	Alias string `json:"alias,omitempty"`
+	// CoOccurrences is the number of times the tag is linked to an image
+	// sharing a tag with the image
+	CoOccurrences int64 `json:"co_occurrences,omitempty"`
+}
+
+// Alias is another name of a tag
+type Alias struct {
+	Name string `json:"name"`
+}
+
+// Validate : interface for JSON backend validation
+func (a *Alias) Validate() error {
+	tag := Tag{Name: a.Name}
+	return tag.Validate()
+}
+
+// Merge lists the tags merged into another one
+type Merge struct {
+	TagIDs []int64 `json:"tags"`
+}
+
+// Validate : interface for JSON backend validation
+func (m *Merge) Validate() error {
+	if len(m.TagIDs) == 0 {
+		return fmt.Errorf("tags cannot be empty")
+	}
+
+	seen := make(map[int64]bool, len(m.TagIDs))
+	for _, id := range m.TagIDs {
+		if seen[id] {
+			return fmt.Errorf("tag %d is listed twice", id)
+		}
+		seen[id] = true
+	}
+
+	return nil
+}
+
+// Move sets the parent of a tag
+type Move struct {
+	// ParentID is the id of the new parent, nil moves the tag to the root of the tree
+	ParentID *int64 `json:"parent_id"`
+}
+
+// Validate : interface for JSON backend validation
+func (m *Move) Validate() error {
+	return nil
 }
 
 // Validate : interface for JSON backend validation
@@ -32,58 +139,208 @@ func (t *Tag) Validate() error {
 	return nil
 }
 
+// tagFields are the columns read by scanTag
+const tagFields = "t.id, t.name, t.created_at, t.updated_at, t.parent_id," +
+	" (SELECT COUNT(DISTINCT it.image_id) FROM image_tag it WHERE it.tag_id = t.id)"
+
+// DescendantsQuery selects the id of the tag ? and the ids of its descendants
+const DescendantsQuery = "WITH RECURSIVE descendant(id) AS (SELECT t.id FROM tag t WHERE t.id = ?" +
+	" UNION SELECT t.id FROM tag t INNER JOIN descendant d ON t.parent_id = d.id) SELECT d.id FROM descendant d"
+
+// scanTag reads a tag selected with tagFields
+func scanTag(scan func(dest ...interface{}) error) (*Tag, error) {
+	var tag Tag
+	var parentID sql.NullInt64
+	err := scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt, &parentID, &tag.ImageCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentID.Valid {
+		tag.ParentID = &parentID.Int64
+	}
+
+	return &tag, nil
+}
+
 // SelectTagBy retrieves a tag by any field (whereColumn) and any value (whereValue)
-func (repository *Repository) SelectTagBy(whereColumn string, whereValue interface{}) (*Tag, error) {
-	query := "SELECT t.id, t.name, t.created_at, t.updated_at FROM tag t WHERE t." + whereColumn + "=(?)"
-	row := repository.Conn.QueryRow(query, whereValue)
-	var id int64
-	var name string
-	var createdAt, updatedAt time.Time
-	switch err := row.Scan(&id, &name, &createdAt, &updatedAt); err {
-	case sql.ErrNoRows:
+func (repository *SQLRepository) SelectTagBy(whereColumn string, whereValue interface{}) (*Tag, error) {
+	query := "SELECT " + tagFields + " FROM tag t WHERE t." + whereColumn + "=(?)"
+	// names are compared ignoring case, as MySQL and SQLite do with the collation of the column
+	if repository.Driver == database.PostgreSQL && whereColumn == "name" {
+		query = "SELECT " + tagFields + " FROM tag t WHERE LOWER(t.name)=LOWER(?)"
+	}
+
+	tag, err := scanTag(repository.Conn.QueryRow(query, whereValue).Scan)
+	if err == sql.ErrNoRows {
 		return nil, nil
-	case nil:
-		tag := Tag{
-			ID:        id,
-			Name:      name,
-			CreatedAt: createdAt,
-			UpdatedAt: updatedAt,
+	}
+
+	return tag, err
+}
+
+// RetrieveAllTags retrieves all tags with their usage count
+func (repository *SQLRepository) RetrieveAllTags() ([]*Tag, error) {
+	rows, err := repository.Conn.Query("SELECT " + tagFields + " FROM tag t ORDER BY t.name, t.id")
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+	defer rows.Close()
+
+	tags := make([]*Tag, 0)
+	for rows.Next() {
+		tag, err := scanTag(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("could not get tags: %v", err)
 		}
-		return &tag, nil
-	default:
-		return nil, err
+		tags = append(tags, tag)
 	}
+
+	return tags, rows.Err()
 }
 
 // InsertTag posts a new tag
-func (repository *Repository) InsertTag(tag *Tag) error {
-	stmt, err := repository.Conn.Prepare("INSERT INTO tag(name, created_at," +
-		" updated_at) VALUES(?,?,?)")
+func (repository *SQLRepository) InsertTag(tag *Tag) error {
+	tag.CreatedAt = time.Now()
+	tag.UpdatedAt = time.Now()
 
-	if err != nil {
-		return err
+	lastInsertedID, errInsert := repository.Driver.Insert(repository.Conn, "INSERT INTO tag(name, created_at,"+
+		" updated_at, parent_id) VALUES(?,?,?,?)", tag.Name, tag.CreatedAt, tag.UpdatedAt, tag.ParentID)
+
+	if errInsert != nil {
+		return errInsert
 	}
+
+	tag.ID = lastInsertedID
+
+	return nil
+}
+
+// UpsertTag posts a new tag or reads the tag with the same name, the unique
+// index of tag names settles concurrent inserts
+func (repository *SQLRepository) UpsertTag(tag *Tag) error {
 	tag.CreatedAt = time.Now()
 	tag.UpdatedAt = time.Now()
 
-	res, errExec := stmt.Exec(tag.Name, tag.CreatedAt, tag.UpdatedAt)
-	if errExec != nil {
-		return errExec
+	query := "INSERT INTO tag(name, created_at, updated_at, parent_id) VALUES(?,?,?,?) ON CONFLICT DO NOTHING"
+	if repository.Driver == database.MySQL {
+		query = "INSERT INTO tag(name, created_at, updated_at, parent_id) VALUES(?,?,?,?)" +
+			" ON DUPLICATE KEY UPDATE id=id"
+	}
+
+	_, err := repository.Conn.Exec(query, tag.Name, tag.CreatedAt, tag.UpdatedAt, tag.ParentID)
+	if err != nil {
+		return fmt.Errorf("could not insert tag: %v", err)
 	}
 
-	lastInsertedID, errInsert := res.LastInsertId()
+	// the tag may have been saved by a transaction committed after this one
+	// started, which only a locking read sees with MySQL
+	query = "SELECT t.id, t.name, t.created_at, t.updated_at, t.parent_id FROM tag t WHERE t.name=(?)"
+	switch repository.Driver {
+	case database.MySQL:
+		query += " FOR UPDATE"
+	case database.PostgreSQL:
+		query = "SELECT t.id, t.name, t.created_at, t.updated_at, t.parent_id FROM tag t" +
+			" WHERE LOWER(t.name)=LOWER(?)"
+	}
 
-	if errInsert != nil {
-		return errInsert
+	var parentID sql.NullInt64
+	err = repository.Conn.QueryRow(query, tag.Name).Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt,
+		&parentID)
+	if err != nil {
+		return fmt.Errorf("could not retrieve saved tag: %v", err)
 	}
 
-	tag.ID = lastInsertedID
+	tag.ParentID = nil
+	if parentID.Valid {
+		tag.ParentID = &parentID.Int64
+	}
 
 	return nil
 }
 
+// UpdateTag renames a tag
+func (repository *SQLRepository) UpdateTag(tag *Tag, id int64) error {
+	row := repository.Conn.QueryRow("SELECT t.created_at FROM tag t WHERE t.id=(?)", id)
+	if err := row.Scan(&tag.CreatedAt); err != nil {
+		return err
+	}
+	tag.UpdatedAt = time.Now()
+
+	_, err := repository.Conn.Exec("UPDATE tag SET name=(?), updated_at=(?) WHERE id=(?)", tag.Name, tag.UpdatedAt, id)
+	if err != nil {
+		return err
+	}
+
+	tag.ID = id
+
+	return nil
+}
+
+// MoveTag sets the parent of a tag
+func (repository *SQLRepository) MoveTag(id int64, parentID *int64) error {
+	_, err := repository.Conn.Exec("UPDATE tag SET parent_id=(?), updated_at=(?) WHERE id=(?)", parentID, time.Now(),
+		id)
+	if err != nil {
+		return fmt.Errorf("could not move tag: %v", err)
+	}
+
+	return nil
+}
+
+// SelectDescendantIDs returns the id of a tag and the ids of the tags below it
+func (repository *SQLRepository) SelectDescendantIDs(id int64) ([]int64, error) {
+	rows, err := repository.Conn.Query(DescendantsQuery, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag descendants: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var descendantID int64
+		if err = rows.Scan(&descendantID); err != nil {
+			return nil, fmt.Errorf("could not get tag descendants: %v", err)
+		}
+		ids = append(ids, descendantID)
+	}
+
+	return ids, rows.Err()
+}
+
+// DeleteTag by ID, images linked to the tag are kept and its children are
+// moved to its parent
+func (repository *SQLRepository) DeleteTag(id int64) (int64, error) {
+	_, err := repository.Conn.Exec("DELETE FROM image_tag WHERE tag_id=(?)", id)
+	if err != nil {
+		return 0, fmt.Errorf("could not unlink tag: %v", err)
+	}
+
+	// MySQL cannot update the tag table from a subquery reading it
+	var parentID sql.NullInt64
+	err = repository.Conn.QueryRow("SELECT t.parent_id FROM tag t WHERE t.id=(?)", id).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve tag parent: %v", err)
+	}
+
+	_, err = repository.Conn.Exec("UPDATE tag SET parent_id=(?) WHERE parent_id=(?)", parentID, id)
+	if err != nil {
+		return 0, fmt.Errorf("could not move tag children: %v", err)
+	}
+
+	res, err := repository.Conn.Exec("DELETE FROM tag WHERE id=(?)", id)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
 // GetAllTagsByImageID gets all tags linked to an image
-func (repository *Repository) GetAllTagsByImageID(id int64) ([]string, error) {
+func (repository *SQLRepository) GetAllTagsByImageID(id int64) ([]string, error) {
 
 	rows, err := repository.Conn.Query("SELECT t.name "+
 		"FROM tag t INNER JOIN image_tag it ON it.tag_id = t.id "+
@@ -105,3 +362,151 @@ func (repository *Repository) GetAllTagsByImageID(id int64) ([]string, error) {
 
 	return tags, nil
 }
+
+// ResolveTag retrieves a tag by its name or one of its aliases
+func (repository *SQLRepository) ResolveTag(name string) (*Tag, error) {
+	tag, err := repository.SelectTagBy("name", name)
+	if err != nil || tag != nil {
+		return tag, err
+	}
+
+	query := "SELECT ta.tag_id FROM tag_alias ta WHERE ta.name=(?)"
+	if repository.Driver == database.PostgreSQL {
+		query = "SELECT ta.tag_id FROM tag_alias ta WHERE LOWER(ta.name)=LOWER(?)"
+	}
+
+	var id int64
+	switch err := repository.Conn.QueryRow(query, name).Scan(&id); err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+		return repository.SelectTagBy("id", id)
+	default:
+		return nil, fmt.Errorf("could not resolve tag alias: %v", err)
+	}
+}
+
+// MergeTags links the images, aliases and children of a tag to another one,
+// images linked to both are linked once. The target must not be a descendant
+// of the source
+func (repository *SQLRepository) MergeTags(sourceID int64, targetID int64) error {
+	_, err := repository.Conn.Exec("INSERT INTO image_tag(image_id, tag_id)"+
+		" SELECT DISTINCT it.image_id, t.id FROM image_tag it INNER JOIN tag t ON t.id=(?) WHERE it.tag_id=(?)"+
+		" AND it.image_id NOT IN (SELECT it2.image_id FROM image_tag it2 WHERE it2.tag_id=(?))",
+		targetID, sourceID, targetID)
+	if err != nil {
+		return fmt.Errorf("could not move tag images: %v", err)
+	}
+
+	_, err = repository.Conn.Exec("UPDATE tag_alias SET tag_id=(?) WHERE tag_id=(?)", targetID, sourceID)
+	if err != nil {
+		return fmt.Errorf("could not move tag aliases: %v", err)
+	}
+
+	_, err = repository.Conn.Exec("UPDATE tag SET parent_id=(?) WHERE parent_id=(?)", targetID, sourceID)
+	if err != nil {
+		return fmt.Errorf("could not move tag children: %v", err)
+	}
+
+	_, err = repository.DeleteTag(sourceID)
+
+	return err
+}
+
+// InsertAlias adds an alias to a tag
+func (repository *SQLRepository) InsertAlias(tagID int64, name string) error {
+	_, err := repository.Conn.Exec("INSERT INTO tag_alias(name, tag_id, created_at) VALUES(?,?,?)", name, tagID,
+		time.Now())
+	if err != nil {
+		return fmt.Errorf("could not insert tag alias: %v", err)
+	}
+
+	return nil
+}
+
+// SelectAliasesByTagID returns the aliases of a tag ordered by name
+func (repository *SQLRepository) SelectAliasesByTagID(tagID int64) ([]string, error) {
+	rows, err := repository.Conn.Query("SELECT ta.name FROM tag_alias ta WHERE ta.tag_id=(?) ORDER BY ta.name",
+		tagID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag aliases: %v", err)
+	}
+	defer rows.Close()
+
+	aliases := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("could not get tag aliases: %v", err)
+		}
+		aliases = append(aliases, name)
+	}
+
+	return aliases, rows.Err()
+}
+
+// DeleteAlias removes an alias of a tag
+func (repository *SQLRepository) DeleteAlias(tagID int64, name string) (int64, error) {
+	query := "DELETE FROM tag_alias WHERE tag_id=(?) AND name=(?)"
+	if repository.Driver == database.PostgreSQL {
+		query = "DELETE FROM tag_alias WHERE tag_id=(?) AND LOWER(name)=LOWER(?)"
+	}
+
+	res, err := repository.Conn.Exec(query, tagID, name)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// RetrieveAllAliases returns the aliases of all tags ordered by name
+func (repository *SQLRepository) RetrieveAllAliases() (map[int64][]string, error) {
+	rows, err := repository.Conn.Query("SELECT ta.tag_id, ta.name FROM tag_alias ta ORDER BY ta.name")
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag aliases: %v", err)
+	}
+	defer rows.Close()
+
+	aliases := make(map[int64][]string)
+	for rows.Next() {
+		var tagID int64
+		var name string
+		if err = rows.Scan(&tagID, &name); err != nil {
+			return nil, fmt.Errorf("could not get tag aliases: %v", err)
+		}
+		aliases[tagID] = append(aliases[tagID], name)
+	}
+
+	return aliases, rows.Err()
+}
+
+// SelectCoOccurringTags counts, for each tag the image is not linked to, its
+// links to the images sharing a tag with the image, once per shared tag
+func (repository *SQLRepository) SelectCoOccurringTags(imageID int64, limit int) ([]*Suggestion, error) {
+	rows, err := repository.Conn.Query("SELECT "+tagFields+", COUNT(*) FROM image_tag mine"+
+		" INNER JOIN image_tag co ON co.tag_id = mine.tag_id AND co.image_id <> mine.image_id"+
+		" INNER JOIN image_tag sug ON sug.image_id = co.image_id"+
+		" INNER JOIN tag t ON t.id = sug.tag_id"+
+		" WHERE mine.image_id = (?)"+
+		" AND sug.tag_id NOT IN (SELECT own.tag_id FROM image_tag own WHERE own.image_id = (?))"+
+		" GROUP BY t.id ORDER BY COUNT(*) DESC, t.name, t.id LIMIT ?", imageID, imageID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve co-occurring tags: %v", err)
+	}
+	defer rows.Close()
+
+	suggestions := make([]*Suggestion, 0)
+	for rows.Next() {
+		var coOccurrences int64
+		tag, err := scanTag(func(dest ...interface{}) error {
+			return rows.Scan(append(dest, &coOccurrences)...)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not get co-occurring tags: %v", err)
+		}
+		suggestions = append(suggestions, &Suggestion{Tag: *tag, CoOccurrences: coOccurrences})
+	}
+
+	return suggestions, rows.Err()
+}
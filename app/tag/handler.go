@@ -0,0 +1,622 @@
+package tag
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"image_gallery/helpers"
+	cLog "image_gallery/logger"
+	"image_gallery/router"
+	"net/http"
+	"net/url"
+)
+
+// Handler is the tag handler, the images of a tag are listed by the image handler
+type Handler struct {
+	Logger *cLog.Logger
+	Store  Store
+	// Names normalises the names of tags and aliases
+	Names NameConfig
+}
+
+// Routes returns handler routes
+func (h *Handler) Routes() router.Routes {
+	return []router.Route{
+		router.Route{
+			Name:        "Get all tags",
+			Method:      "GET",
+			Pattern:     "/tags",
+			HandlerFunc: h.getAllTags,
+		},
+		router.Route{
+			Name:        "Suggest tags",
+			Method:      "GET",
+			Pattern:     "/tags/suggest",
+			HandlerFunc: h.suggestTags,
+		},
+		router.Route{
+			Name:        "Get a tag by id",
+			Method:      "GET",
+			Pattern:     "/tags/{id}",
+			HandlerFunc: h.getTagByID,
+		},
+		router.Route{
+			Name:        "Post tag",
+			Method:      "POST",
+			Pattern:     "/tags",
+			HandlerFunc: h.createTag,
+		},
+		router.Route{
+			Name:        "Rename tag",
+			Method:      "PUT",
+			Pattern:     "/tags/{id}",
+			HandlerFunc: h.updateTag,
+		},
+		router.Route{
+			Name:        "Delete tag",
+			Method:      "DELETE",
+			Pattern:     "/tags/{id}",
+			HandlerFunc: h.deleteTag,
+		},
+		router.Route{
+			Name:        "Merge tags",
+			Method:      "POST",
+			Pattern:     "/tags/{id}/merge",
+			HandlerFunc: h.mergeTags,
+		},
+		router.Route{
+			Name:        "Move tag",
+			Method:      "PUT",
+			Pattern:     "/tags/{id}/parent",
+			HandlerFunc: h.moveTag,
+		},
+		router.Route{
+			Name:        "Post tag alias",
+			Method:      "POST",
+			Pattern:     "/tags/{id}/aliases",
+			HandlerFunc: h.createAlias,
+		},
+		router.Route{
+			Name:        "Delete tag alias",
+			Method:      "DELETE",
+			Pattern:     "/tags/{id}/aliases/{name}",
+			HandlerFunc: h.deleteAlias,
+		},
+	}
+}
+
+func (h *Handler) getAllTags(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	tags, err := h.Store.Tags().RetrieveAllTags()
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tags")
+		return
+	}
+
+	h.Logger.Infof("tags retrieved")
+	helpers.WriteJSON(w, http.StatusOK, tags)
+}
+
+// suggestTags lists the tags whose name or alias matches the q query parameter
+func (h *Handler) suggestTags(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	limit, err := helpers.ParseLimit(r, defaultSuggestions, MaxSuggestions)
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	repository := h.Store.Tags()
+
+	tags, err := repository.RetrieveAllTags()
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tags")
+		return
+	}
+
+	aliases, err := repository.RetrieveAllAliases()
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tags")
+		return
+	}
+
+	suggestions := Suggest(tags, aliases, h.Names, r.URL.Query().Get("q"), limit)
+
+	h.Logger.Infof("%d tags suggested", len(suggestions))
+	helpers.WriteJSON(w, http.StatusOK, suggestions)
+}
+
+func (h *Handler) getTagByID(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	tag, err := selectTagWithAliases(h.Store.Tags(), id)
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to retrieve tag")
+		return
+	}
+
+	if tag == nil {
+		h.Logger.Infof("tried to retrieve a tag that does not exist with id %d", id)
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag does not exist")
+		return
+	}
+
+	h.Logger.Infof("tag retrieved: %v", tag)
+	helpers.WriteJSON(w, http.StatusOK, tag)
+}
+
+func (h *Handler) createTag(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	var tag Tag
+
+	err := h.readName(w, r, &tag, &tag.Name)
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+
+	var status int
+	err = h.Store.Transaction(func(store Store) error {
+		// the name must not be used by a tag or an alias
+		existing, err := store.Tags().ResolveTag(tag.Name)
+		if err != nil || existing != nil {
+			status = http.StatusConflict
+			return err
+		}
+
+		if tag.ParentID != nil {
+			parent, err := store.Tags().SelectTagBy("id", *tag.ParentID)
+			if err != nil || parent == nil {
+				status = http.StatusBadRequest
+				return err
+			}
+		}
+
+		return store.Tags().InsertTag(&tag)
+	})
+	if err != nil {
+		// a tag saved by a concurrent request makes the unique index of names refuse the insert
+		existing, resolveErr := h.Store.Tags().ResolveTag(tag.Name)
+		if resolveErr != nil || existing == nil {
+			h.Logger.Error(err)
+			helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to save tag")
+			return
+		}
+		status = http.StatusConflict
+	}
+
+	switch {
+	case status == http.StatusConflict:
+		helpers.WriteErrorJSON(w, http.StatusConflict, "a tag with this name already exists")
+	case status == http.StatusBadRequest:
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "parent tag does not exist")
+	default:
+		h.Logger.Infof("saved tag: %v", tag)
+		helpers.WriteJSON(w, http.StatusOK, tag)
+	}
+}
+
+func (h *Handler) updateTag(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	var tag Tag
+
+	err = h.readName(w, r, &tag, &tag.Name)
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+
+	var renamed *Tag
+	var status int
+	err = h.Store.Transaction(func(store Store) error {
+		repository := store.Tags()
+
+		existing, err := repository.SelectTagBy("id", id)
+		if err != nil || existing == nil {
+			status = http.StatusNotFound
+			return err
+		}
+
+		// the case of a tag name can be changed and an alias can become the name
+		// of its tag, a name used by another tag cannot
+		other, err := repository.ResolveTag(tag.Name)
+		if err != nil || (other != nil && other.ID != id) {
+			status = http.StatusConflict
+			return err
+		}
+
+		if err = repository.UpdateTag(&tag, id); err != nil {
+			return err
+		}
+
+		if _, err = repository.DeleteAlias(id, tag.Name); err != nil {
+			return err
+		}
+
+		renamed, err = selectTagWithAliases(repository, id)
+		return err
+	})
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to update tag")
+		return
+	}
+
+	switch {
+	case status == http.StatusNotFound:
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag does not exist")
+	case status == http.StatusConflict:
+		helpers.WriteErrorJSON(w, http.StatusConflict, "a tag with this name already exists")
+	default:
+		h.Logger.Infof("updated tag: %v", renamed)
+		helpers.WriteJSON(w, http.StatusOK, renamed)
+	}
+}
+
+func (h *Handler) deleteTag(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	var rowsAffected int64
+	err = h.Store.Transaction(func(store Store) error {
+		rowsAffected, err = store.Tags().DeleteTag(id)
+		return err
+	})
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to delete tag")
+		return
+	}
+
+	if rowsAffected == 0 {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag does not exist")
+		return
+	}
+
+	h.Logger.Infof("%d tag deleted with ID: %v", rowsAffected, id)
+	helpers.WriteJSON(w, http.StatusNoContent, "Tag deleted")
+}
+
+// mergeTags merges the tags of the request into the tag of the url: their
+// images are linked to it and their names become its aliases
+func (h *Handler) mergeTags(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	var merge Merge
+
+	err = helpers.ReadValidateJSON(w, r, &merge)
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+
+	for _, sourceID := range merge.TagIDs {
+		if sourceID == id {
+			helpers.WriteErrorJSON(w, http.StatusBadRequest, "a tag cannot be merged into itself")
+			return
+		}
+	}
+
+	var merged *Tag
+	var missing, ancestor int64
+	err = h.Store.Transaction(func(store Store) error {
+		repository := store.Tags()
+
+		target, err := repository.SelectTagBy("id", id)
+		if err != nil {
+			return err
+		}
+		if target == nil {
+			missing = id
+			return fmt.Errorf("tag %d does not exist", id)
+		}
+
+		for _, sourceID := range merge.TagIDs {
+			source, err := repository.SelectTagBy("id", sourceID)
+			if err != nil {
+				return err
+			}
+			// the error rolls back the merges of the tags listed before
+			if source == nil {
+				missing = sourceID
+				return fmt.Errorf("tag %d does not exist", sourceID)
+			}
+
+			// the children of the source would be moved below themselves
+			below, err := isDescendant(repository, id, sourceID)
+			if err != nil {
+				return err
+			}
+			if below {
+				ancestor = sourceID
+				return fmt.Errorf("tag %d is below tag %d", id, sourceID)
+			}
+
+			if err = repository.MergeTags(sourceID, id); err != nil {
+				return err
+			}
+
+			// the name is kept unless it still names a tag, as with a different case
+			existing, err := repository.ResolveTag(source.Name)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				if err = repository.InsertAlias(id, source.Name); err != nil {
+					return err
+				}
+			}
+		}
+
+		merged, err = selectTagWithAliases(repository, id)
+		return err
+	})
+	if err != nil {
+		if missing != 0 {
+			helpers.WriteErrorJSON(w, http.StatusNotFound, fmt.Sprintf("tag %d does not exist", missing))
+			return
+		}
+		if ancestor != 0 {
+			helpers.WriteErrorJSON(w, http.StatusConflict,
+				fmt.Sprintf("tag %d cannot be merged into one of its descendants", ancestor))
+			return
+		}
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to merge tags")
+		return
+	}
+
+	h.Logger.Infof("merged tags %v into tag %d", merge.TagIDs, id)
+	helpers.WriteJSON(w, http.StatusOK, merged)
+}
+
+// moveTag sets the parent of a tag, a tag cannot be moved below itself
+func (h *Handler) moveTag(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	var move Move
+
+	err = helpers.ReadValidateJSON(w, r, &move)
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+
+	var moved *Tag
+	var status int
+	err = h.Store.Transaction(func(store Store) error {
+		repository := store.Tags()
+
+		existing, err := repository.SelectTagBy("id", id)
+		if err != nil || existing == nil {
+			status = http.StatusNotFound
+			return err
+		}
+
+		if move.ParentID != nil {
+			parent, err := repository.SelectTagBy("id", *move.ParentID)
+			if err != nil || parent == nil {
+				status = http.StatusBadRequest
+				return err
+			}
+
+			// the parent must not be the tag or one of its descendants
+			below, err := isDescendant(repository, parent.ID, id)
+			if err != nil {
+				return err
+			}
+			if below {
+				status = http.StatusConflict
+				return nil
+			}
+		}
+
+		if err = repository.MoveTag(id, move.ParentID); err != nil {
+			return err
+		}
+
+		moved, err = selectTagWithAliases(repository, id)
+		return err
+	})
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to move tag")
+		return
+	}
+
+	switch {
+	case status == http.StatusNotFound:
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag does not exist")
+	case status == http.StatusBadRequest:
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "parent tag does not exist")
+	case status == http.StatusConflict:
+		helpers.WriteErrorJSON(w, http.StatusConflict, "a tag cannot be moved below itself or its descendants")
+	default:
+		h.Logger.Infof("moved tag: %v", moved)
+		helpers.WriteJSON(w, http.StatusOK, moved)
+	}
+}
+
+func (h *Handler) createAlias(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	id, err := helpers.ParseInt64(mux.Vars(r)["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	var alias Alias
+
+	err = h.readName(w, r, &alias, &alias.Name)
+	if err != nil {
+		h.Logger.Error(err)
+		return
+	}
+
+	var tag *Tag
+	var status int
+	err = h.Store.Transaction(func(store Store) error {
+		repository := store.Tags()
+
+		existing, err := repository.SelectTagBy("id", id)
+		if err != nil || existing == nil {
+			status = http.StatusNotFound
+			return err
+		}
+
+		// the name must not be used by a tag or an alias
+		other, err := repository.ResolveTag(alias.Name)
+		if err != nil || other != nil {
+			status = http.StatusConflict
+			return err
+		}
+
+		if err = repository.InsertAlias(id, alias.Name); err != nil {
+			return err
+		}
+
+		tag, err = selectTagWithAliases(repository, id)
+		return err
+	})
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to save tag alias")
+		return
+	}
+
+	switch {
+	case status == http.StatusNotFound:
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag does not exist")
+	case status == http.StatusConflict:
+		helpers.WriteErrorJSON(w, http.StatusConflict, "a tag or an alias with this name already exists")
+	default:
+		h.Logger.Infof("saved alias %s of tag %d", alias.Name, id)
+		helpers.WriteJSON(w, http.StatusOK, tag)
+	}
+}
+
+func (h *Handler) deleteAlias(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Infof("calling %v", r.URL.Path)
+
+	muxVars := mux.Vars(r)
+	id, err := helpers.ParseInt64(muxVars["id"])
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+
+	name, err := url.PathUnescape(muxVars["name"])
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, "invalid alias name")
+		return
+	}
+
+	rowsAffected, err := h.Store.Tags().DeleteAlias(id, h.Names.Normalize(name))
+	if err != nil {
+		h.Logger.Error(err)
+		helpers.WriteErrorJSON(w, http.StatusInternalServerError, "unable to delete tag alias")
+		return
+	}
+
+	if rowsAffected == 0 {
+		helpers.WriteErrorJSON(w, http.StatusNotFound, "this tag alias does not exist")
+		return
+	}
+
+	h.Logger.Infof("deleted alias %s of tag %d", name, id)
+	helpers.WriteJSON(w, http.StatusNoContent, "Tag alias deleted")
+}
+
+// readName reads a tag or an alias from the request body, normalises its name
+// and validates it, as a name can be empty once normalised
+func (h *Handler) readName(w http.ResponseWriter, r *http.Request, v helpers.Validable, name *string) error {
+	err := helpers.ReadJSON(w, r, v)
+	if err != nil {
+		return err
+	}
+
+	*name = h.Names.Normalize(*name)
+
+	err = v.Validate()
+	if err != nil {
+		helpers.WriteErrorJSON(w, http.StatusBadRequest, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// isDescendant returns true when the tag id is the tag ancestorID or one of its descendants
+func isDescendant(repository Repository, id int64, ancestorID int64) (bool, error) {
+	descendants, err := repository.SelectDescendantIDs(ancestorID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, descendantID := range descendants {
+		if descendantID == id {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// selectTagWithAliases returns a tag with its aliases, nil when it does not exist
+func selectTagWithAliases(repository Repository, id int64) (*Tag, error) {
+	tag, err := repository.SelectTagBy("id", id)
+	if err != nil || tag == nil {
+		return tag, err
+	}
+
+	tag.Aliases, err = repository.SelectAliasesByTagID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
@@ -0,0 +1,81 @@
+package tag
+
+import (
+	"fmt"
+	"github.com/caarlos0/env/v6"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// NameConfig sets how tag and alias names are normalised before they are
+// saved or looked up. Names are always compared ignoring case
+type NameConfig struct {
+	// Trim removes leading and trailing spaces and collapses inner ones
+	Trim bool `env:"TAG_TRIM" envDefault:"true"`
+	// NFC composes unicode characters, so that an accented letter typed as a
+	// letter and an accent is the same as the accented letter
+	NFC bool `env:"TAG_NFC" envDefault:"true"`
+	// CaseFold saves names in lower case, "Car" is saved as "car"
+	CaseFold bool `env:"TAG_CASE_FOLD" envDefault:"false"`
+	// Slug saves names as lower case words separated by dashes, "Red Car" is saved as "red-car"
+	Slug bool `env:"TAG_SLUG" envDefault:"false"`
+}
+
+// LoadNameConfig reads the tag names config from TAG_* env vars
+func LoadNameConfig() (NameConfig, error) {
+	cfg := NameConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, fmt.Errorf("%+v", err)
+	}
+
+	return cfg, nil
+}
+
+// Normalize returns the normalised form of a name
+func (cfg NameConfig) Normalize(name string) string {
+	if cfg.NFC {
+		name = norm.NFC.String(name)
+	}
+
+	if cfg.Trim {
+		name = strings.Join(strings.Fields(name), " ")
+	}
+
+	if cfg.CaseFold {
+		name = cases.Fold().String(name)
+	}
+
+	if cfg.Slug {
+		name = slug(name)
+	}
+
+	return name
+}
+
+// Key returns the form of a name two names are the same tag if they share,
+// the normalised name ignoring case
+func (cfg NameConfig) Key(name string) string {
+	return strings.ToLower(cfg.Normalize(name))
+}
+
+// slug lower cases letters and digits and replaces the other characters by
+// single dashes
+func slug(name string) string {
+	var slugged strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			if dash && slugged.Len() > 0 {
+				slugged.WriteByte('-')
+			}
+			dash = false
+			slugged.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+
+	return slugged.String()
+}
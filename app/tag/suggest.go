@@ -0,0 +1,142 @@
+package tag
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultSuggestions is the number of suggestions returned when no limit is set
+const defaultSuggestions = 10
+
+// MaxSuggestions is the number of suggestions returned at most
+const MaxSuggestions = 50
+
+// match ranks how a name matches a query, the lower the closer
+type match int
+
+const (
+	exactMatch match = iota
+	prefixMatch
+	fuzzyMatch
+	noMatch
+)
+
+// suggestion is a Suggestion with how it matches the query
+type suggestion struct {
+	*Suggestion
+	match    match
+	distance int
+}
+
+// Suggest returns the tags whose name or alias matches a query: exact matches
+// first, then names starting with the query, then names containing it or close
+// to it despite typos. Tags matching alike are ranked by usage count. An empty
+// query returns the most used tags
+func Suggest(tags []*Tag, aliases map[int64][]string, names NameConfig, query string, limit int) []*Suggestion {
+	key := names.Key(query)
+
+	suggestions := make([]*suggestion, 0)
+	for _, tag := range tags {
+		best := &suggestion{Suggestion: &Suggestion{Tag: *tag}, match: noMatch}
+
+		best.match, best.distance = matchName(key, names.Key(tag.Name))
+		for _, alias := range aliases[tag.ID] {
+			aliasMatch, distance := matchName(key, names.Key(alias))
+			if aliasMatch < best.match || (aliasMatch == best.match && distance < best.distance) {
+				best.match, best.distance, best.Alias = aliasMatch, distance, alias
+			}
+		}
+
+		if best.match != noMatch {
+			suggestions = append(suggestions, best)
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		switch {
+		case a.match != b.match:
+			return a.match < b.match
+		case a.ImageCount != b.ImageCount:
+			return a.ImageCount > b.ImageCount
+		default:
+			return a.distance < b.distance
+		}
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	matches := make([]*Suggestion, 0, len(suggestions))
+	for _, s := range suggestions {
+		matches = append(matches, s.Suggestion)
+	}
+
+	return matches
+}
+
+// matchName compares a query to a name, both lower case. A fuzzy match is a
+// name containing the query or whose beginning is a few typos from it, the
+// distance is the number of typos
+func matchName(query string, name string) (match, int) {
+	switch {
+	case query == name:
+		return exactMatch, 0
+	case strings.HasPrefix(name, query):
+		return prefixMatch, 0
+	case strings.Contains(name, query):
+		return fuzzyMatch, 0
+	}
+
+	// short queries have too many names a typo away
+	length := utf8.RuneCountInString(query)
+	maxDistance := 2
+	switch {
+	case length < 3:
+		return noMatch, 0
+	case length < 6:
+		maxDistance = 1
+	}
+
+	// the name is compared to the query as a whole, and as far as the query goes
+	distance := levenshtein(query, name)
+	if prefix := []rune(name); len(prefix) > length {
+		if prefixDistance := levenshtein(query, string(prefix[:length])); prefixDistance < distance {
+			distance = prefixDistance
+		}
+	}
+
+	if distance > maxDistance {
+		return noMatch, 0
+	}
+
+	return fuzzyMatch, distance
+}
+
+// levenshtein returns the number of characters to insert, delete or replace
+// to change a into b
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"image_gallery/database"
+	"image_gallery/image"
+	cLog "image_gallery/logger"
+	"image_gallery/tag"
+)
+
+// runTags runs the tags subcommand: cleanup [-dry-run]
+func runTags(logger *cLog.Logger, args []string) error {
+	if len(args) == 0 || args[0] != "cleanup" {
+		return fmt.Errorf("unknown tags command, use cleanup [-dry-run]")
+	}
+
+	flags := flag.NewFlagSet("tags cleanup", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "list the changes without saving them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	names, err := tag.LoadNameConfig()
+	if err != nil {
+		return fmt.Errorf("could not load tag names config: %v", err)
+	}
+
+	if err = database.Connect(); err != nil {
+		return fmt.Errorf("could not connect to db: %v", err)
+	}
+
+	// the queries of the tags need the latest schema
+	migrator, err := database.NewMigrator(database.DbConn, database.DbDriver, logger)
+	if err != nil {
+		return fmt.Errorf("could not load migrations: %v", err)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return fmt.Errorf("migration %d_%s is pending, run migrate up first", status.Version, status.Name)
+		}
+	}
+
+	store := &image.SQLStore{Conn: database.DbConn, Driver: database.DbDriver}
+
+	report, err := tag.Cleanup(image.TagStore(store), names, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range report.Changes {
+		fmt.Println(change)
+	}
+
+	if *dryRun {
+		logger.Infof("dry run, %d tags would be merged and %d renamed", report.Merged, report.Renamed)
+		return nil
+	}
+	logger.Infof("%d tags merged and %d renamed", report.Merged, report.Renamed)
+
+	return nil
+}
@@ -1,55 +1,7 @@
 /*
-    This file is used by the docker-compose build command to build the mysql db
-    
-    Tables:
-    * category : stores categories (id, name, desc, creation, update)
-    * image : stores images (id, name, desc, type, creation, update, category ID)
-    * tag : stores tags (id, name, creation date)
-    * image_tag : links images to tags by ids (Many to Many relation)
-*/
-
-CREATE TABLE IF NOT EXISTS category (
-    id INT PRIMARY KEY NOT NULL AUTO_INCREMENT,
-    name VARCHAR(255),
-    description TEXT,
-    created_at DATETIME,
-    updated_at DATETIME
-);
-
-CREATE TABLE IF NOT EXISTS image (
-    id INT PRIMARY KEY NOT NULL AUTO_INCREMENT,
-    name VARCHAR(255),
-    slug VARCHAR(255) UNIQUE,
-    description TEXT,
-    type VARCHAR(10),
-    created_at DATETIME,
-    updated_at DATETIME,
-    category_id INT, 
-    FOREIGN KEY (category_id) 
-        REFERENCES category(id)   
-        ON DELETE CASCADE 
-);
+    Starter sample data, loaded once the schema is migrated:
 
-CREATE TABLE IF NOT EXISTS tag (
-    id INT PRIMARY KEY NOT NULL AUTO_INCREMENT,
-    name VARCHAR(255),
-    created_at DATETIME,
-    updated_at DATETIME
-);
-
-CREATE TABLE IF NOT EXISTS image_tag (
-    image_id INT,
-    tag_id INT,
-    FOREIGN KEY (image_id) 
-        REFERENCES image(id)
-        ON DELETE CASCADE,
-    FOREIGN KEY (tag_id) 
-        REFERENCES tag(id)
-);
-
-
-/*
-    Starter sample data
+    docker-compose exec -T db mysql -ugallery -pgallery image_gallery < docker/data/sample_data.sql
     
     3 categories with ids 1 to 3
     7 images metadata with ids 1 to 7